@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"backend/core/store"
+)
+
+func (h *Handler) BlockUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	blockedID := p.ByName("user")
+
+	// Validate
+	if blockedID == userID {
+		writeStatus(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Insert, blocking twice is harmless
+	err := h.store.Users().Block(userID, blockedID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+	// Without Postgres notifications, tell the cache
+	if !h.notified {
+		h.blocks.set(blockedID, userID, true)
+	}
+
+	w.WriteHeader(200)
+}
+
+func (h *Handler) UnblockUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	blockedID := p.ByName("user")
+
+	// Delete
+	err := h.store.Users().Unblock(userID, blockedID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+	if !h.notified {
+		h.blocks.set(blockedID, userID, false)
+	}
+
+	w.WriteHeader(200)
+}
+
+func (h *Handler) GetBlockedUsers(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+
+	// Select, most recently blocked first
+	users, err := h.store.Users().Blocked(userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Shape
+	err = shapeUsers(h.store.Users(), userID, users)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// blockedBy reports whether blocker has blocked user
+func blockedBy(s store.UserStore, user string, blocker string) (bool, error) {
+	blockers, err := s.Blockers(user, []string{blocker})
+	return blockers[blocker], err
+}
@@ -0,0 +1,75 @@
+// +build integration
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestBlock(t *testing.T) {
+	db := connect()
+	defer db.Close()
+	h := NewHandler(db, nil)
+	r := NewRouter(h)
+
+	t.Run("Block", testBlock(db, r))
+}
+
+func testBlock(db *sql.DB, router http.Handler) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		users := []User{}
+		for i, phone := range []string{"+65 9999 5001", "+65 9999 5002"} {
+			w := serve(router, "POST", "/user", &User{PhoneNumber: phone, FirstName: "Block", LastName: []string{"Blocker", "Blocked"}[i]}, "")
+			assertCode(t, w, 200)
+			user := User{}
+			json.NewDecoder(w.Body).Decode(&user)
+			users = append(users, user)
+		}
+		blocker, blocked := users[0], users[1]
+		assertCode(t, serve(router, "PATCH", "/user", &User{Username: null.StringFrom("blocker5001"), FirstName: blocker.FirstName, LastName: blocker.LastName}, blocker.ID), 200)
+
+		w := serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom("Test Block")}, blocked.ID)
+		assertCode(t, w, 200)
+		conversation := Conversation{}
+		json.NewDecoder(w.Body).Decode(&conversation)
+
+		// Block
+		assertCode(t, serve(router, "PUT", "/user/block/"+blocker.ID, nil, blocker.ID), 400)
+		assertCode(t, serve(router, "PUT", "/user/block/u-nobody", nil, blocker.ID), 404)
+		assertCode(t, serve(router, "PUT", "/user/block/"+blocked.ID, nil, blocker.ID), 200)
+		assertCode(t, serve(router, "PUT", "/user/block/"+blocked.ID, nil, blocker.ID), 200)
+
+		w = serve(router, "GET", "/user/block", nil, blocker.ID)
+		assertCode(t, w, 200)
+		list := []User{}
+		json.NewDecoder(w.Body).Decode(&list)
+		if len(list) != 1 || list[0].ID != blocked.ID {
+			t.Errorf("Want %s blocked, got %v", blocked.ID, list)
+		}
+
+		// The blocked user can't find or add the blocker
+		assertCode(t, serve(router, "GET", "/user?phone_number=%2B6599995001", nil, blocked.ID), 404)
+		assertCode(t, serve(router, "GET", "/user/username/blocker5001", nil, blocked.ID), 404)
+		assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: blocker.PhoneNumber}, blocked.ID), 404)
+		assertNoDB(t, db, `SELECT * FROM contact WHERE "user" = $1 AND contact = $2`, blocked.ID, blocker.ID)
+		assertCode(t, serve(router, "POST", "/user/conversation/"+conversation.ID+"/member", &User{ID: blocker.ID}, blocked.ID), 404)
+		assertCode(t, serve(router, "PUT", "/user/dm/"+blocker.ID, nil, blocked.ID), 404)
+
+		// Others still can
+		assertCode(t, serve(router, "GET", "/user/username/blocker5001", nil, blocker.ID), 200)
+
+		// Unblock
+		assertCode(t, serve(router, "DELETE", "/user/block/"+blocked.ID, nil, blocker.ID), 200)
+		assertCode(t, serve(router, "DELETE", "/user/block/"+blocked.ID, nil, blocker.ID), 404)
+		assertCode(t, serve(router, "GET", "/user/username/blocker5001", nil, blocked.ID), 200)
+		assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: blocker.PhoneNumber}, blocked.ID), 200)
+
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"backend/core/store"
+)
+
+// Blocks caches who blocked the users with open subscriptions, kept current
+// by the block_new/block_delete triggers, or by BlockUser and UnblockUser
+// without Postgres.
+type Blocks struct {
+	*notifyCache
+}
+
+func NewBlocks(s store.Store) *Blocks {
+	return &Blocks{newNotifyCache(s.Users().BlockerIDs, "block_new", "block_delete", func(blocker, blocked string) (string, string) {
+		return blocked, blocker
+	}, 0)}
+}
+
+// Blocked reports whether blocker has blocked user. Only users acquired into
+// the cache are ever blocked.
+func (b *Blocks) Blocked(user, blocker string) bool {
+	return b.has(user, blocker)
+}
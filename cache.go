@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type cachedUser struct {
+	refs    int
+	keys    map[string]bool
+	removed map[string]time.Time
+
+	ready chan struct{} // closed once first loaded, with err
+	err   error
+
+	// Changes set while loads are in progress, to apply over what they load
+	loads   int
+	changes map[string]bool
+}
+
+// notifyCache caches a set of keys for each user with an open subscription,
+// such as their conversations, as loaded by load. With Postgres it is kept
+// current by the notifications of a table's triggers on the added and removed
+// channels, whose "<a>+<b>" payloads key turns into a user and key. Other
+// stores have their changes passed to set instead.
+type notifyCache struct {
+	load    func(user string) ([]string, error)
+	added   string
+	removed string
+	key     func(a, b string) (user string, key string)
+	grace   time.Duration // how long a removed key is still in the set
+
+	mu    sync.RWMutex
+	users map[string]*cachedUser
+}
+
+func newNotifyCache(load func(user string) ([]string, error), added, removed string, key func(a, b string) (string, string), grace time.Duration) *notifyCache {
+	return &notifyCache{
+		load:    load,
+		added:   added,
+		removed: removed,
+		key:     key,
+		grace:   grace,
+		users:   make(map[string]*cachedUser),
+	}
+}
+
+// Acquire loads the keys of a user into the cache, or waits for them to be
+// loaded if another subscription is loading them. Every Acquire that succeeds
+// must be followed by a Release once the subscription closes.
+func (c *notifyCache) Acquire(user string) error {
+	c.mu.Lock()
+	cached, ok := c.users[user]
+	if ok {
+		cached.refs += 1
+		c.mu.Unlock()
+		<-cached.ready
+		if cached.err != nil {
+			c.Release(user)
+		}
+		return cached.err
+	}
+	cached = &cachedUser{
+		refs:    1,
+		keys:    make(map[string]bool),
+		removed: make(map[string]time.Time),
+		ready:   make(chan struct{}),
+	}
+	c.users[user] = cached
+	c.mu.Unlock()
+
+	cached.err = c.refresh(user, cached)
+	close(cached.ready)
+	if cached.err != nil {
+		c.Release(user)
+	}
+	return cached.err
+}
+
+func (c *notifyCache) Release(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.users[user]; ok {
+		cached.refs -= 1
+		if cached.refs < 1 {
+			delete(c.users, user)
+		}
+	}
+}
+
+// has reports whether key is, or was less than grace ago, in the set of user.
+// Only users acquired into the cache have any keys.
+func (c *notifyCache) has(user, key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	cached, ok := c.users[user]
+	if !ok {
+		return false
+	}
+	if cached.keys[key] {
+		return true
+	}
+	if removed, ok := cached.removed[key]; ok && time.Since(removed) < c.grace {
+		return true
+	}
+	return false
+}
+
+// Listen follows the notifications. It blocks, so it should be run in its own
+// goroutine.
+func (c *notifyCache) Listen(conninfo string) {
+	listener := pq.NewListener(conninfo, 1*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Print(err)
+		}
+	})
+	if err := listener.Listen(c.added); err != nil {
+		log.Print(err)
+		return
+	}
+	if err := listener.Listen(c.removed); err != nil {
+		log.Print(err)
+		return
+	}
+
+	for n := range listener.NotificationChannel() {
+		// A nil notification means the connection was re-established, and
+		// notifications may have been lost in the meantime
+		if n == nil {
+			c.reload()
+			continue
+		}
+
+		a, b, ok := parsePairNotification(n.Extra)
+		if !ok {
+			log.Printf("invalid %s notification %s\n", n.Channel, n.Extra)
+			continue
+		}
+		user, key := c.key(a, b)
+		c.set(user, key, n.Channel == c.added)
+	}
+}
+
+// refresh loads the keys of user anew into cached. Keys set while loading
+// may or may not be in what is loaded, so they are applied over it.
+func (c *notifyCache) refresh(user string, cached *cachedUser) error {
+	c.mu.Lock()
+	if cached.loads == 0 {
+		cached.changes = make(map[string]bool)
+	}
+	cached.loads += 1
+	c.mu.Unlock()
+
+	loaded, err := c.load(user)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached.loads -= 1
+	changes := cached.changes
+	if cached.loads == 0 {
+		cached.changes = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]bool)
+	for _, key := range loaded {
+		keys[key] = true
+	}
+	for key, present := range changes {
+		if present {
+			keys[key] = true
+		} else {
+			delete(keys, key)
+		}
+	}
+	cached.keys = keys
+	return nil
+}
+
+func (c *notifyCache) reload() {
+	c.mu.RLock()
+	users := make(map[string]*cachedUser, len(c.users))
+	for user, cached := range c.users {
+		users[user] = cached
+	}
+	c.mu.RUnlock()
+
+	for user, cached := range users {
+		if err := c.refresh(user, cached); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// set adds key to, or removes it from, the set of user if they are cached
+func (c *notifyCache) set(user, key string, present bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.users[user]
+	if !ok {
+		return
+	}
+	if cached.changes != nil {
+		cached.changes[key] = present
+	}
+	if present {
+		cached.keys[key] = true
+		delete(cached.removed, key)
+		return
+	}
+	delete(cached.keys, key)
+	if c.grace > 0 {
+		cached.removed[key] = time.Now()
+	}
+
+	// Forget old removals
+	for k, removed := range cached.removed {
+		if time.Since(removed) >= c.grace {
+			delete(cached.removed, k)
+		}
+	}
+}
+
+// parsePairNotification splits a "<a>+<b>" payload, such as the
+// "<user>+<conversation>" of member notifications. The columns are BYTEA, so
+// CONCAT may hand them to us hex-encoded.
+func parsePairNotification(payload string) (string, string, bool) {
+	parts := strings.SplitN(payload, "+", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	a, ok1 := decodeBytea(parts[0])
+	b, ok2 := decodeBytea(parts[1])
+	return a, b, ok1 && ok2
+}
+
+func decodeBytea(s string) (string, bool) {
+	if !strings.HasPrefix(s, `\x`) {
+		return s, true
+	}
+	b, err := hex.DecodeString(s[2:])
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
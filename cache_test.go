@@ -0,0 +1,103 @@
+// +build unit
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotifyCache(t *testing.T) {
+	c := newNotifyCache(nil, "pair_new", "pair_delete", func(a, b string) (string, string) {
+		return b, a
+	}, time.Minute)
+	c.users["u-1"] = &cachedUser{refs: 2, keys: map[string]bool{"k-1": true}, removed: make(map[string]time.Time)}
+
+	// Only cached users are updated
+	c.set("u-1", "k-2", true)
+	c.set("u-2", "k-2", true)
+	if !c.has("u-1", "k-1") || !c.has("u-1", "k-2") || c.has("u-2", "k-2") {
+		t.Errorf("Want the keys of u-1 only, got %v", c.users)
+	}
+
+	// Removed keys linger for the grace period
+	c.set("u-1", "k-1", false)
+	if !c.has("u-1", "k-1") {
+		t.Error("Want k-1 kept for the grace period")
+	}
+	c.users["u-1"].removed["k-1"] = time.Now().Add(-time.Minute)
+	if c.has("u-1", "k-1") {
+		t.Error("Want k-1 gone after the grace period")
+	}
+
+	// Until every subscription is released
+	c.Release("u-1")
+	if !c.has("u-1", "k-2") {
+		t.Error("Want u-1 cached until released twice")
+	}
+	c.Release("u-1")
+	if c.has("u-1", "k-2") {
+		t.Error("Want u-1 gone once released")
+	}
+}
+
+func TestNotifyCacheAcquire(t *testing.T) {
+	loading := make(chan bool)
+	loaded := make(chan bool)
+	c := newNotifyCache(func(user string) ([]string, error) {
+		loading <- true
+		<-loaded
+		return []string{"k-1", "k-2"}, nil
+	}, "pair_new", "pair_delete", func(a, b string) (string, string) {
+		return a, b
+	}, 0)
+
+	errs := make(chan error, 2)
+	go func() { errs <- c.Acquire("u-1") }()
+	<-loading
+
+	// Changes while loading outlast what was loaded before them
+	c.set("u-1", "k-2", false)
+	c.set("u-1", "k-3", true)
+
+	// Other subscriptions wait for the load
+	go func() { errs <- c.Acquire("u-1") }()
+	select {
+	case err := <-errs:
+		t.Fatalf("Want Acquire to wait for the load, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(loaded)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !c.has("u-1", "k-1") || c.has("u-1", "k-2") || !c.has("u-1", "k-3") {
+		t.Errorf("Want k-1 and k-3, got %v", c.users["u-1"].keys)
+	}
+	if c.users["u-1"].refs != 2 || c.users["u-1"].changes != nil {
+		t.Errorf("Want two subscriptions and no load in progress, got %v", c.users["u-1"])
+	}
+}
+
+func TestParsePairNotification(t *testing.T) {
+	tests := []struct {
+		payload      string
+		user         string
+		conversation string
+		ok           bool
+	}{
+		{"u-1+c-1", "u-1", "c-1", true},
+		{`\x752d31+\x632d31`, "u-1", "c-1", true},
+		{`\x752d3+\x632d31`, "", "c-1", false},
+		{"u-1", "", "", false},
+	}
+
+	for _, test := range tests {
+		user, conversation, ok := parsePairNotification(test.payload)
+		if user != test.user || conversation != test.conversation || ok != test.ok {
+			t.Errorf("parsePairNotification(%q) = %q, %q, %v, want %q, %q, %v", test.payload, user, conversation, ok, test.user, test.conversation, test.ok)
+		}
+	}
+}
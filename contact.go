@@ -2,10 +2,12 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+
+	"backend/core/event"
+	"backend/core/store"
 )
 
 func (h *Handler) CreateContact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -15,66 +17,80 @@ func (h *Handler) CreateContact(w http.ResponseWriter, r *http.Request, p httpro
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&contactPhone)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeError(w, r, malformed(err))
 		return
 	}
 
 	// Validate
-	phone, err := ParsePhone(contactPhone.PhoneNumber)
-	if err != nil || len(contactPhone.PhoneNumber) < 1 {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	err = validateContact(&contactPhone)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
+	phone, _ := ParsePhone(contactPhone.PhoneNumber)
 
 	// Generate ID (just in case)
 	id := "u-" + RandomHex()
 
-	// Create contact if not exists, returning the id regardless
-	contact := User{}
-	err = h.db.QueryRow(`
-		INSERT INTO "user" (id, username, bio, profile_pic, first_name, last_name, phone_number)
-			VALUES ($1, '', '', '', '', '', $2)
-			ON CONFLICT(phone_number)
-			DO UPDATE SET phone_number=EXCLUDED.phone_number
-			RETURNING id, username, bio, profile_pic, first_name, last_name, phone_number
-	`, id, phone).Scan(&contact.ID, &contact.Username, &contact.Bio, &contact.ProfilePic, &contact.FirstName, &contact.LastName, &contact.PhoneNumber)
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Create contact if not exists, returning the user regardless
+	users, err := tx.Users().Ensure([]User{{ID: id, PhoneNumber: phone}})
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
+		return
+	}
+	contact := users[0]
+
+	// Users can't be added as a contact by someone they blocked, nor find
+	// them, so it looks like there is nobody to add
+	blocked, err := blockedBy(tx.Users(), userID, contact.ID)
+	switch {
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case blocked:
+		writeStatus(w, r, http.StatusNotFound)
 		return
 	}
 
 	// Insert
-	_, err = h.db.Exec(`
-		INSERT INTO contact ("user", contact) VALUES ($1, $2)
-	`, userID, contact.ID)
+	err = tx.Contacts().Create(userID, contact.ID)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
 		return
 	}
 
 	// Publish NATs
-	if h.nc != nil {
-		contact := Contact{
-			UserA: userID,
-			UserB: contact.ID,
-		}
-		contactString, err := json.Marshal(&contact)
-		if err == nil {
-			updateMsg := UpdateMsg{
-				Type: "add",
-				Data: string(contactString),
-			}
-			updateMsgString, err := json.Marshal(&updateMsg)
-			if err == nil {
-				h.nc.Publish("contact", updateMsgString)
-			}
-		}
+	err = tx.Enqueue(event.ContactCreated, userID, &Contact{
+		UserA: userID,
+		UserB: contact.ID,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	// Shape
+	err = shapeUser(h.store.Users(), userID, &contact)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
 	// Respond
-	w.WriteHeader(200)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(contact)
 }
@@ -82,35 +98,284 @@ func (h *Handler) CreateContact(w http.ResponseWriter, r *http.Request, p httpro
 func (h *Handler) GetContacts(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	// Parse
 	userID := r.Context().Value("user").(string)
+	page, err := ParsePage(r, 3)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Select, by name
+	var after *User
+	if !page.First() {
+		after = &User{FirstName: page.Key(0, ""), LastName: page.Key(1, ""), ID: page.Key(2, "")}
+	}
+	users, err := h.store.Contacts().List(userID, after, page.Fetch())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	// Response object
 	contacts := make([]User, 0)
+	for _, contact := range users {
+		if page.More(len(contacts) + 1) {
+			last := contacts[len(contacts)-1]
+			SetNext(w, r, page, last.FirstName, last.LastName, last.ID)
+			break
+		}
+		contacts = append(contacts, contact)
+	}
+
+	// Shape
+	err = shapeUsers(h.store.Users(), userID, contacts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(contacts)
+}
+
+func (h *Handler) GetContact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	contactID := p.ByName("contact")
 
 	// Select
-	rows, err := h.db.Query(`
-		SELECT id, username, bio, profile_pic, first_name, last_name, phone_number FROM "user"
-		INNER JOIN contact
-		ON contact.contact = "user".id AND contact.user = $1
-	`, userID)
+	contact, err := h.store.Contacts().Get(userID, contactID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Shape
+	err = shapeUser(h.store.Users(), userID, &contact)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(contact)
+}
+
+func (h *Handler) DeleteContact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	contactID := p.ByName("contact")
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Delete, only from the user's own contacts
+	err = tx.Contacts().Delete(userID, contactID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs
+	err = tx.Enqueue(event.ContactDeleted, userID, &Contact{
+		UserA: userID,
+		UserB: contactID,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
 		return
 	}
-	defer rows.Close()
+	h.outbox.Wake()
+
+	w.WriteHeader(200)
+}
 
-	// Scan
-	for rows.Next() {
-		contact := User{}
-		if err := rows.Scan(&contact.ID, &contact.Username, &contact.Bio, &contact.ProfilePic, &contact.FirstName, &contact.LastName, &contact.PhoneNumber); err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			log.Print(err)
-			return
+func (h *Handler) GetContactConversations(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	contactID := p.ByName("contact")
+
+	// Check
+	contact, err := h.store.Contacts().Exists(userID, contactID)
+	switch {
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case !contact:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	}
+
+	// Select conversations both are members of
+	conversations, err := h.store.Conversations().Shared(userID, contactID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversations)
+}
+
+// Most phone numbers synced in one request
+const maxSyncContacts = 5000
+
+func (h *Handler) SyncContacts(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	sync := ContactSync{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&sync)
+	if err != nil {
+		writeError(w, r, malformed(err))
+		return
+	}
+
+	// Validate
+	err = validateContactSync(&sync)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Normalize, keeping each number once
+	results := make([]ContactSyncResult, len(sync.PhoneNumbers))
+	phones := make([]string, 0)
+	seen := make(map[string]bool)
+	for i, raw := range sync.PhoneNumbers {
+		results[i] = ContactSyncResult{PhoneNumber: raw, Status: ContactSyncInvalid}
+		phone, err := ParsePhoneRegion(raw, sync.DefaultRegion)
+		if err != nil || len(raw) < 1 {
+			continue
+		}
+		results[i].Normalized = phone
+		if !seen[phone] {
+			seen[phone] = true
+			phones = append(phones, phone)
+		}
+	}
+
+	// Generate IDs (just in case)
+	placeholders := make([]User, len(phones))
+	for i, phone := range phones {
+		placeholders[i] = User{ID: "u-" + RandomHex(), PhoneNumber: phone}
+	}
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Create users if not exists, returning them regardless
+	ensured, err := tx.Users().Ensure(placeholders)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	users := make(map[string]string)
+	registered := make(map[string]bool)
+	ids := make([]string, 0, len(ensured))
+	for _, user := range ensured {
+		users[user.PhoneNumber] = user.ID
+		registered[user.PhoneNumber] = user.Registered()
+		ids = append(ids, user.ID)
+	}
+
+	// Users can't be added as a contact by someone they blocked
+	blockers, err := tx.Users().Blockers(userID, ids)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Insert, contacts that already exist are left out of the result
+	contactIDs := make([]string, 0, len(users))
+	for _, id := range ids {
+		if id != userID && !blockers[id] {
+			contactIDs = append(contactIDs, id)
+		}
+	}
+	inserted, err := tx.Contacts().CreateAll(userID, contactIDs)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	added := make(map[string]bool)
+	for _, id := range inserted {
+		added[id] = true
+	}
+
+	// Publish NATs
+	contacts := make([]interface{}, 0, len(added))
+	for id := range added {
+		contacts = append(contacts, &Contact{
+			UserA: userID,
+			UserB: id,
+		})
+	}
+	err = tx.Enqueue(event.ContactCreated, userID, contacts...)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	// Results, numbers supplied more than once share one
+	for i, result := range results {
+		id, ok := users[result.Normalized]
+		if !ok {
+			continue
+		}
+		switch {
+		case id == userID:
+			// Users can't be their own contact
+			results[i].Status = ContactSyncSelf
+			continue
+		case blockers[id]:
+			// Or that of someone who blocked them, which isn't told apart
+			results[i].Status = ContactSyncUnmatched
+			continue
+		}
+		results[i].User = id
+		switch {
+		case !added[id]:
+			results[i].Status = ContactSyncContact
+		case registered[result.Normalized]:
+			results[i].Status = ContactSyncRegistered
+		default:
+			results[i].Status = ContactSyncNew
 		}
-		contacts = append(contacts, contact)
 	}
 
 	// Respond
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(contacts)
+	json.NewEncoder(w).Encode(results)
 }
@@ -8,9 +8,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"gopkg.in/guregu/null.v3"
 )
 
 func TestContact(t *testing.T) {
@@ -23,6 +25,9 @@ func TestContact(t *testing.T) {
 
 	t.Run("Create", testCreateContact(db, r, users))
 	t.Run("Get", testGetContacts(db, r, users))
+	t.Run("Single", testContact(db, r, users))
+	t.Run("Sync", testSyncContacts(db, r, users))
+	t.Run("Paginate", testPaginateContacts(db, r, users))
 }
 
 func setupContactUsers(t *testing.T, db *sql.DB, router http.Handler) []User {
@@ -93,6 +98,9 @@ func testCreateContact(db *sql.DB, router http.Handler, users []User) func(t *te
 
 		router.ServeHTTP(w, r)
 		assertCode(t, w, 200)
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Want a JSON content type, got %q", contentType)
+		}
 
 		// Assert
 		got, want := User{}, users[0]
@@ -169,3 +177,178 @@ func testGetContacts(db *sql.DB, router http.Handler, users []User) func(t *test
 
 	}
 }
+
+func testContact(db *sql.DB, router http.Handler, users []User) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		w := serve(router, "POST", "/user", &User{PhoneNumber: "+65 9999 1003", FirstName: "ContactOwner", LastName: "User"}, "")
+		assertCode(t, w, 200)
+		owner := User{}
+		json.NewDecoder(w.Body).Decode(&owner)
+		contact := users[0]
+		target := "/user/contact/" + contact.ID
+
+		assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: contact.PhoneNumber}, owner.ID), 200)
+
+		w = serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom("Test Shared")}, owner.ID)
+		assertCode(t, w, 200)
+		shared := Conversation{}
+		json.NewDecoder(w.Body).Decode(&shared)
+		assertCode(t, serve(router, "POST", "/user/conversation/"+shared.ID+"/member", &User{ID: contact.ID}, owner.ID), 200)
+		w = serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom("Test Not Shared")}, owner.ID)
+		assertCode(t, w, 200)
+
+		// Get
+		w = serve(router, "GET", target, nil, owner.ID)
+		assertCode(t, w, 200)
+		got := User{}
+		json.NewDecoder(w.Body).Decode(&got)
+		if diff := cmp.Diff(got, contact); len(diff) != 0 {
+			t.Error(diff)
+		}
+		assertCode(t, serve(router, "GET", target, nil, users[1].ID), 404)
+
+		// Shared conversations
+		w = serve(router, "GET", target+"/conversation", nil, owner.ID)
+		assertCode(t, w, 200)
+		conversations := make([]Conversation, 0)
+		json.NewDecoder(w.Body).Decode(&conversations)
+		if len(conversations) != 1 || conversations[0].ID != shared.ID {
+			t.Errorf("Want only conversation %s, got %v", shared.ID, conversations)
+		}
+		assertCode(t, serve(router, "GET", target+"/conversation", nil, users[1].ID), 404)
+
+		// Delete
+		assertCode(t, serve(router, "DELETE", target, nil, users[1].ID), 404)
+		assertCode(t, serve(router, "DELETE", target, nil, owner.ID), 200)
+		assertNoDB(t, db, `SELECT * FROM contact WHERE "user" = $1 AND contact = $2`, owner.ID, contact.ID)
+		assertCode(t, serve(router, "GET", target, nil, owner.ID), 404)
+		assertCode(t, serve(router, "DELETE", target, nil, owner.ID), 404)
+
+	}
+}
+
+func testSyncContacts(db *sql.DB, router http.Handler, users []User) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		w := serve(router, "POST", "/user", &User{PhoneNumber: "+65 9999 1004", FirstName: "ContactOwner", LastName: "User"}, "")
+		assertCode(t, w, 200)
+		owner := User{}
+		json.NewDecoder(w.Body).Decode(&owner)
+		assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: users[0].PhoneNumber}, owner.ID), 200)
+		assertCode(t, serve(router, "PUT", "/user/block/"+owner.ID, nil, users[2].ID), 200)
+
+		// Test
+		sync := &ContactSync{
+			DefaultRegion: "sg",
+			PhoneNumbers: []string{
+				users[0].PhoneNumber,
+				users[1].PhoneNumber,
+				"+65 9999 1101",
+				"9999 1102",
+				"not a phone number",
+				"+6599990002",
+				owner.PhoneNumber,
+				users[2].PhoneNumber,
+			},
+		}
+		w = serve(router, "POST", "/user/contact/sync", sync, owner.ID)
+		assertCode(t, w, 200)
+
+		// Assert
+		results := make([]ContactSyncResult, 0)
+		json.NewDecoder(w.Body).Decode(&results)
+		want := []string{
+			ContactSyncContact,
+			ContactSyncRegistered,
+			ContactSyncNew,
+			ContactSyncNew,
+			ContactSyncInvalid,
+			ContactSyncRegistered,
+			ContactSyncSelf,
+			ContactSyncUnmatched,
+		}
+		if len(results) != len(want) {
+			t.Fatalf("Want %d results, got %d", len(want), len(results))
+		}
+		for i, result := range results {
+			if result.Status != want[i] {
+				t.Errorf("Want %s to be %s, got %s", result.PhoneNumber, want[i], result.Status)
+			}
+		}
+		if results[1].User != users[1].ID || results[5].User != users[1].ID {
+			t.Errorf("Want both spellings of %s to be %s, got %s and %s", users[1].PhoneNumber, users[1].ID, results[1].User, results[5].User)
+		}
+		if results[3].Normalized != "+65 9999 1102" {
+			t.Errorf("Want a local number normalized in the default region, got %s", results[3].Normalized)
+		}
+		for _, result := range results[1:4] {
+			assertDB(t, db, `SELECT * FROM contact WHERE "user" = $1 AND contact = $2`, owner.ID, result.User)
+		}
+		if results[6].User != "" || results[7].User != "" {
+			t.Errorf("Want no user for the owner's own number or a blocker's, got %s and %s", results[6].User, results[7].User)
+		}
+		assertNoDB(t, db, `SELECT * FROM contact WHERE "user" = $1 AND contact = $2`, owner.ID, users[2].ID)
+
+		// Syncing again changes nothing
+		w = serve(router, "POST", "/user/contact/sync", sync, owner.ID)
+		assertCode(t, w, 200)
+		json.NewDecoder(w.Body).Decode(&results)
+		for i, result := range results[:6] {
+			if result.Status != ContactSyncContact && result.Status != ContactSyncInvalid {
+				t.Errorf("Want %s to already be a contact, got %s", result.PhoneNumber, result.Status)
+			}
+			if i == 4 && result.Status != ContactSyncInvalid {
+				t.Errorf("Want %s to stay invalid, got %s", result.PhoneNumber, result.Status)
+			}
+		}
+		if results[6].Status != ContactSyncSelf || results[7].Status != ContactSyncUnmatched {
+			t.Errorf("Want the owner's own number and a blocker's left out again, got %s and %s", results[6].Status, results[7].Status)
+		}
+
+	}
+}
+
+func testPaginateContacts(db *sql.DB, router http.Handler, users []User) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		w := serve(router, "POST", "/user", &User{PhoneNumber: "+65 9999 1005", FirstName: "ContactOwner", LastName: "User"}, "")
+		assertCode(t, w, 200)
+		owner := User{}
+		json.NewDecoder(w.Body).Decode(&owner)
+		for _, user := range users {
+			assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: user.PhoneNumber}, owner.ID), 200)
+		}
+
+		// Test, following the Link header two at a time
+		got := []User{}
+		target := "/user/contact?limit=2"
+		pages := 0
+		for target != "" {
+			w := serve(router, "GET", target, nil, owner.ID)
+			assertCode(t, w, 200)
+			page := []User{}
+			json.NewDecoder(w.Body).Decode(&page)
+			got = append(got, page...)
+			pages += 1
+
+			target = ""
+			if link := w.Header().Get("Link"); link != "" {
+				target = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			}
+		}
+
+		// Assert
+		if pages != 2 {
+			t.Errorf("Want 2 pages, got %d", pages)
+		}
+		if diff := cmp.Diff(got, users); len(diff) != 0 {
+			t.Error(diff)
+		}
+		assertCode(t, serve(router, "GET", "/user/contact?cursor=nope", nil, owner.ID), 400)
+
+	}
+}
@@ -1,14 +1,24 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"gopkg.in/guregu/null.v3"
+
+	"backend/core/event"
+	"backend/core/store"
 )
 
+var errAlreadyMember = &Error{Status: http.StatusConflict, Code: CodeAlreadyMember, Message: "user is already a member of the conversation"}
+
 func (h *Handler) CreateConversation(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	// Parse
 	userID := r.Context().Value("user").(string)
@@ -16,61 +26,61 @@ func (h *Handler) CreateConversation(w http.ResponseWriter, r *http.Request, p h
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&conversation)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeError(w, r, malformed(err))
+		return
+	}
+
+	// Validate
+	err = validateConversation(&conversation)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Generate ID
 	id := "c-" + RandomHex()
 	conversation.ID = id
+	conversation.DM = false // DMs are created through GetOrCreateDM
+	conversation.Role = RoleOwner
+	conversation.Archived = false
+	conversation.MutedUntil = null.Time{}
+	conversation.Notifications = NotifyAll
 
 	// Log
 	log.Print(conversation)
 
 	// Insert
-	tx, err := h.db.Begin()
+	tx, err := h.store.Begin()
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
 		return
 	}
+	defer tx.Rollback()
 
-	// Conversation
-	_, err1 := tx.Exec(`
-		INSERT INTO "conversation" (id, title, picture) VALUES ($1, $2, $3)
-	`, conversation.ID, conversation.Title, conversation.Picture)
-	// First member
-	_, err2 := tx.Exec(`
-		INSERT INTO member ("user", "conversation") VALUES ($1, $2)
-	`, userID, conversation.ID)
-	if err1 != nil || err2 != nil {
-		// likely 404...
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-		log.Print(err1, err2)
+	// Conversation, with its first member, who owns it
+	err = tx.Conversations().Create(&conversation, userID)
+	switch {
+	case err == store.ErrReference: // the caller has no user
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
 		return
 	}
 
-	err = tx.Commit()
+	// Publish NATs
+	err = tx.Enqueue(event.ConversationCreated, userID, &conversation)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
 		return
 	}
 
-	// Publish NATs
-	if h.nc != nil {
-		conversationString, err := json.Marshal(&conversation)
-		if err == nil {
-			updateMsg := UpdateMsg{
-				Type: "add",
-				Data: string(conversationString),
-			}
-			updateMsgString, err := json.Marshal(&updateMsg)
-			if err == nil {
-				h.nc.Publish("conversation", updateMsgString)
-			}
-		}
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
+	h.outbox.Wake()
 
 	// Respond
 	w.Header().Set("Content-Type", "application/json")
@@ -78,32 +88,50 @@ func (h *Handler) CreateConversation(w http.ResponseWriter, r *http.Request, p h
 }
 
 func (h *Handler) GetConversations(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Looking up by members
+	if _, ok := r.URL.Query()["members"]; ok {
+		h.GetConversationsByMembers(w, r, p)
+		return
+	}
+
 	// Parse
 	userID := r.Context().Value("user").(string)
+	page, err := ParsePage(r, 3)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	// Response object
-	conversations := make([]Conversation, 0)
+	// Validate cursor, pinned first, then most recently active
+	var after *Conversation
+	if !page.First() {
+		after = &Conversation{ID: page.Key(2, "")}
+		after.Pinned, err = strconv.ParseBool(page.Key(0, ""))
+		if err != nil {
+			writeError(w, r, errInvalidPage)
+			return
+		}
+		after.ActiveAt, err = time.Parse(time.RFC3339Nano, page.Key(1, ""))
+		if err != nil {
+			writeError(w, r, errInvalidPage)
+			return
+		}
+	}
 
 	// Select
-	rows, err := h.db.Query(`
-		SELECT "conversation".id, "conversation".title, "conversation".picture, member.pinned
-		FROM "conversation", member
-		WHERE member.conversation = "conversation".id AND member.user = $1
-	`, userID)
+	rows, err := h.store.Conversations().List(userID, after, page.Fetch())
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
 		return
 	}
-	defer rows.Close()
 
-	// Scan
-	for rows.Next() {
-		conversation := Conversation{}
-		if err := rows.Scan(&conversation.ID, &conversation.Title, &conversation.Picture, &conversation.Pinned); err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			log.Print(err)
-			return
+	// Response object
+	conversations := make([]Conversation, 0)
+	for _, conversation := range rows {
+		if page.More(len(conversations) + 1) {
+			last := conversations[len(conversations)-1]
+			SetNext(w, r, page, strconv.FormatBool(last.Pinned), last.ActiveAt.Format(time.RFC3339Nano), last.ID)
+			break
 		}
 		conversations = append(conversations, conversation)
 	}
@@ -118,23 +146,14 @@ func (h *Handler) GetConversation(w http.ResponseWriter, r *http.Request, p http
 	userID := r.Context().Value("user").(string)
 	conversationID := p.ByName("conversation")
 
-	// Response object
-	conversation := Conversation{}
-
 	// Select
-	err := h.db.QueryRow(`
-		SELECT "conversation".id, "conversation".title, "conversation".picture, member.pinned
-		FROM "conversation", member
-		WHERE member.conversation = "conversation".id AND member.user = $1 AND member.conversation = $2
-	`, userID, conversationID).Scan(&conversation.ID, &conversation.Title, &conversation.Picture, &conversation.Pinned)
-
+	conversation, err := h.store.Conversations().Get(userID, conversationID)
 	switch {
-	case err == sql.ErrNoRows:
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
 		return
 	case err != nil:
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
 		return
 	}
 
@@ -151,56 +170,62 @@ func (h *Handler) UpdateConversation(w http.ResponseWriter, r *http.Request, p h
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&conversation)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeError(w, r, malformed(err))
+		return
+	}
+
+	// Validate
+	err = validateConversation(&conversation)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
+	defer tx.Rollback()
 
 	// Check
-	var conversationID2 string
-	err = h.db.QueryRow(`
-		SELECT id FROM "conversation"
-		INNER JOIN member
-		ON member.conversation = "conversation".id AND member.user = $1 AND member.conversation = $2
-	`, userID, conversationID).Scan(&conversationID2)
+	role, err := tx.Conversations().Role(userID, conversationID)
 	switch {
-	case err == sql.ErrNoRows:
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
 		return
 	case err != nil:
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
+		return
+	case !canAdministrate(role):
+		writeStatus(w, r, http.StatusForbidden)
 		return
 	}
 
 	// Update
 	if conversation.Title.Valid {
-		_, err = h.db.Exec(`
-			UPDATE "conversation"
-			SET title = $2, picture = $3
-			WHERE id = $1
-		`, conversationID, conversation.Title, conversation.Picture)
+		err = tx.Conversations().Update(conversationID, conversation.Title, conversation.Picture)
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			log.Print(err)
+			writeError(w, r, err)
 			return
 		}
 	}
 
 	// Publish NATs
-	if h.nc != nil {
-		conversationString, err := json.Marshal(&conversation)
-		if err == nil {
-			updateMsg := UpdateMsg{
-				Type: "update",
-				Data: string(conversationString),
-			}
-			updateMsgString, err := json.Marshal(&updateMsg)
-			if err == nil {
-				h.nc.Publish("conversation", updateMsgString)
-			}
-		}
+	conversation.ID = conversationID
+	err = tx.Enqueue(event.ConversationUpdated, userID, &conversation)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
 	w.WriteHeader(200)
 }
 
@@ -209,91 +234,123 @@ func (h *Handler) DeleteConversation(w http.ResponseWriter, r *http.Request, p h
 	conversationID := p.ByName("conversation")
 
 	// Delete
-	tx, err := h.db.Begin()
+	tx, err := h.store.Begin()
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
 		return
 	}
+	defer tx.Rollback()
 
 	// Check
-	var conversationID2 string
-	err = h.db.QueryRow(`
-		SELECT id FROM "conversation"
-		INNER JOIN member
-		ON member.conversation = "conversation".id AND member.user = $1 AND member.conversation = $2
-	`, userID, conversationID).Scan(&conversationID2)
+	role, err := tx.Conversations().Role(userID, conversationID)
 	switch {
-	case err == sql.ErrNoRows:
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
 		return
 	case err != nil:
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
+		return
+	case role != RoleOwner:
+		writeStatus(w, r, http.StatusForbidden)
 		return
 	}
 
-	// Users in Conversation
-	_, err1 := tx.Exec(`
-		DELETE FROM "member" WHERE "conversation" = $1
-	`, conversationID)
-	// Conversation
-	_, err2 := tx.Exec(`
-		DELETE FROM "conversation" WHERE "id" = $1
-	`, conversationID)
+	// Delete softly, members stay so the conversation can be restored until
+	// the purger removes it for good
+	err = tx.Conversations().Trash(conversationID, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	if err1 != nil || err2 != nil {
-		// likely 404...
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-		log.Print(err1, err2)
+	// Publish NATs
+	err = tx.Enqueue(event.ConversationTrashed, userID, &Conversation{
+		ID: conversationID,
+	})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	w.WriteHeader(200)
+}
+
+func (h *Handler) RestoreConversation(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Check, Role hides deleted conversations
+	member, err := tx.Conversations().Member(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case member.Role != RoleOwner:
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	// Update, only within the retention window
+	conversation, err := tx.Conversations().Restore(conversationID, conversationRetention)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
 		return
 	}
 
 	// Publish NATs
-	if h.nc != nil {
-		conversation := Conversation{
-			ID: conversationID,
-		}
-		conversationString, err := json.Marshal(&conversation)
-		if err == nil {
-			updateMsg := UpdateMsg{
-				Type: "delete",
-				Data: string(conversationString),
-			}
-			updateMsgString, err := json.Marshal(&updateMsg)
-			if err == nil {
-				h.nc.Publish("conversation", updateMsgString)
-			}
-		}
+	err = tx.Enqueue(event.ConversationRestored, userID, &conversation)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
+	h.outbox.Wake()
 
 	w.WriteHeader(200)
 }
 
 func (h *Handler) CreateConversationMember(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	// Parse
-	// We don't need the user ID here because when we first create a conversation, it should have no members
-	// TODO: conversations should have conversation owners?
-	//userID := r.Context().Value("user").(string)
+	userID := r.Context().Value("user").(string)
 	conversationID := p.ByName("conversation")
 	member := User{}
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&member)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeError(w, r, malformed(err))
 		return
 	}
 
 	// Validate
 	if len(member.ID) < 1 {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeError(w, r, invalid(FieldError{"id", FieldRequired, "is required"}))
 		return
 	}
 
@@ -302,36 +359,97 @@ func (h *Handler) CreateConversationMember(w http.ResponseWriter, r *http.Reques
 
 	// TODO: When we need stronger constraints, add some policy around existing conversations with a title set
 
-	// Insert
-	_, err = h.db.Exec(`
-		INSERT INTO member ("user", "conversation") VALUES ($2, $1)
-	`, conversationID, member.ID)
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Check
+	role, err := tx.Conversations().Role(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case !canAdministrate(role):
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	// DMs stay between their two users
+	conversation, err := tx.Conversations().Get(userID, conversationID)
+	switch {
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case conversation.DM:
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	// Users can't be added by someone they blocked, who looks as if they
+	// don't exist
+	blocked, err := blockedBy(tx.Users(), userID, member.ID)
+	switch {
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case blocked:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	}
+
+	// Users who aren't contacts of the admin are invited instead, and only
+	// join once they accept
+	contact, err := tx.Contacts().Exists(userID, member.ID)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
+		return
+	}
+	if !contact {
+		h.inviteMember(w, r, tx, userID, conversationID, member.ID)
+		return
+	}
+
+	// Insert
+	err = tx.Conversations().AddMember(Member{
+		User:         member.ID,
+		Conversation: conversationID,
+		Pinned:       false, // default
+		Role:         RoleMember,
+	})
+	switch {
+	case err == store.ErrConflict:
+		writeError(w, r, errAlreadyMember)
+		return
+	case err != nil:
+		writeError(w, r, err)
 		return
 	}
 
 	// Publish NATs
-	if h.nc != nil {
-		member := Member{
-			User:         member.ID,
-			Conversation: conversationID,
-			Pinned:       false, // default
-		}
-		memberString, err := json.Marshal(&member)
-		if err == nil {
-			updateMsg := UpdateMsg{
-				Type: "add",
-				Data: string(memberString),
-			}
-			updateMsgString, err := json.Marshal(&updateMsg)
-			if err == nil {
-				h.nc.Publish("member", updateMsgString)
-			}
-		}
+	err = tx.Enqueue(event.MemberCreated, userID, &Member{
+		User:         member.ID,
+		Conversation: conversationID,
+		Pinned:       false, // default
+		Role:         RoleMember,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
 	// Respond
 	//w.Header().Set("Content-Type", "application/json")
 	//json.NewEncoder(w).Encode(member)
@@ -342,36 +460,41 @@ func (h *Handler) GetConversationMembers(w http.ResponseWriter, r *http.Request,
 	// Parse
 	userID := r.Context().Value("user").(string)
 	conversationID := p.ByName("conversation")
+	page, err := ParsePage(r, 3)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Select, by name
+	var after *User
+	if !page.First() {
+		after = &User{FirstName: page.Key(0, ""), LastName: page.Key(1, ""), ID: page.Key(2, "")}
+	}
+	members, err := h.store.Conversations().Members(userID, conversationID, after, page.Fetch())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	// Response object
 	users := make([]User, 0)
-
-	// Select
-	rows, err := h.db.Query(`
-		SELECT "user".id, "user".username, "user".bio, "user".profile_pic, "user".first_name, "user".last_name, "user".phone_number FROM "user"
-		INNER JOIN member m ON "user".id = m.user AND "user".id != $1
-		INNER JOIN conversation ON "conversation".id = m.conversation
-		INNER JOIN member
-		ON member.conversation = "conversation".id AND member.user = $1 AND member.conversation = $2
-	`, userID, conversationID)
-	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
-		return
-	}
-	defer rows.Close()
-
-	// Scan
-	for rows.Next() {
-		user := User{}
-		if err := rows.Scan(&user.ID, &user.Username, &user.Bio, &user.ProfilePic, &user.FirstName, &user.LastName, &user.PhoneNumber); err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			log.Print(err)
-			return
+	for _, user := range members {
+		if page.More(len(users) + 1) {
+			last := users[len(users)-1]
+			SetNext(w, r, page, last.FirstName, last.LastName, last.ID)
+			break
 		}
 		users = append(users, user)
 	}
 
+	// Shape
+	err = shapeUsers(h.store.Users(), userID, users)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
 	// Respond
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(users)
@@ -381,43 +504,36 @@ func (h *Handler) PinConversation(w http.ResponseWriter, r *http.Request, p http
 	conversationID := p.ByName("conversation")
 	userID := r.Context().Value("user").(string)
 
-	// Check relation exists
-	var exists int
-	err := h.db.QueryRow(`SELECT 1 FROM member WHERE "user" = $1 AND "conversation" = $2`, userID, conversationID).Scan(&exists)
-	if err == sql.ErrNoRows {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Update relation, if it exists
+	member, err := tx.Conversations().SetPinned(userID, conversationID, true)
+	if err == store.ErrNotFound {
+		writeStatus(w, r, http.StatusNotFound)
 		return
 	} else if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	// Update relation
-	_, err = h.db.Exec(`UPDATE "member" SET "pinned" = TRUE WHERE "user" = $1 AND "conversation" = $2`, userID, conversationID)
+	// Publish NATs
+	err = tx.Enqueue(event.MemberUpdated, userID, &member)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	// Publish NATs
-	if h.nc != nil {
-		member := Member{
-			User:         userID,
-			Conversation: conversationID,
-			Pinned:       true,
-		}
-		memberString, err := json.Marshal(&member)
-		if err == nil {
-			updateMsg := UpdateMsg{
-				Type: "update",
-				Data: string(memberString),
-			}
-			updateMsgString, err := json.Marshal(&updateMsg)
-			if err == nil {
-				h.nc.Publish("member", updateMsgString)
-			}
-		}
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
+	h.outbox.Wake()
 
 	w.WriteHeader(200)
 }
@@ -426,43 +542,566 @@ func (h *Handler) UnpinConversation(w http.ResponseWriter, r *http.Request, p ht
 	conversationID := p.ByName("conversation")
 	userID := r.Context().Value("user").(string)
 
-	// Check relation exists
-	var exists int
-	err := h.db.QueryRow(`SELECT 1 FROM member WHERE "user" = $1 AND "conversation" = $2`, userID, conversationID).Scan(&exists)
-	if err == sql.ErrNoRows {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Update relation, if it exists
+	member, err := tx.Conversations().SetPinned(userID, conversationID, false)
+	if err == store.ErrNotFound {
+		writeStatus(w, r, http.StatusNotFound)
 		return
 	} else if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs
+	err = tx.Enqueue(event.MemberUpdated, userID, &member)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
+	h.outbox.Wake()
 
-	// Update relation
-	_, err = h.db.Exec(`UPDATE "member" SET "pinned" = FALSE WHERE "user" = $1 AND "conversation" = $2`, userID, conversationID)
+	w.WriteHeader(200)
+}
+
+func (h *Handler) PromoteConversationMember(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+	memberID := p.ByName("member")
+
+	tx, err := h.store.Begin()
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Check
+	role, err := tx.Conversations().Role(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case !canAdministrate(role):
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	targetRole, err := tx.Conversations().Role(memberID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case targetRole != RoleMember:
+		// Already an admin or the owner
+		w.WriteHeader(200)
+		return
+	}
+
+	// Update
+	member, err := tx.Conversations().SetRole(memberID, conversationID, RoleAdmin)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Publish NATs
-	if h.nc != nil {
+	err = tx.Enqueue(event.MemberUpdated, userID, &member)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	w.WriteHeader(200)
+}
+
+func (h *Handler) DemoteConversationMember(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+	memberID := p.ByName("member")
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Check, only the owner may demote admins
+	role, err := tx.Conversations().Role(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case role != RoleOwner:
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	targetRole, err := tx.Conversations().Role(memberID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case targetRole == RoleOwner:
+		// Ownership has to be transferred instead
+		writeStatus(w, r, http.StatusBadRequest)
+		return
+	case targetRole == RoleMember:
+		w.WriteHeader(200)
+		return
+	}
+
+	// Update
+	member, err := tx.Conversations().SetRole(memberID, conversationID, RoleMember)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs
+	err = tx.Enqueue(event.MemberUpdated, userID, &member)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	w.WriteHeader(200)
+}
+
+func (h *Handler) TransferConversation(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+	owner := User{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&owner)
+	if err != nil {
+		writeError(w, r, malformed(err))
+		return
+	}
+
+	// Validate
+	if len(owner.ID) < 1 {
+		writeError(w, r, invalid(FieldError{"id", FieldRequired, "is required"}))
+		return
+	}
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Check
+	role, err := tx.Conversations().Role(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case role != RoleOwner:
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	_, err = tx.Conversations().Role(owner.ID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case owner.ID == userID:
+		w.WriteHeader(200)
+		return
+	}
+
+	// Update, the previous owner stays on as an admin
+	previous, err := tx.Conversations().SetRole(userID, conversationID, RoleAdmin)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	next, err := tx.Conversations().SetRole(owner.ID, conversationID, RoleOwner)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs
+	for _, member := range []Member{previous, next} {
+		err = tx.Enqueue(event.MemberUpdated, userID, &member)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	w.WriteHeader(200)
+}
+
+func canAdministrate(role string) bool {
+	return role == RoleOwner || role == RoleAdmin
+}
+
+func (h *Handler) LeaveConversation(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Check
+	err = tx.Conversations().Lock(conversationID)
+	if err != nil && err != store.ErrNotFound {
+		writeError(w, r, err)
+		return
+	}
+	_, err = tx.Conversations().Role(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Delete
+	err = h.removeMember(tx, userID, userID, conversationID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	w.WriteHeader(200)
+}
+
+func (h *Handler) DeleteConversationMember(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+	memberID := p.ByName("member")
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Check
+	err = tx.Conversations().Lock(conversationID)
+	if err != nil && err != store.ErrNotFound {
+		writeError(w, r, err)
+		return
+	}
+	role, err := tx.Conversations().Role(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case !canAdministrate(role):
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	targetRole, err := tx.Conversations().Role(memberID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case memberID == userID:
+		// Removing yourself is leaving
+	case targetRole == RoleOwner || (targetRole == RoleAdmin && role != RoleOwner):
+		// Only the owner may remove admins, and nobody the owner
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	// Delete
+	err = h.removeMember(tx, userID, memberID, conversationID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	w.WriteHeader(200)
+}
+
+// removeMember deletes the membership of user in conversation. Ownership
+// passes on if user was the owner, and the conversation is deleted once nobody
+// is left. Events are enqueued as part of tx.
+func (h *Handler) removeMember(tx store.Tx, actor string, user string, conversation string) error {
+	member, err := tx.Conversations().RemoveMember(user, conversation)
+	if err != nil {
+		return err
+	}
+	err = tx.Enqueue(event.MemberDeleted, actor, &member)
+	if err != nil {
+		return err
+	}
+
+	// Prefer admins as the next owner
+	next, err := tx.Conversations().NextOwner(conversation)
+	switch {
+	case err == store.ErrNotFound:
+		// Last one out
+		err = tx.Conversations().Delete(conversation)
+		if err != nil {
+			return err
+		}
+		return tx.Enqueue(event.ConversationDeleted, actor, &Conversation{
+			ID: conversation,
+		})
+	case err != nil:
+		return err
+	case member.Role == RoleOwner:
+		owner, err := tx.Conversations().SetRole(next, conversation, RoleOwner)
+		if err != nil {
+			return err
+		}
+		return tx.Enqueue(event.MemberUpdated, actor, &owner)
+	}
+	return nil
+}
+
+func (h *Handler) GetOrCreateDM(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	otherID := p.ByName("user")
+
+	// Validate
+	if otherID == userID {
+		writeStatus(w, r, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Check
+	_, err = tx.Users().Get(otherID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Users can't be in a DM with someone they blocked, who looks as if they
+	// don't exist
+	blocked, err := blockedBy(tx.Users(), userID, otherID)
+	switch {
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case blocked:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	}
+
+	// Get or create. Deleted DMs come back, unless they were purged in the
+	// meantime, which makes room for a new one.
+	conversationID, created, err := tx.Conversations().DM("c-"+RandomHex(), userID, otherID)
+	if err == nil && !created {
+		err = restoreDM(tx, userID, conversationID)
+		if err == store.ErrNotFound {
+			conversationID, created, err = tx.Conversations().DM("c-"+RandomHex(), userID, otherID)
+		}
+	}
+	if err == nil && created {
+		err = tx.Enqueue(event.ConversationCreated, userID, &Conversation{
+			ID: conversationID,
+			DM: true,
+		})
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// New DMs start with both users. Opening a DM brings the user back if
+	// they left it, but never the other user, who has to open it themselves.
+	members := []string{userID}
+	if created {
+		members = append(members, otherID)
+	}
+	for _, user := range members {
 		member := Member{
-			User:         userID,
+			User:         user,
 			Conversation: conversationID,
-			Pinned:       false,
+			Pinned:       false, // default
+			Role:         RoleMember,
+		}
+		err = tx.Conversations().AddMember(member)
+		if err == store.ErrConflict {
+			continue
 		}
-		memberString, err := json.Marshal(&member)
 		if err == nil {
-			updateMsg := UpdateMsg{
-				Type: "update",
-				Data: string(memberString),
-			}
-			updateMsgString, err := json.Marshal(&updateMsg)
-			if err == nil {
-				h.nc.Publish("member", updateMsgString)
-			}
+			err = tx.Enqueue(event.MemberCreated, userID, &member)
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
 		}
 	}
 
-	w.WriteHeader(200)
+	// Response object
+	conversation, err := tx.Conversations().Get(userID, conversationID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// restoreDM undeletes conversation if it was deleted, however long ago, as
+// there is only ever one DM between two users. ErrNotFound means it was
+// purged.
+func restoreDM(tx store.Tx, actor string, conversation string) error {
+	err := tx.Conversations().Lock(conversation)
+	if err != nil {
+		return err
+	}
+	restored, err := tx.Conversations().Restore(conversation, math.MaxInt64)
+	switch {
+	case err == store.ErrNotFound:
+		// It wasn't deleted
+		return nil
+	case err != nil:
+		return err
+	}
+	return tx.Enqueue(event.ConversationRestored, actor, &restored)
+}
+
+// Most users a conversation can be looked up by
+const maxLookupMembers = 256
+
+// GetConversationsByMembers is GetConversations with members in the querystring
+func (h *Handler) GetConversationsByMembers(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	query := r.URL.Query()
+	superset := query.Get("superset") == "true"
+
+	// The user is always part of the set
+	members := []string{userID}
+	seen := map[string]bool{userID: true}
+	for _, member := range strings.Split(query.Get("members"), ",") {
+		if member != "" && !seen[member] {
+			seen[member] = true
+			members = append(members, member)
+		}
+	}
+
+	// Validate
+	if len(members) > maxLookupMembers {
+		writeError(w, r, invalid(FieldError{"members", FieldTooMany, fmt.Sprintf("at most %d", maxLookupMembers)}))
+		return
+	}
+
+	// Select
+	conversations, err := h.store.Conversations().ByMembers(userID, members, superset)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversations)
 }
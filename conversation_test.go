@@ -8,7 +8,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"gopkg.in/guregu/null.v3"
@@ -24,6 +26,14 @@ func TestConversation(t *testing.T) {
 
 	t.Run("Create", testCreateConversation(db, r, users))
 	t.Run("Get", testGetConversations(db, r, users))
+	t.Run("Roles", testConversationRoles(db, r, users))
+	t.Run("Leave", testLeaveConversation(db, r, users))
+	t.Run("DM", testDM(db, r, users))
+	t.Run("ByMembers", testGetConversationsByMembers(db, r, users))
+	t.Run("LastHeard", testLastHeard(db, r, users))
+	t.Run("Paginate", testPaginateConversations(db, r, users))
+	t.Run("Restore", testRestoreConversation(db, h, r, users))
+	t.Run("Preferences", testMemberPreferences(db, r, users))
 }
 
 func setupConversationUsers(t *testing.T, db *sql.DB, router http.Handler) []User {
@@ -62,6 +72,15 @@ func setupConversationUsers(t *testing.T, db *sql.DB, router http.Handler) []Use
 		resultUsers = append(resultUsers, got)
 	}
 
+	// Contacts are added to conversations directly instead of being invited
+	for _, user := range resultUsers {
+		for _, contact := range resultUsers {
+			if user.ID != contact.ID {
+				assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: contact.PhoneNumber}, user.ID), 200)
+			}
+		}
+	}
+
 	return resultUsers
 
 }
@@ -133,3 +152,411 @@ func testGetConversations(db *sql.DB, router http.Handler, users []User) func(t
 
 	}
 }
+
+func testConversationRoles(db *sql.DB, router http.Handler, users []User) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		owner, admin, member := users[0].ID, users[1].ID, users[2].ID
+
+		w := serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom("Test Roles")}, owner)
+		assertCode(t, w, 200)
+		conversation := Conversation{}
+		json.NewDecoder(w.Body).Decode(&conversation)
+		if conversation.Role != RoleOwner {
+			t.Errorf("Want creator to be %s, got %s", RoleOwner, conversation.Role)
+		}
+		base := "/user/conversation/" + conversation.ID
+
+		// Non-members can't add members
+		w = serve(router, "POST", base+"/member", &User{ID: member}, admin)
+		assertCode(t, w, 404)
+
+		assertCode(t, serve(router, "POST", base+"/member", &User{ID: admin}, owner), 200)
+		assertCode(t, serve(router, "POST", base+"/member", &User{ID: member}, owner), 200)
+
+		// Members can't administrate
+		w = serve(router, "PATCH", base, &Conversation{Title: null.StringFrom("Renamed")}, admin)
+		assertCode(t, w, 403)
+		w = serve(router, "POST", base+"/member/"+member+"/admin", nil, admin)
+		assertCode(t, w, 403)
+
+		// Promoted admins can
+		assertCode(t, serve(router, "POST", base+"/member/"+admin+"/admin", nil, owner), 200)
+		assertDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2 AND "role" = $3`, admin, conversation.ID, RoleAdmin)
+		w = serve(router, "PATCH", base, &Conversation{Title: null.StringFrom("Renamed")}, admin)
+		assertCode(t, w, 200)
+
+		// Only the owner deletes or demotes
+		assertCode(t, serve(router, "DELETE", base, nil, admin), 403)
+		assertCode(t, serve(router, "DELETE", base+"/member/"+admin+"/admin", nil, admin), 403)
+
+		// Transfer
+		assertCode(t, serve(router, "POST", base+"/owner", &User{ID: admin}, admin), 403)
+		assertCode(t, serve(router, "POST", base+"/owner", &User{ID: admin}, owner), 200)
+		assertDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2 AND "role" = $3`, admin, conversation.ID, RoleOwner)
+		assertDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2 AND "role" = $3`, owner, conversation.ID, RoleAdmin)
+
+		// The new owner can demote the old one
+		assertCode(t, serve(router, "DELETE", base+"/member/"+owner+"/admin", nil, admin), 200)
+		assertDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2 AND "role" = $3`, owner, conversation.ID, RoleMember)
+		assertCode(t, serve(router, "DELETE", base, nil, admin), 200)
+
+	}
+}
+
+func testLeaveConversation(db *sql.DB, router http.Handler, users []User) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		owner, admin, member := users[0].ID, users[1].ID, users[2].ID
+
+		w := serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom("Test Leave")}, owner)
+		assertCode(t, w, 200)
+		conversation := Conversation{}
+		json.NewDecoder(w.Body).Decode(&conversation)
+		base := "/user/conversation/" + conversation.ID
+
+		assertCode(t, serve(router, "POST", base+"/member", &User{ID: admin}, owner), 200)
+		assertCode(t, serve(router, "POST", base+"/member", &User{ID: member}, owner), 200)
+		assertCode(t, serve(router, "POST", base+"/member/"+admin+"/admin", nil, owner), 200)
+
+		// Kick
+		assertCode(t, serve(router, "DELETE", base+"/member/"+admin, nil, member), 403)
+		assertCode(t, serve(router, "DELETE", base+"/member/"+owner, nil, admin), 403)
+		assertCode(t, serve(router, "DELETE", base+"/member/"+member, nil, admin), 200)
+		assertNoDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2`, member, conversation.ID)
+		assertCode(t, serve(router, "DELETE", base+"/member", nil, member), 404)
+
+		// The owner leaving hands ownership to the admin
+		assertCode(t, serve(router, "DELETE", base+"/member", nil, owner), 200)
+		assertDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2 AND "role" = $3`, admin, conversation.ID, RoleOwner)
+
+		// The last one out cleans up
+		assertCode(t, serve(router, "DELETE", base+"/member", nil, admin), 200)
+		assertNoDB(t, db, `SELECT * FROM "conversation" WHERE id = $1`, conversation.ID)
+
+	}
+}
+
+func testDM(db *sql.DB, router http.Handler, users []User) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Test, concurrently
+		ids := make([]string, 10)
+		var wg sync.WaitGroup
+		for i := range ids {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				a, b := users[i%2].ID, users[(i+1)%2].ID
+				w := serve(router, "PUT", "/user/dm/"+b, nil, a)
+				assertCode(t, w, 200)
+				conversation := Conversation{}
+				json.NewDecoder(w.Body).Decode(&conversation)
+				if !conversation.DM {
+					t.Error("Want a DM, got a conversation that isn't one")
+				}
+				ids[i] = conversation.ID
+			}(i)
+		}
+		wg.Wait()
+
+		// Assert
+		for _, id := range ids {
+			if id != ids[0] {
+				t.Fatalf("Want the same DM for every request, got %s and %s", ids[0], id)
+			}
+		}
+		assertDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2`, users[0].ID, ids[0])
+		assertDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2`, users[1].ID, ids[0])
+
+		// Nobody else joins
+		assertCode(t, serve(router, "POST", "/user/conversation/"+ids[0]+"/member", &User{ID: users[2].ID}, users[0].ID), 403)
+
+		// Leaving, and only coming back by yourself
+		assertCode(t, serve(router, "DELETE", "/user/conversation/"+ids[0]+"/member", nil, users[1].ID), 200)
+		assertCode(t, serve(router, "PUT", "/user/dm/"+users[1].ID, nil, users[0].ID), 200)
+		assertNoDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2`, users[1].ID, ids[0])
+		assertCode(t, serve(router, "PUT", "/user/dm/"+users[0].ID, nil, users[1].ID), 200)
+		assertDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2`, users[1].ID, ids[0])
+
+		// Deleted DMs come back, however long ago
+		_, err := db.Exec(`UPDATE "conversation" SET deleted_at = NOW() - INTERVAL '1000 days' WHERE id = $1`, ids[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertCode(t, serve(router, "PUT", "/user/dm/"+users[1].ID, nil, users[0].ID), 200)
+		assertDB(t, db, `SELECT * FROM "conversation" WHERE id = $1 AND deleted_at IS NULL`, ids[0])
+
+		// Not with yourself or nobody
+		assertCode(t, serve(router, "PUT", "/user/dm/"+users[0].ID, nil, users[0].ID), 400)
+		assertCode(t, serve(router, "PUT", "/user/dm/u-nobody", nil, users[0].ID), 404)
+
+	}
+}
+
+func testGetConversationsByMembers(db *sql.DB, router http.Handler, users []User) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup overlapping groups: pair {0, 1}, trio {0, 1, 2}, other pair {0, 2}
+		create := func(title string, members ...string) string {
+			w := serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom(title)}, users[0].ID)
+			assertCode(t, w, 200)
+			conversation := Conversation{}
+			json.NewDecoder(w.Body).Decode(&conversation)
+			for _, member := range members {
+				assertCode(t, serve(router, "POST", "/user/conversation/"+conversation.ID+"/member", &User{ID: member}, users[0].ID), 200)
+			}
+			return conversation.ID
+		}
+		pair := create("Test Pair", users[1].ID)
+		trio := create("Test Trio", users[1].ID, users[2].ID)
+		other := create("Test Other Pair", users[2].ID)
+
+		find := func(query string, user string) map[string]bool {
+			w := serve(router, "GET", "/user/conversation?"+query, nil, user)
+			assertCode(t, w, 200)
+			conversations := make([]Conversation, 0)
+			json.NewDecoder(w.Body).Decode(&conversations)
+			found := make(map[string]bool)
+			for _, conversation := range conversations {
+				found[conversation.ID] = true
+			}
+			return found
+		}
+
+		// Test
+		tests := []struct {
+			name  string
+			query string
+			user  string
+			want  map[string]bool
+		}{
+			{"Exact", "members=" + users[1].ID, users[0].ID, map[string]bool{pair: true}},
+			{"Exact caller listed", "members=" + users[0].ID + "," + users[1].ID, users[0].ID, map[string]bool{pair: true}},
+			{"Exact trio", "members=" + users[1].ID + "," + users[2].ID, users[0].ID, map[string]bool{trio: true}},
+			{"Superset", "members=" + users[1].ID + "&superset=true", users[0].ID, map[string]bool{pair: true, trio: true}},
+			{"Superset other member", "members=" + users[0].ID + "&superset=true", users[2].ID, map[string]bool{trio: true, other: true}},
+		}
+
+		// Assert, ignoring conversations the other tests left behind
+		for _, test := range tests {
+			found := find(test.query, test.user)
+			for _, id := range []string{pair, trio, other} {
+				if found[id] != test.want[id] {
+					t.Errorf("%s: want conversation %s found to be %t, got %t", test.name, id, test.want[id], found[id])
+				}
+			}
+		}
+
+	}
+}
+
+func testLastHeard(db *sql.DB, router http.Handler, users []User) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		w := serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom("Test Last Heard")}, users[0].ID)
+		assertCode(t, w, 200)
+		conversation := Conversation{}
+		json.NewDecoder(w.Body).Decode(&conversation)
+		target := "/user/lastheard/" + conversation.ID
+
+		get := func() int64 {
+			w := serve(router, "GET", target, nil, users[0].ID)
+			assertCode(t, w, 200)
+			lastHeard := LastHeard{}
+			json.NewDecoder(w.Body).Decode(&lastHeard)
+			return lastHeard.LastHeard
+		}
+
+		// Test
+		if got := get(); got != 0 {
+			t.Errorf("Want a new conversation to be unheard, got %d", got)
+		}
+		assertCode(t, serve(router, "PUT", target, &LastHeard{LastHeard: 100}, users[0].ID), 200)
+		if got := get(); got != 100 {
+			t.Errorf("Want last heard 100, got %d", got)
+		}
+
+		// A device that is behind doesn't move it back
+		assertCode(t, serve(router, "PUT", target, &LastHeard{LastHeard: 50}, users[0].ID), 200)
+		if got := get(); got != 100 {
+			t.Errorf("Want last heard to stay at 100, got %d", got)
+		}
+
+		// It shows up with the conversation
+		w = serve(router, "GET", "/user/conversation/"+conversation.ID, nil, users[0].ID)
+		assertCode(t, w, 200)
+		json.NewDecoder(w.Body).Decode(&conversation)
+		if conversation.LastHeard.Int64 != 100 {
+			t.Errorf("Want the conversation to be heard up to 100, got %v", conversation.LastHeard)
+		}
+
+		// Only for members
+		assertCode(t, serve(router, "GET", target, nil, users[1].ID), 404)
+		assertCode(t, serve(router, "PUT", target, &LastHeard{LastHeard: 100}, users[1].ID), 404)
+		w = serve(router, "PUT", target, &LastHeard{LastHeard: -1}, users[0].ID)
+		assertCode(t, w, 400)
+		body := Error{}
+		json.NewDecoder(w.Body).Decode(&body)
+		if len(body.Details) != 1 || body.Details[0].Field != "lastheard" {
+			t.Errorf("Want lastheard to be invalid, got %v", body.Details)
+		}
+
+	}
+}
+
+func testPaginateConversations(db *sql.DB, router http.Handler, users []User) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		w := serve(router, "POST", "/user", &User{PhoneNumber: "+65 9999 0004", FirstName: "Paginate", LastName: "User"}, "")
+		assertCode(t, w, 200)
+		user := User{}
+		json.NewDecoder(w.Body).Decode(&user)
+
+		ids := []string{}
+		for _, title := range []string{"Oldest", "Pinned", "Newest"} {
+			w := serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom(title)}, user.ID)
+			assertCode(t, w, 200)
+			conversation := Conversation{}
+			json.NewDecoder(w.Body).Decode(&conversation)
+			ids = append(ids, conversation.ID)
+		}
+		assertCode(t, serve(router, "POST", "/user/conversation/"+ids[1]+"/pin", nil, user.ID), 200)
+
+		// Test
+		w = serve(router, "GET", "/user/conversation?limit=2", nil, user.ID)
+		assertCode(t, w, 200)
+		first := []Conversation{}
+		json.NewDecoder(w.Body).Decode(&first)
+		cursor := w.Header().Get("X-Next-Cursor")
+		w = serve(router, "GET", "/user/conversation?limit=2&cursor="+cursor, nil, user.ID)
+		assertCode(t, w, 200)
+		second := []Conversation{}
+		json.NewDecoder(w.Body).Decode(&second)
+
+		// Assert, pinned first, then most recently active
+		got := []string{}
+		for _, conversation := range append(first, second...) {
+			got = append(got, conversation.ID)
+		}
+		want := []string{ids[1], ids[2], ids[0]}
+		if diff := cmp.Diff(got, want); len(diff) != 0 {
+			t.Error(diff)
+		}
+		if w.Header().Get("Link") != "" {
+			t.Error("Want no next page after the last one")
+		}
+
+	}
+}
+
+func testRestoreConversation(db *sql.DB, h *Handler, router http.Handler, users []User) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		owner, member := users[0].ID, users[1].ID
+		w := serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom("Test Restore")}, owner)
+		assertCode(t, w, 200)
+		conversation := Conversation{}
+		json.NewDecoder(w.Body).Decode(&conversation)
+		base := "/user/conversation/" + conversation.ID
+		assertCode(t, serve(router, "POST", base+"/member", &User{ID: member}, owner), 200)
+
+		listed := func(user string) bool {
+			w := serve(router, "GET", "/user/conversation?limit=200", nil, user)
+			assertCode(t, w, 200)
+			conversations := make([]Conversation, 0)
+			json.NewDecoder(w.Body).Decode(&conversations)
+			for _, c := range conversations {
+				if c.ID == conversation.ID {
+					return true
+				}
+			}
+			return false
+		}
+
+		// Delete hides the conversation but keeps its members
+		assertCode(t, serve(router, "DELETE", base, nil, owner), 200)
+		assertCode(t, serve(router, "GET", base, nil, owner), 404)
+		assertCode(t, serve(router, "GET", base, nil, member), 404)
+		if listed(member) {
+			t.Error("Want deleted conversation hidden, got it listed")
+		}
+		assertCode(t, serve(router, "PATCH", base, &Conversation{Title: null.StringFrom("Renamed")}, owner), 404)
+		assertCode(t, serve(router, "DELETE", base, nil, owner), 404)
+		assertDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2`, member, conversation.ID)
+
+		// Only the owner restores
+		assertCode(t, serve(router, "POST", base+"/restore", nil, member), 403)
+		assertCode(t, serve(router, "POST", base+"/restore", nil, owner), 200)
+		assertCode(t, serve(router, "POST", base+"/restore", nil, owner), 404)
+		assertCode(t, serve(router, "GET", base, nil, member), 200)
+		if !listed(member) {
+			t.Error("Want restored conversation listed, got it hidden")
+		}
+
+		// Past the retention it is purged for good
+		assertCode(t, serve(router, "DELETE", base, nil, owner), 200)
+		_, err := db.Exec(`UPDATE "conversation" SET deleted_at = NOW() - $2::FLOAT8 * INTERVAL '1 second' WHERE id = $1`, conversation.ID, conversationRetention.Seconds())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertCode(t, serve(router, "POST", base+"/restore", nil, owner), 404)
+		if _, err := h.purge(); err != nil {
+			t.Fatal(err)
+		}
+		assertNoDB(t, db, `SELECT * FROM "conversation" WHERE id = $1`, conversation.ID)
+		assertNoDB(t, db, `SELECT * FROM member WHERE "conversation" = $1`, conversation.ID)
+
+	}
+}
+
+func testMemberPreferences(db *sql.DB, router http.Handler, users []User) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		user := users[0].ID
+		w := serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom("Test Preferences")}, user)
+		assertCode(t, w, 200)
+		conversation := Conversation{}
+		json.NewDecoder(w.Body).Decode(&conversation)
+		base := "/user/conversation/" + conversation.ID
+		if conversation.Archived || conversation.MutedUntil.Valid || conversation.Notifications != NotifyAll {
+			t.Errorf("Want default preferences, got %v", conversation)
+		}
+
+		get := func() Conversation {
+			w := serve(router, "GET", base, nil, user)
+			assertCode(t, w, 200)
+			got := Conversation{}
+			json.NewDecoder(w.Body).Decode(&got)
+			return got
+		}
+
+		// Validate
+		assertCode(t, serve(router, "PATCH", base+"/preferences", map[string]interface{}{"notifications": "some"}, user), 400)
+		assertCode(t, serve(router, "PATCH", base+"/preferences", map[string]interface{}{"archived": nil}, user), 400)
+		assertCode(t, serve(router, "PATCH", base+"/preferences", map[string]interface{}{"archived": true}, users[1].ID), 404)
+
+		// Update some
+		muted := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		w = serve(router, "PATCH", base+"/preferences", map[string]interface{}{"muted_until": muted, "notifications": NotifyMentions}, user)
+		assertCode(t, w, 200)
+		got := get()
+		if got.Archived || !got.MutedUntil.Time.Equal(muted) || got.Notifications != NotifyMentions {
+			t.Errorf("Want muted until %s for mentions, got %v", muted, got)
+		}
+
+		// Others stay as they are, and null unmutes
+		assertCode(t, serve(router, "PATCH", base+"/preferences", map[string]interface{}{"archived": true, "muted_until": nil}, user), 200)
+		got = get()
+		if !got.Archived || got.MutedUntil.Valid || got.Notifications != NotifyMentions {
+			t.Errorf("Want archived and unmuted for mentions, got %v", got)
+		}
+
+	}
+}
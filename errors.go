@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/lib/pq"
+
+	"backend/core/store"
+)
+
+// Codes of error responses. Clients should act on these rather than the
+// message, which is for people.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeMalformedBody    = "malformed_body"
+	CodeInvalid          = "invalid" // see the details
+	CodeInvalidPage      = "invalid_page"
+	CodeMissingClaim     = "missing_user_claim"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodeUsernameTaken    = "username_taken"
+	CodeAlreadyMember    = "already_member"
+	CodeInvalidReference = "invalid_reference"
+	CodeInternal         = "internal"
+	CodeUnavailable      = "unavailable"
+)
+
+// Codes of field errors
+const (
+	FieldRequired = "required"
+	FieldInvalid  = "invalid"
+	FieldTooShort = "too_short"
+	FieldTooLong  = "too_long"
+	FieldTooMany  = "too_many"
+	FieldReserved = "reserved"
+)
+
+// RequestIDHeader identifies a request in error responses and logs. It is
+// taken from the request if set there, by a gateway for instance.
+const RequestIDHeader = "X-Request-ID"
+
+// Error is the body of every error response
+type Error struct {
+	Status    int          `json:"-"`
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id"`
+	Details   []FieldError `json:"details,omitempty"`
+}
+
+// FieldError is what is wrong with one field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// statusCodes are the codes of errors that are only known by their status
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          CodeBadRequest,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusConflict:            CodeConflict,
+	http.StatusUnprocessableEntity: CodeInvalidReference,
+	http.StatusInternalServerError: CodeInternal,
+	http.StatusServiceUnavailable:  CodeUnavailable,
+}
+
+// statusError is the error of status, with its usual code and text
+func statusError(status int) *Error {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeBadRequest
+		if status >= 500 {
+			code = CodeInternal
+		}
+	}
+	return &Error{Status: status, Code: code, Message: http.StatusText(status)}
+}
+
+// malformed is the error of a body that couldn't be decoded
+func malformed(err error) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeMalformedBody, Message: err.Error()}
+}
+
+// invalid is the error of a request with fields that don't validate
+func invalid(details ...FieldError) *Error {
+	return &Error{
+		Status:  http.StatusBadRequest,
+		Code:    CodeInvalid,
+		Message: "some fields are invalid",
+		Details: details,
+	}
+}
+
+// requestID returns the ID of r, and sets it on the response
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	id := w.Header().Get(RequestIDHeader)
+	if id == "" {
+		id = r.Header.Get(RequestIDHeader)
+	}
+	if id == "" {
+		id = RandomHex()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return id
+}
+
+// writeStatus responds with the error of status
+func writeStatus(w http.ResponseWriter, r *http.Request, status int) {
+	writeError(w, r, statusError(status))
+}
+
+// writeError responds with err. Errors of the store and of Postgres are
+// mapped to their status, anything unexpected is logged and is a 500, or a 503
+// if the database can't be reached.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	id := requestID(w, r)
+
+	var e *Error
+	var pqErr *pq.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &e):
+		copied := *e
+		e = &copied
+	case errors.Is(err, store.ErrNotFound), errors.Is(err, sql.ErrNoRows):
+		e = statusError(http.StatusNotFound)
+	case errors.Is(err, store.ErrConflict):
+		e = statusError(http.StatusConflict)
+	case errors.Is(err, store.ErrReference):
+		e = statusError(http.StatusUnprocessableEntity)
+	case errors.As(err, &pqErr) && pqErr.Code == "23505": // unique_violation
+		e = statusError(http.StatusConflict)
+	case errors.As(err, &pqErr) && pqErr.Code == "23503": // foreign_key_violation
+		e = statusError(http.StatusUnprocessableEntity)
+	case errors.Is(err, driver.ErrBadConn), errors.As(err, &netErr):
+		log.Printf("request %s: %v", id, err)
+		e = statusError(http.StatusServiceUnavailable)
+	default:
+		log.Printf("request %s: %v", id, err)
+		e = statusError(http.StatusInternalServerError)
+	}
+	e.RequestID = id
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(e)
+}
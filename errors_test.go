@@ -0,0 +1,87 @@
+// +build unit
+
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lib/pq"
+
+	"backend/core/store"
+	"backend/core/store/memory"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{store.ErrNotFound, 404, CodeNotFound},
+		{store.ErrConflict, 409, CodeConflict},
+		{store.ErrReference, 422, CodeInvalidReference},
+		{&pq.Error{Code: "23505"}, 409, CodeConflict},
+		{&pq.Error{Code: "23503"}, 422, CodeInvalidReference},
+		{fmt.Errorf("wrapped: %w", store.ErrConflict), 409, CodeConflict},
+		{driver.ErrBadConn, 503, CodeUnavailable},
+		{errors.New("unexpected"), 500, CodeInternal},
+		{errUsernameTaken, 409, CodeUsernameTaken},
+		{invalid(FieldError{"first_name", FieldRequired, "is required"}), 400, CodeInvalid},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		writeError(w, httptest.NewRequest("GET", "/", nil), test.err)
+
+		body := Error{}
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != test.status || body.Code != test.code {
+			t.Errorf("writeError(%v) = %d %s; want %d %s", test.err, w.Code, body.Code, test.status, test.code)
+		}
+		if body.RequestID == "" || body.RequestID != w.Header().Get(RequestIDHeader) {
+			t.Errorf("Want the request ID in the body and header, got %q and %q", body.RequestID, w.Header().Get(RequestIDHeader))
+		}
+	}
+}
+
+func TestWriteErrorRequestID(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(RequestIDHeader, "req-1")
+	writeStatus(w, r, http.StatusForbidden)
+
+	body := Error{}
+	json.NewDecoder(w.Body).Decode(&body)
+	if body.RequestID != "req-1" || body.Code != CodeForbidden {
+		t.Errorf("Want the request ID of the request and %s, got %v", CodeForbidden, body)
+	}
+}
+
+func TestValidationDetails(t *testing.T) {
+	router := NewRouter(NewStoreHandler(memory.New(nil), nil))
+
+	w := serve(router, "POST", "/user", &User{PhoneNumber: "not a number"}, "")
+	assertCode(t, w, 400)
+	body := Error{}
+	json.NewDecoder(w.Body).Decode(&body)
+	fields := make([]string, 0)
+	for _, detail := range body.Details {
+		fields = append(fields, detail.Field)
+	}
+	if got, want := fmt.Sprint(fields), "[phone_number first_name last_name]"; got != want {
+		t.Errorf("Want details for %s, got %s", want, got)
+	}
+
+	w = serve(router, "GET", "/user", nil, "")
+	assertCode(t, w, 400)
+	json.NewDecoder(w.Body).Decode(&body)
+	if body.Code != CodeMissingClaim {
+		t.Errorf("Want %s, got %s", CodeMissingClaim, body.Code)
+	}
+}
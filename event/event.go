@@ -0,0 +1,126 @@
+// Package event defines the NATS subjects core publishes on and the envelope
+// every event is wrapped in.
+package event
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Prefix is prepended to every subject. Bump the version when payloads change
+// incompatibly.
+const Prefix = "core.v1"
+
+// Subjects
+const (
+	UserCreated = Prefix + ".user.created"
+	UserUpdated = Prefix + ".user.updated"
+
+	ContactCreated = Prefix + ".contact.created"
+	ContactDeleted = Prefix + ".contact.deleted"
+
+	ConversationCreated = Prefix + ".conversation.created"
+	ConversationUpdated = Prefix + ".conversation.updated"
+	ConversationDeleted = Prefix + ".conversation.deleted"
+
+	// Deleted conversations can be restored until they are purged, which
+	// publishes ConversationDeleted
+	ConversationTrashed  = Prefix + ".conversation.trashed"
+	ConversationRestored = Prefix + ".conversation.restored"
+
+	MemberCreated = Prefix + ".member.created"
+	MemberUpdated = Prefix + ".member.updated"
+	MemberDeleted = Prefix + ".member.deleted"
+
+	// Only delivered to the member itself
+	MemberLastHeard   = Prefix + ".member.lastheard"
+	MemberPreferences = Prefix + ".member.preferences"
+
+	InviteCreated  = Prefix + ".invite.created"
+	InviteAccepted = Prefix + ".invite.accepted"
+	InviteDeclined = Prefix + ".invite.declined"
+)
+
+// Wildcards matching every subject of a kind
+const (
+	Users         = Prefix + ".user.*"
+	Contacts      = Prefix + ".contact.*"
+	Conversations = Prefix + ".conversation.*"
+	Members       = Prefix + ".member.*"
+	Invites       = Prefix + ".invite.*"
+)
+
+// Envelope wraps the payload of every event
+type Envelope struct {
+	ID      string          `json:"id"`      // unique event ID
+	Subject string          `json:"subject"` // subject the event was published on
+	Time    time.Time       `json:"time"`    // when the event happened
+	Actor   string          `json:"actor"`   // ID of the user that caused the event
+	Data    json.RawMessage `json:"data"`    // payload
+}
+
+// New wraps payload in an envelope with a fresh ID
+func New(subject string, actor string, payload interface{}) (*Envelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		ID:      newID(),
+		Subject: subject,
+		Time:    time.Now().UTC(),
+		Actor:   actor,
+		Data:    data,
+	}, nil
+}
+
+// Parse reads an envelope from a message body
+func Parse(b []byte) (*Envelope, error) {
+	envelope := &Envelope{}
+	err := json.Unmarshal(b, envelope)
+	if err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
+
+// Decode unmarshals the payload of the envelope into v
+func (e *Envelope) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// Action returns the last part of the subject, e.g. "created"
+func (e *Envelope) Action() string {
+	return e.Subject[strings.LastIndex(e.Subject, ".")+1:]
+}
+
+// Publisher is satisfied by *nats.Conn
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// Publish wraps payload in an envelope and publishes it on subject
+func Publish(p Publisher, subject string, actor string, payload interface{}) error {
+	envelope, err := New(subject, actor, payload)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return p.Publish(subject, b)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic("unable to generate 16 bytes of randomness")
+	}
+	return "e-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,86 @@
+// +build unit
+
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+func runServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   server.RANDOM_PORT,
+		NoLog:  true,
+		NoSigs: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("Embedded nats-server did not start")
+	}
+	return s
+}
+
+type payload struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+func TestPublish(t *testing.T) {
+	s := runServer(t)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync(Conversations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := payload{ID: "c-1", Title: "Title"}
+	err = Publish(nc, ConversationUpdated, "u-1", &want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != ConversationUpdated {
+		t.Errorf("Want subject %s, got %s", ConversationUpdated, msg.Subject)
+	}
+
+	envelope, err := Parse(msg.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envelope.ID) < 1 || envelope.Time.IsZero() {
+		t.Error("Want an envelope with ID and Time set")
+	}
+	if envelope.Actor != "u-1" {
+		t.Errorf("Want actor u-1, got %s", envelope.Actor)
+	}
+	if envelope.Action() != "updated" {
+		t.Errorf("Want action updated, got %s", envelope.Action())
+	}
+
+	got := payload{}
+	err = envelope.Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Want payload %v, got %v", want, got)
+	}
+}
@@ -1,17 +1,18 @@
 module backend/core
 
 require (
-	github.com/golang/protobuf v1.1.0 // indirect
 	github.com/google/go-cmp v0.3.1
 	github.com/joho/godotenv v1.3.0
 	github.com/julienschmidt/httprouter v0.0.0-20180715161854-348b672cd90d
 	github.com/lib/pq v0.0.0-20180523175426-90697d60dd84
 	github.com/nats-io/go-nats v1.7.2
+	github.com/nats-io/nats-server/v2 v2.1.0
 	github.com/nats-io/nkeys v0.1.0 // indirect
 	github.com/nats-io/nuid v1.0.1 // indirect
 	github.com/ttacon/builder v0.0.0-20170518171403-c099f663e1c2 // indirect
 	github.com/ttacon/libphonenumber v1.0.0
 	golang.org/x/sync v0.0.0-20181221193216-37e7f081c4d4 // indirect
+	golang.org/x/text v0.3.2
 	gopkg.in/guregu/null.v3 v3.4.0
 )
 
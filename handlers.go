@@ -4,38 +4,80 @@ import (
 	"database/sql"
 
 	"github.com/nats-io/go-nats"
+
+	"backend/core/event"
+	"backend/core/store"
+)
+
+// Messages buffered per subscriber before the slow consumer policy applies
+const subscriberBufferSize = 64
+
+// Subscription topics
+const (
+	contactTopic      = "contact"
+	conversationTopic = "conversation"
+	inviteTopic       = "invite"
+	userTopic         = "user"
 )
 
+func memberTopic(conversation string) string {
+	return "member:" + conversation
+}
+
 type Handler struct {
-	db *sql.DB
-	nc *nats.Conn
+	store store.Store
+	nc    *nats.Conn
 
-	contactConnections      map[string]chan []byte
-	conversationConnections map[string]chan []byte
-	userConnections         map[string]chan []byte
-	memberConnections       map[string]map[string]chan []byte
+	permissions *Permissions
+	blocks      *Blocks
+	notified    bool // whether Postgres keeps permissions and blocks current
+	hub         *Hub
+	outbox      *Outbox
+	seen        *seenEvents
 }
 
+// NewHandler keeps everything in Postgres
 func NewHandler(db *sql.DB, nc *nats.Conn) *Handler {
-	contactConnections := make(map[string]chan []byte)
-	conversationConnections := make(map[string]chan []byte)
-	userConnections := make(map[string]chan []byte)
-	memberConnections := make(map[string]map[string]chan []byte)
+	outbox := NewOutbox(db, nc)
+	return newHandler(store.NewPostgres(db, outbox), db, nc, outbox)
+}
+
+// NewStoreHandler keeps everything in s, without Postgres
+func NewStoreHandler(s store.Store, nc *nats.Conn) *Handler {
+	return newHandler(s, nil, nc, NewOutbox(nil, nc))
+}
+
+func newHandler(s store.Store, db *sql.DB, nc *nats.Conn, outbox *Outbox) *Handler {
+	permissions := NewPermissions(s)
+	blocks := NewBlocks(s)
+	hub := NewHub(subscriberBufferSize, Disconnect)
+	seen := newSeenEvents(seenEventsSize)
 
 	h := &Handler{
-		db,
+		s,
 		nc,
-		contactConnections,
-		conversationConnections,
-		userConnections,
-		memberConnections,
+		permissions,
+		blocks,
+		db != nil,
+		hub,
+		outbox,
+		seen,
 	}
 
+	go h.Purge()
+
 	if nc != nil {
-		nc.Subscribe("contacts", h.ContactHandler)
-		nc.Subscribe("conversations", h.ConversationHandler)
-		nc.Subscribe("users", h.UserHandler)
-		nc.Subscribe("members", h.MemberHandler)
+		if db != nil {
+			go permissions.Listen(postgres)
+			go blocks.Listen(postgres)
+			go outbox.Relay()
+		}
+
+		nc.Subscribe(event.Contacts, h.ContactHandler)
+		nc.Subscribe(event.Conversations, h.ConversationHandler)
+		nc.Subscribe(event.Users, h.UserHandler)
+		nc.Subscribe(event.Members, h.MemberHandler)
+		nc.Subscribe(event.Invites, h.InviteHandler)
 	}
 
 	return h
@@ -0,0 +1,219 @@
+// +build unit
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"gopkg.in/guregu/null.v3"
+
+	"backend/core/event"
+	"backend/core/store/memory"
+)
+
+// subjects records the subject of every event published through it
+type subjects []string
+
+func (s *subjects) Publish(subject string, data []byte) error {
+	*s = append(*s, subject)
+	return nil
+}
+
+// registerAll registers users with the IDs ids, numbered in order
+func registerAll(t *testing.T, s *memory.Memory, ids ...string) {
+	for i, id := range ids {
+		user := User{ID: id, FirstName: id, PhoneNumber: fmt.Sprintf("+65 9999 0%03d", i+1)}
+		if _, err := s.Users().Register(&user); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUserHandlers(t *testing.T) {
+	events := subjects{}
+	s := memory.New(&events)
+	router := NewRouter(NewStoreHandler(s, nil))
+
+	// Create
+	w := serve(router, "POST", "/user", &User{PhoneNumber: "+65 9999 0001", FirstName: "Ada", LastName: "Lovelace"}, "")
+	assertCode(t, w, 200)
+	user := User{}
+	json.NewDecoder(w.Body).Decode(&user)
+	if user.ID == "" || user.PhoneNumber != "+65 9999 0001" {
+		t.Errorf("Want a created user with a normalised phone number, got %v", user)
+	}
+	assertCode(t, serve(router, "POST", "/user", &User{PhoneNumber: "+65 9999 0002"}, ""), 400)
+
+	// Get
+	assertCode(t, serve(router, "GET", "/user/id/"+user.ID, nil, user.ID), 200)
+	assertCode(t, serve(router, "GET", "/user/id/u-missing", nil, user.ID), 404)
+	assertCode(t, serve(router, "GET", "/user?phone_number=%2B6599990001", nil, user.ID), 200)
+	assertCode(t, serve(router, "GET", "/user?phone_number=%2B6599990003", nil, user.ID), 404)
+
+	// Update
+	assertCode(t, serve(router, "PATCH", "/user", &User{FirstName: "Augusta", LastName: "King"}, user.ID), 200)
+	if got, _ := s.Users().Get(user.ID); got.FirstName != "Augusta" || got.PhoneNumber != user.PhoneNumber {
+		t.Errorf("Want the name updated and the phone number kept, got %v", got)
+	}
+	assertCode(t, serve(router, "PATCH", "/user", &User{FirstName: "Nobody", LastName: "Here"}, "u-missing"), 404)
+
+	if got, want := len(events), 2; got != want {
+		t.Errorf("Want %d events, got %d", want, got)
+	}
+}
+
+func TestContactHandlers(t *testing.T) {
+	s := memory.New(nil)
+	router := NewRouter(NewStoreHandler(s, nil))
+	registerAll(t, s, "u-a", "u-b")
+
+	// Existing users are added, others get a placeholder
+	assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: "+65 9999 0002"}, "u-a"), 200)
+	assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: "+65 9999 0003"}, "u-a"), 200)
+	assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: "+65 9999 0003"}, "u-a"), 409)
+	assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: "not a number"}, "u-a"), 400)
+	if _, err := s.Users().ByPhone("+65 9999 0003", "u-a"); err != nil {
+		t.Errorf("Want a placeholder, got %v", err)
+	}
+
+	// List
+	w := serve(router, "GET", "/user/contact", nil, "u-a")
+	assertCode(t, w, 200)
+	contacts := []User{}
+	json.NewDecoder(w.Body).Decode(&contacts)
+	if got, want := len(contacts), 2; got != want {
+		t.Errorf("Want %d contacts, got %d", want, got)
+	}
+
+	// Get and delete
+	assertCode(t, serve(router, "GET", "/user/contact/u-b", nil, "u-a"), 200)
+	assertCode(t, serve(router, "DELETE", "/user/contact/u-b", nil, "u-a"), 200)
+	assertCode(t, serve(router, "GET", "/user/contact/u-b", nil, "u-a"), 404)
+	assertCode(t, serve(router, "DELETE", "/user/contact/u-b", nil, "u-a"), 404)
+}
+
+func TestConversationHandlers(t *testing.T) {
+	events := subjects{}
+	s := memory.New(&events)
+	router := NewRouter(NewStoreHandler(s, nil))
+	registerAll(t, s, "u-a", "u-b", "u-c")
+	s.Contacts().Create("u-a", "u-b")
+	s.Contacts().Create("u-b", "u-c")
+
+	// Create
+	w := serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom("Test")}, "u-a")
+	assertCode(t, w, 200)
+	conversation := Conversation{}
+	json.NewDecoder(w.Body).Decode(&conversation)
+	if conversation.Role != RoleOwner || conversation.Title.String != "Test" {
+		t.Errorf("Want a conversation owned by its creator, got %v", conversation)
+	}
+	path := "/user/conversation/" + conversation.ID
+
+	// Members, only admins add them
+	assertCode(t, serve(router, "POST", path+"/member", &User{ID: "u-b"}, "u-a"), 200)
+	assertCode(t, serve(router, "POST", path+"/member", &User{ID: "u-c"}, "u-b"), 403)
+	assertCode(t, serve(router, "POST", path+"/member", &User{ID: "u-c"}, "u-c"), 404)
+	w = serve(router, "GET", path+"/member", nil, "u-a")
+	assertCode(t, w, 200)
+	members := []User{}
+	json.NewDecoder(w.Body).Decode(&members)
+	if len(members) != 1 || members[0].ID != "u-b" {
+		t.Errorf("Want u-b as the other member, got %v", members)
+	}
+
+	// Promoted members can add their own contacts
+	assertCode(t, serve(router, "POST", path+"/member/u-b/admin", nil, "u-a"), 200)
+	assertCode(t, serve(router, "POST", path+"/member", &User{ID: "u-c"}, "u-b"), 200)
+
+	// Get and update
+	assertCode(t, serve(router, "GET", path, nil, "u-c"), 200)
+	assertCode(t, serve(router, "PATCH", path, &Conversation{Title: null.StringFrom("Renamed")}, "u-c"), 403)
+	assertCode(t, serve(router, "PATCH", path, &Conversation{Title: null.StringFrom("Renamed")}, "u-b"), 200)
+	if got, _ := s.Conversations().Get("u-a", conversation.ID); got.Title.String != "Renamed" {
+		t.Errorf("Want the conversation renamed, got %q", got.Title.String)
+	}
+
+	// List
+	w = serve(router, "GET", "/user/conversation", nil, "u-c")
+	assertCode(t, w, 200)
+	conversations := []Conversation{}
+	json.NewDecoder(w.Body).Decode(&conversations)
+	if len(conversations) != 1 || conversations[0].Role != RoleMember {
+		t.Errorf("Want the conversation as a member, got %v", conversations)
+	}
+
+	want := []string{
+		event.ConversationCreated,
+		event.MemberCreated,
+		event.MemberUpdated,
+		event.MemberCreated,
+		event.ConversationUpdated,
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Want events %v, got %v", want, events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("Want events %v, got %v", want, events)
+			break
+		}
+	}
+}
+
+func TestBlockHandlers(t *testing.T) {
+	s := memory.New(nil)
+	h := NewStoreHandler(s, nil)
+	router := NewRouter(h)
+	registerAll(t, s, "u-a", "u-b")
+
+	// Subscribed users learn of blocks without Postgres
+	if err := h.blocks.Acquire("u-b"); err != nil {
+		t.Fatal(err)
+	}
+	defer h.blocks.Release("u-b")
+	assertCode(t, serve(router, "PUT", "/user/block/u-b", nil, "u-a"), 200)
+	if !h.blocks.Blocked("u-b", "u-a") {
+		t.Error("Want u-b blocked by u-a")
+	}
+	assertCode(t, serve(router, "DELETE", "/user/block/u-b", nil, "u-a"), 200)
+	if h.blocks.Blocked("u-b", "u-a") {
+		t.Error("Want u-b unblocked")
+	}
+}
+
+func TestDMHandlers(t *testing.T) {
+	s := memory.New(nil)
+	router := NewRouter(NewStoreHandler(s, nil))
+	registerAll(t, s, "u-a", "u-b")
+
+	w := serve(router, "PUT", "/user/dm/u-b", nil, "u-a")
+	assertCode(t, w, 200)
+	dm := Conversation{}
+	json.NewDecoder(w.Body).Decode(&dm)
+
+	// Whoever left only comes back by themselves
+	assertCode(t, serve(router, "DELETE", "/user/conversation/"+dm.ID+"/member", nil, "u-b"), 200)
+	assertCode(t, serve(router, "PUT", "/user/dm/u-b", nil, "u-a"), 200)
+	if _, err := s.Conversations().Member("u-b", dm.ID); err == nil {
+		t.Error("Want u-b left out of the DM")
+	}
+	assertCode(t, serve(router, "PUT", "/user/dm/u-a", nil, "u-b"), 200)
+	if _, err := s.Conversations().Member("u-b", dm.ID); err != nil {
+		t.Errorf("Want u-b back in the DM, got %v", err)
+	}
+
+	// Deleted DMs come back
+	if err := s.Conversations().Trash(dm.ID, "u-a"); err != nil {
+		t.Fatal(err)
+	}
+	w = serve(router, "PUT", "/user/dm/u-b", nil, "u-a")
+	assertCode(t, w, 200)
+	restored := Conversation{}
+	json.NewDecoder(w.Body).Decode(&restored)
+	if restored.ID != dm.ID {
+		t.Errorf("Want %s restored, got %s", dm.ID, restored.ID)
+	}
+}
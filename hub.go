@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sync"
+)
+
+// SlowConsumerPolicy decides what happens to a client whose buffer is full
+type SlowConsumerPolicy int
+
+const (
+	// DropMessage skips the message for that client only
+	DropMessage SlowConsumerPolicy = iota
+	// Disconnect unregisters the client and closes its Done channel
+	Disconnect
+)
+
+// Client is a single subscriber of a topic
+type Client struct {
+	User string
+
+	send chan []byte
+	done chan struct{}
+	once sync.Once
+}
+
+// Messages returns the channel messages for this client are delivered on
+func (c *Client) Messages() <-chan []byte {
+	return c.send
+}
+
+// Done is closed once the hub disconnects the client
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) close() {
+	c.once.Do(func() {
+		close(c.done)
+	})
+}
+
+// Hub fans out messages to the clients registered under a topic. It is safe
+// for concurrent use, and publishing never blocks on a client.
+type Hub struct {
+	bufferSize int
+	policy     SlowConsumerPolicy
+
+	mu     sync.RWMutex
+	topics map[string]map[*Client]bool
+}
+
+func NewHub(bufferSize int, policy SlowConsumerPolicy) *Hub {
+	return &Hub{
+		bufferSize: bufferSize,
+		policy:     policy,
+		topics:     make(map[string]map[*Client]bool),
+	}
+}
+
+// Register creates a client for user subscribed to topic
+func (h *Hub) Register(topic string, user string) *Client {
+	client := &Client{
+		User: user,
+		send: make(chan []byte, h.bufferSize),
+		done: make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	clients, ok := h.topics[topic]
+	if !ok {
+		clients = make(map[*Client]bool)
+		h.topics[topic] = clients
+	}
+	clients[client] = true
+
+	return client
+}
+
+// Unregister removes a client from topic. It is safe to call more than once.
+func (h *Hub) Unregister(topic string, client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.unregister(topic, client)
+}
+
+func (h *Hub) unregister(topic string, client *Client) {
+	if clients, ok := h.topics[topic]; ok {
+		delete(clients, client)
+		if len(clients) < 1 {
+			delete(h.topics, topic)
+		}
+	}
+	client.close()
+}
+
+// Publish sends msg to every client of topic whose user passes allow. A nil
+// allow delivers to everyone.
+func (h *Hub) Publish(topic string, msg []byte, allow func(user string) bool) {
+	slow := make([]*Client, 0)
+
+	h.mu.RLock()
+	for client := range h.topics[topic] {
+		if allow != nil && !allow(client.User) {
+			continue
+		}
+		select {
+		case client.send <- msg:
+		default:
+			slow = append(slow, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(slow) < 1 || h.policy != Disconnect {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, client := range slow {
+		h.unregister(topic, client)
+	}
+}
+
+// Clients returns the number of clients registered under topic
+func (h *Hub) Clients(topic string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.topics[topic])
+}
@@ -0,0 +1,148 @@
+// +build unit
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHubPublish(t *testing.T) {
+	hub := NewHub(4, DropMessage)
+
+	a := hub.Register("topic", "u-a")
+	b := hub.Register("topic", "u-b")
+	other := hub.Register("other", "u-a")
+
+	hub.Publish("topic", []byte("hello"), func(user string) bool {
+		return user == "u-a"
+	})
+
+	select {
+	case msg := <-a.Messages():
+		if string(msg) != "hello" {
+			t.Errorf("Want message hello, got %s", msg)
+		}
+	default:
+		t.Error("Want a message for allowed client, got none")
+	}
+	if len(b.Messages()) != 0 {
+		t.Error("Want no message for filtered client, got one")
+	}
+	if len(other.Messages()) != 0 {
+		t.Error("Want no message for client of another topic, got one")
+	}
+}
+
+func TestHubSlowConsumer(t *testing.T) {
+	t.Run("DropMessage", func(t *testing.T) {
+		hub := NewHub(2, DropMessage)
+		client := hub.Register("topic", "u-a")
+
+		for i := 0; i < 5; i += 1 {
+			hub.Publish("topic", []byte("msg"), nil)
+		}
+
+		if got, want := len(client.Messages()), 2; got != want {
+			t.Errorf("Want %d buffered messages, got %d", want, got)
+		}
+		if got, want := hub.Clients("topic"), 1; got != want {
+			t.Errorf("Want %d clients, got %d", want, got)
+		}
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		hub := NewHub(2, Disconnect)
+		client := hub.Register("topic", "u-a")
+
+		for i := 0; i < 5; i += 1 {
+			hub.Publish("topic", []byte("msg"), nil)
+		}
+
+		select {
+		case <-client.Done():
+		default:
+			t.Error("Want slow client to be disconnected")
+		}
+		if got, want := hub.Clients("topic"), 0; got != want {
+			t.Errorf("Want %d clients, got %d", want, got)
+		}
+
+		// Unregistering after a disconnect is harmless
+		hub.Unregister("topic", client)
+	})
+}
+
+func TestHubStress(t *testing.T) {
+	const (
+		subscribers = 5000
+		topics      = 10
+		messages    = 50
+	)
+
+	hub := NewHub(messages, Disconnect)
+
+	var ready, done sync.WaitGroup
+	ready.Add(subscribers)
+	done.Add(subscribers)
+	received := make([]int, subscribers)
+	start := make(chan struct{})
+
+	for i := 0; i < subscribers; i += 1 {
+		go func(i int) {
+			defer done.Done()
+			topic := fmt.Sprintf("topic-%d", i%topics)
+			client := hub.Register(topic, fmt.Sprintf("u-%d", i))
+			defer hub.Unregister(topic, client)
+			ready.Done()
+
+			<-start
+			timeout := time.After(10 * time.Second)
+			for received[i] < messages {
+				select {
+				case <-client.Messages():
+					received[i] += 1
+				case <-client.Done():
+					return
+				case <-timeout:
+					return
+				}
+			}
+		}(i)
+	}
+
+	// Churn registrations while publishing
+	var churn sync.WaitGroup
+	churn.Add(1)
+	go func() {
+		defer churn.Done()
+		for i := 0; i < 1000; i += 1 {
+			topic := fmt.Sprintf("topic-%d", i%topics)
+			hub.Unregister(topic, hub.Register(topic, "u-churn"))
+		}
+	}()
+
+	ready.Wait()
+	close(start)
+	for m := 0; m < messages; m += 1 {
+		for i := 0; i < topics; i += 1 {
+			hub.Publish(fmt.Sprintf("topic-%d", i), []byte("msg"), nil)
+		}
+	}
+
+	churn.Wait()
+	done.Wait()
+
+	for i, got := range received {
+		if got != messages {
+			t.Fatalf("Want subscriber %d to receive %d messages, got %d", i, messages, got)
+		}
+	}
+	for i := 0; i < topics; i += 1 {
+		if got := hub.Clients(fmt.Sprintf("topic-%d", i)); got != 0 {
+			t.Errorf("Want no clients left on topic-%d, got %d", i, got)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"backend/core/event"
+	"backend/core/store"
+)
+
+// How long invites stay open, set with INVITE_TTL
+const defaultInviteTTL = 7 * 24 * time.Hour
+
+var inviteTTL = defaultInviteTTL
+
+// inviteMember invites a user who isn't a contact of the admin into a
+// conversation, as part of CreateConversationMember. Inviting a user again
+// renews the pending invite. It commits tx.
+func (h *Handler) inviteMember(w http.ResponseWriter, r *http.Request, tx store.Tx, userID string, conversationID string, memberID string) {
+	// Check
+	_, err := tx.Conversations().Role(memberID, conversationID)
+	switch {
+	case err == nil:
+		writeError(w, r, errAlreadyMember)
+		return
+	case err != store.ErrNotFound:
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = tx.Users().Get(memberID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Response object
+	invite := Invite{
+		ID:           "i-" + RandomHex(),
+		Conversation: conversationID,
+		User:         memberID,
+		Inviter:      userID,
+	}
+
+	// Insert
+	err = tx.Conversations().Invite(&invite, inviteTTL)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs
+	err = tx.Enqueue(event.InviteCreated, userID, &invite)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(invite)
+}
+
+func (h *Handler) GetInvites(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	page, err := ParsePage(r, 2)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Validate cursor, most recent first
+	var after *Invite
+	if !page.First() {
+		after = &Invite{ID: page.Key(1, "")}
+		after.CreatedAt, err = time.Parse(time.RFC3339Nano, page.Key(0, ""))
+		if err != nil {
+			writeError(w, r, errInvalidPage)
+			return
+		}
+	}
+
+	// Select
+	rows, err := h.store.Conversations().Invites(userID, after, page.Fetch())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Response object
+	invites := make([]Invite, 0)
+	for _, invite := range rows {
+		if page.More(len(invites) + 1) {
+			last := invites[len(invites)-1]
+			SetNext(w, r, page, last.CreatedAt.Format(time.RFC3339Nano), last.ID)
+			break
+		}
+		invites = append(invites, invite)
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+func (h *Handler) GetConversationInvites(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+	page, err := ParsePage(r, 2)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Validate cursor, most recent first
+	var after *Invite
+	if !page.First() {
+		after = &Invite{ID: page.Key(1, "")}
+		after.CreatedAt, err = time.Parse(time.RFC3339Nano, page.Key(0, ""))
+		if err != nil {
+			writeError(w, r, errInvalidPage)
+			return
+		}
+	}
+
+	// Check
+	role, err := h.store.Conversations().Role(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case !canAdministrate(role):
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	// Select
+	rows, err := h.store.Conversations().ConversationInvites(conversationID, after, page.Fetch())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Response object
+	invites := make([]Invite, 0)
+	for _, invite := range rows {
+		if page.More(len(invites) + 1) {
+			last := invites[len(invites)-1]
+			SetNext(w, r, page, last.CreatedAt.Format(time.RFC3339Nano), last.ID)
+			break
+		}
+		invites = append(invites, invite)
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+func (h *Handler) AcceptInvite(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	inviteID := p.ByName("invite")
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Delete, an invite is only ever answered once
+	invite, err := tx.Conversations().AnswerInvite(inviteID, userID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Insert, unless the user was added in the meantime
+	member := Member{
+		User:         userID,
+		Conversation: invite.Conversation,
+		Pinned:       false, // default
+		Role:         RoleMember,
+	}
+	err = tx.Conversations().AddMember(member)
+	added := err == nil
+	if err != nil && err != store.ErrConflict {
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs
+	err = tx.Enqueue(event.InviteAccepted, userID, &invite)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if added {
+		err = tx.Enqueue(event.MemberCreated, userID, &member)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	// Respond
+	w.Write([]byte(invite.Conversation))
+}
+
+func (h *Handler) DeclineInvite(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	inviteID := p.ByName("invite")
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Delete
+	invite, err := tx.Conversations().AnswerInvite(inviteID, userID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs
+	err = tx.Enqueue(event.InviteDeclined, userID, &invite)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	w.WriteHeader(200)
+}
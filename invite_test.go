@@ -0,0 +1,131 @@
+// +build integration
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestInvite(t *testing.T) {
+	db := connect()
+	defer db.Close()
+	h := NewHandler(db, nil)
+	r := NewRouter(h)
+
+	t.Run("Invite", testInvite(db, h, r))
+}
+
+func testInvite(db *sql.DB, h *Handler, router http.Handler) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		users := []User{}
+		for i, phone := range []string{"+65 9999 6001", "+65 9999 6002", "+65 9999 6003"} {
+			w := serve(router, "POST", "/user", &User{PhoneNumber: phone, FirstName: "Invite", LastName: []string{"Admin", "Stranger", "Contact"}[i]}, "")
+			assertCode(t, w, 200)
+			user := User{}
+			json.NewDecoder(w.Body).Decode(&user)
+			users = append(users, user)
+		}
+		admin, stranger, contact := users[0].ID, users[1].ID, users[2].ID
+		assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: users[2].PhoneNumber}, admin), 200)
+
+		w := serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom("Test Invite")}, admin)
+		assertCode(t, w, 200)
+		conversation := Conversation{}
+		json.NewDecoder(w.Body).Decode(&conversation)
+		base := "/user/conversation/" + conversation.ID
+
+		// Contacts are added directly
+		assertCode(t, serve(router, "POST", base+"/member", &User{ID: contact}, admin), 200)
+		assertDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2`, contact, conversation.ID)
+
+		// Others are invited
+		w = serve(router, "POST", base+"/member", &User{ID: stranger}, admin)
+		assertCode(t, w, 202)
+		invite := Invite{}
+		json.NewDecoder(w.Body).Decode(&invite)
+		if invite.User != stranger || invite.Inviter != admin || invite.Conversation != conversation.ID || !invite.ExpiresAt.After(invite.CreatedAt) {
+			t.Errorf("Want an open invite of %s by %s, got %v", stranger, admin, invite)
+		}
+		assertNoDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2`, stranger, conversation.ID)
+
+		// Inviting again renews the same invite
+		w = serve(router, "POST", base+"/member", &User{ID: stranger}, admin)
+		assertCode(t, w, 202)
+		renewed := Invite{}
+		json.NewDecoder(w.Body).Decode(&renewed)
+		if renewed.ID != invite.ID {
+			t.Errorf("Want invite %s renewed, got %s", invite.ID, renewed.ID)
+		}
+
+		// List
+		w = serve(router, "GET", "/user/invite", nil, stranger)
+		assertCode(t, w, 200)
+		invites := []Invite{}
+		json.NewDecoder(w.Body).Decode(&invites)
+		if len(invites) != 1 || invites[0].ID != invite.ID || invites[0].Title != conversation.Title {
+			t.Errorf("Want invite %s, got %v", invite.ID, invites)
+		}
+		w = serve(router, "GET", base+"/invite", nil, admin)
+		assertCode(t, w, 200)
+		json.NewDecoder(w.Body).Decode(&invites)
+		if len(invites) != 1 || invites[0].ID != invite.ID {
+			t.Errorf("Want invite %s, got %v", invite.ID, invites)
+		}
+		assertCode(t, serve(router, "GET", base+"/invite", nil, contact), 403)
+
+		// Only the invited user answers
+		assertCode(t, serve(router, "POST", "/user/invite/"+invite.ID+"/accept", nil, contact), 404)
+
+		// Accept
+		assertCode(t, serve(router, "POST", "/user/invite/"+invite.ID+"/accept", nil, stranger), 200)
+		assertDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2 AND "role" = $3`, stranger, conversation.ID, RoleMember)
+		assertNoDB(t, db, `SELECT * FROM invite WHERE id = $1`, invite.ID)
+		assertCode(t, serve(router, "POST", "/user/invite/"+invite.ID+"/accept", nil, stranger), 404)
+		assertCode(t, serve(router, "POST", base+"/member", &User{ID: stranger}, admin), 409)
+
+		// Decline
+		assertCode(t, serve(router, "DELETE", base+"/member", nil, stranger), 200)
+		w = serve(router, "POST", base+"/member", &User{ID: stranger}, admin)
+		assertCode(t, w, 202)
+		json.NewDecoder(w.Body).Decode(&invite)
+		assertCode(t, serve(router, "POST", "/user/invite/"+invite.ID+"/decline", nil, stranger), 200)
+		assertNoDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2`, stranger, conversation.ID)
+
+		// Expired invites are gone
+		w = serve(router, "POST", base+"/member", &User{ID: stranger}, admin)
+		assertCode(t, w, 202)
+		json.NewDecoder(w.Body).Decode(&invite)
+		_, err := db.Exec(`UPDATE invite SET expires_at = NOW() WHERE id = $1`, invite.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = serve(router, "GET", "/user/invite", nil, stranger)
+		assertCode(t, w, 200)
+		json.NewDecoder(w.Body).Decode(&invites)
+		if len(invites) != 0 {
+			t.Errorf("Want no invites, got %v", invites)
+		}
+		assertCode(t, serve(router, "POST", "/user/invite/"+invite.ID+"/accept", nil, stranger), 404)
+
+		// And purged later on
+		if _, err := h.purgeInvites(); err != nil {
+			t.Fatal(err)
+		}
+		assertNoDB(t, db, `SELECT * FROM invite WHERE id = $1`, invite.ID)
+
+		// Invites into deleted conversations can't be accepted
+		w = serve(router, "POST", base+"/member", &User{ID: stranger}, admin)
+		assertCode(t, w, 202)
+		json.NewDecoder(w.Body).Decode(&invite)
+		assertCode(t, serve(router, "DELETE", base, nil, admin), 200)
+		assertCode(t, serve(router, "POST", "/user/invite/"+invite.ID+"/accept", nil, stranger), 404)
+
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"backend/core/event"
+	"backend/core/store"
+)
+
+func (h *Handler) GetLastHeard(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+
+	// Response object
+	lastHeard := LastHeard{
+		User:         userID,
+		Conversation: conversationID,
+	}
+
+	// Select, conversations that were never heard are at 0
+	var err error
+	lastHeard.LastHeard, err = h.store.Conversations().LastHeard(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lastHeard)
+}
+
+func (h *Handler) SetLastHeard(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+	lastHeard := LastHeard{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&lastHeard)
+	if err != nil {
+		writeError(w, r, malformed(err))
+		return
+	}
+
+	// Validate
+	if lastHeard.LastHeard < 0 {
+		writeError(w, r, invalid(FieldError{"lastheard", FieldInvalid, "can't be negative"}))
+		return
+	}
+	lastHeard.User = userID
+	lastHeard.Conversation = conversationID
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Update, never moving backwards so devices that are behind can't undo
+	// what another device has heard
+	previous, err := tx.Conversations().LastHeard(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+	if lastHeard.LastHeard <= previous {
+		lastHeard.LastHeard = previous
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lastHeard)
+		return
+	}
+
+	err = tx.Conversations().SetLastHeard(userID, conversationID, lastHeard.LastHeard)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs
+	err = tx.Enqueue(event.MemberLastHeard, userID, &lastHeard)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lastHeard)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"backend/core/event"
+	"backend/core/store"
+)
+
+func (h *Handler) CreateInviteLink(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+	link := InviteLink{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&link)
+	if err != nil {
+		writeError(w, r, malformed(err))
+		return
+	}
+
+	// Validate, links that can never be used are useless
+	violations := make([]FieldError, 0)
+	if link.UsesLeft.Valid && link.UsesLeft.Int64 < 1 {
+		violations = append(violations, FieldError{"uses_left", FieldInvalid, "must be at least 1"})
+	}
+	if link.ExpiresAt.Valid && !link.ExpiresAt.Time.After(time.Now()) {
+		violations = append(violations, FieldError{"expires_at", FieldInvalid, "must be in the future"})
+	}
+	if len(violations) > 0 {
+		writeError(w, r, invalid(violations...))
+		return
+	}
+	link.Token = "l-" + RandomHex()
+	link.Conversation = conversationID
+	link.Creator = userID
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Check
+	role, err := tx.Conversations().Role(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case !canAdministrate(role):
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	// DMs stay between their two users
+	conversation, err := tx.Conversations().Get(userID, conversationID)
+	switch {
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case conversation.DM:
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	// Insert
+	err = tx.Conversations().CreateLink(&link)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(link)
+}
+
+func (h *Handler) GetInviteLinks(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+	page, err := ParsePage(r, 2)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Validate cursor, most recent first
+	var after *InviteLink
+	if !page.First() {
+		after = &InviteLink{Token: page.Key(1, "")}
+		after.CreatedAt, err = time.Parse(time.RFC3339Nano, page.Key(0, ""))
+		if err != nil {
+			writeError(w, r, errInvalidPage)
+			return
+		}
+	}
+
+	// Check
+	role, err := h.store.Conversations().Role(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case !canAdministrate(role):
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	// Select, including links that ran out so admins can tell
+	rows, err := h.store.Conversations().Links(conversationID, after, page.Fetch())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Response object
+	links := make([]InviteLink, 0)
+	for _, link := range rows {
+		if page.More(len(links) + 1) {
+			last := links[len(links)-1]
+			SetNext(w, r, page, last.CreatedAt.Format(time.RFC3339Nano), last.Token)
+			break
+		}
+		links = append(links, link)
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+func (h *Handler) RevokeInviteLink(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+	token := p.ByName("token")
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Check
+	role, err := tx.Conversations().Role(userID, conversationID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case !canAdministrate(role):
+		writeStatus(w, r, http.StatusForbidden)
+		return
+	}
+
+	// Delete
+	err = tx.Conversations().RevokeLink(conversationID, token)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(200)
+}
+
+func (h *Handler) RedeemInviteLink(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	token := p.ByName("token")
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Check, members redeeming a link again don't use it up
+	link, err := tx.Conversations().Link(token)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+	_, err = tx.Conversations().Role(userID, link.Conversation)
+	switch {
+	case err == nil:
+		w.Write([]byte(link.Conversation))
+		return
+	case err != store.ErrNotFound:
+		writeError(w, r, err)
+		return
+	}
+
+	// Update
+	err = tx.Conversations().UseLink(token)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Insert, unless the user joined in the meantime
+	member := Member{
+		User:         userID,
+		Conversation: link.Conversation,
+		Pinned:       false, // default
+		Role:         RoleMember,
+	}
+	err = tx.Conversations().AddMember(member)
+	switch {
+	case err == store.ErrConflict:
+		// Rolling back gives the use back
+		w.Write([]byte(link.Conversation))
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs
+	err = tx.Enqueue(event.MemberCreated, userID, &member)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	// Respond
+	w.Write([]byte(link.Conversation))
+}
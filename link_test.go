@@ -0,0 +1,115 @@
+// +build integration
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestInviteLink(t *testing.T) {
+	db := connect()
+	defer db.Close()
+	h := NewHandler(db, nil)
+	r := NewRouter(h)
+
+	t.Run("InviteLink", testInviteLink(db, r))
+}
+
+func testInviteLink(db *sql.DB, router http.Handler) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		users := []User{}
+		for i := 0; i < 6; i++ {
+			w := serve(router, "POST", "/user", &User{PhoneNumber: fmt.Sprintf("+65 9999 70%02d", i), FirstName: "Link", LastName: fmt.Sprintf("User %d", i)}, "")
+			assertCode(t, w, 200)
+			user := User{}
+			json.NewDecoder(w.Body).Decode(&user)
+			users = append(users, user)
+		}
+		admin, member := users[0].ID, users[1].ID
+
+		w := serve(router, "POST", "/user/conversation", &Conversation{Title: null.StringFrom("Test Link")}, admin)
+		assertCode(t, w, 200)
+		conversation := Conversation{}
+		json.NewDecoder(w.Body).Decode(&conversation)
+		base := "/user/conversation/" + conversation.ID
+
+		// Create
+		assertCode(t, serve(router, "POST", base+"/link", &InviteLink{UsesLeft: null.IntFrom(0)}, admin), 400)
+		assertCode(t, serve(router, "POST", base+"/link", &InviteLink{ExpiresAt: null.TimeFrom(time.Now().Add(-time.Minute))}, admin), 400)
+		assertCode(t, serve(router, "POST", base+"/link", &InviteLink{}, member), 404)
+		w = serve(router, "POST", base+"/link", &InviteLink{UsesLeft: null.IntFrom(2)}, admin)
+		assertCode(t, w, 200)
+		link := InviteLink{}
+		json.NewDecoder(w.Body).Decode(&link)
+
+		// Redeem
+		assertCode(t, serve(router, "POST", "/user/link/l-nothing", nil, member), 404)
+		assertCode(t, serve(router, "POST", "/user/link/"+link.Token, nil, member), 200)
+		assertDB(t, db, `SELECT * FROM member WHERE "user" = $1 AND "conversation" = $2 AND "role" = $3`, member, conversation.ID, RoleMember)
+
+		// Members can't administrate links
+		assertCode(t, serve(router, "GET", base+"/link", nil, member), 403)
+		assertCode(t, serve(router, "DELETE", base+"/link/"+link.Token, nil, member), 403)
+
+		// Redeeming again doesn't use the link up
+		assertCode(t, serve(router, "POST", "/user/link/"+link.Token, nil, member), 200)
+		assertDB(t, db, `SELECT * FROM invite_link WHERE token = $1 AND uses_left = 1`, link.Token)
+
+		// The last use only goes once, concurrently
+		var joined int64
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, user := range users[2:] {
+			wg.Add(1)
+			go func(user string) {
+				defer wg.Done()
+				w := serve(router, "POST", "/user/link/"+link.Token, nil, user)
+				if w.Code == 200 {
+					mu.Lock()
+					joined += 1
+					mu.Unlock()
+				}
+			}(user.ID)
+		}
+		wg.Wait()
+		if joined != 1 {
+			t.Errorf("Want 1 user to join, got %d", joined)
+		}
+		assertDB(t, db, `SELECT * FROM invite_link WHERE token = $1 AND uses_left = 0`, link.Token)
+
+		// List
+		w = serve(router, "GET", base+"/link", nil, admin)
+		assertCode(t, w, 200)
+		links := []InviteLink{}
+		json.NewDecoder(w.Body).Decode(&links)
+		if len(links) != 1 || links[0].Token != link.Token || links[0].UsesLeft != null.IntFrom(0) {
+			t.Errorf("Want link %s used up, got %v", link.Token, links)
+		}
+
+		// Revoke
+		w = serve(router, "POST", base+"/link", &InviteLink{}, admin)
+		assertCode(t, w, 200)
+		json.NewDecoder(w.Body).Decode(&link)
+		assertCode(t, serve(router, "DELETE", base+"/link/"+link.Token, nil, admin), 200)
+		assertCode(t, serve(router, "DELETE", base+"/link/"+link.Token, nil, admin), 404)
+		assertCode(t, serve(router, "POST", "/user/link/"+link.Token, nil, users[5].ID), 404)
+
+		// Links of deleted conversations can't be redeemed
+		w = serve(router, "POST", base+"/link", &InviteLink{}, admin)
+		assertCode(t, w, 200)
+		json.NewDecoder(w.Body).Decode(&link)
+		assertCode(t, serve(router, "DELETE", base, nil, admin), 200)
+		assertCode(t, serve(router, "POST", "/user/link/"+link.Token, nil, users[5].ID), 404)
+
+	}
+}
@@ -2,13 +2,21 @@ package main
 
 import (
 	"database/sql"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/nats-io/go-nats"
+
+	"backend/core/event"
+	"backend/core/migrations"
+	"backend/core/store/memory"
 )
 
 var listen string
@@ -23,14 +31,60 @@ func init() {
 }
 
 func main() {
+	flag.Parse()
+	switch flag.Arg(0) {
+	case "": // serve
+	case "migrate":
+		migrate(flag.Args()[1:])
+		return
+	case "fixtures":
+		err := migrations.Seed(open())
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Print("applied fixtures")
+		return
+	default:
+		log.Fatalf("unknown command %s", flag.Arg(0))
+	}
+
 	listen = os.Getenv("LISTEN")
 
-	// Database
-	db := connect()
+	// Invites
+	if ttl := os.Getenv("INVITE_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid INVITE_TTL %s", ttl)
+		}
+		inviteTTL = d
+	}
+
+	// Deleted conversations
+	if retention := os.Getenv("CONVERSATION_RETENTION"); retention != "" {
+		d, err := time.ParseDuration(retention)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid CONVERSATION_RETENTION %s", retention)
+		}
+		conversationRetention = d
+	}
+
 	// NATs
 	nc := connectNats()
 	// Handler
-	h := NewHandler(db, nc)
+	var h *Handler
+	switch backend := os.Getenv("STORE"); backend {
+	case "", "postgres":
+		h = NewHandler(connect(), nc)
+	case "memory":
+		log.Print("keeping everything in memory, nothing will be persisted")
+		var publisher event.Publisher
+		if nc != nil {
+			publisher = nc
+		}
+		h = NewStoreHandler(memory.New(publisher), nc)
+	default:
+		log.Fatalf("unknown STORE %s", backend)
+	}
 	// Routes
 	router := NewRouter(h)
 
@@ -39,6 +93,21 @@ func main() {
 }
 
 func connect() *sql.DB {
+	db := open()
+
+	// Apply pending migrations, instances take turns
+	if migrate, _ := strconv.ParseBool(os.Getenv("MIGRATE")); migrate {
+		n, err := migrations.Up(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("applied %d migrations", n)
+	}
+
+	return db
+}
+
+func open() *sql.DB {
 	postgres = os.Getenv("POSTGRES")
 
 	// Open postgres
@@ -55,6 +124,65 @@ func connect() *sql.DB {
 	return db
 }
 
+func migrate(args []string) {
+	if len(args) < 1 {
+		log.Fatal("usage: core migrate up|down [n]|status|force <version>")
+	}
+
+	db := open()
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		n, err := migrations.Up(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("applied %d migrations", n)
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			var err error
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatalf("invalid count %s", args[1])
+			}
+		}
+		n, err := migrations.Down(db, n)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("reverted %d migrations", n)
+	case "status":
+		statuses, err := migrations.List(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+	case "force":
+		if len(args) < 2 {
+			log.Fatal("usage: core migrate force <version>")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			log.Fatalf("invalid version %s", args[1])
+		}
+		err = migrations.Force(db, version)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("forced version %d", version)
+	default:
+		log.Fatal("usage: core migrate up|down [n]|status|force <version>")
+	}
+}
+
 func connectNats() *nats.Conn {
 	natsHost := os.Getenv("NATS")
 	var nc *nats.Conn
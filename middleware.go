@@ -13,18 +13,20 @@ type RawClient struct {
 	ClientId string `json:"clientid"`
 }
 
+var errMissingClaim = &Error{Status: http.StatusBadRequest, Code: CodeMissingClaim, Message: "missing or invalid X-User-Claim"}
+
 func AuthMiddleware(next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		ua := r.Header.Get("X-User-Claim")
 		if ua == "" {
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			writeError(w, r, errMissingClaim)
 			return
 		}
 
 		var client RawClient
 		err := json.Unmarshal([]byte(ua), &client)
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			writeError(w, r, errMissingClaim)
 			return
 		}
 
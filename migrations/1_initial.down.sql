@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS notify_permissions_delete ON "member";
+DROP TRIGGER IF EXISTS notify_permissions_new ON "member";
+DROP FUNCTION IF EXISTS notify_permissions_delete ();
+DROP FUNCTION IF EXISTS notify_permissions_new ();
+
+DROP TABLE IF EXISTS pinned_conversation;
+DROP TABLE IF EXISTS contact;
+DROP TABLE IF EXISTS member;
+DROP TABLE IF EXISTS "conversation";
+DROP TABLE IF EXISTS "user";
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS member_owner;
+ALTER TABLE member DROP COLUMN IF EXISTS "role";
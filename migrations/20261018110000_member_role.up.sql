@@ -0,0 +1,6 @@
+ALTER TABLE member ADD COLUMN IF NOT EXISTS "role" VARCHAR(16) NOT NULL DEFAULT 'member' CHECK ("role" IN ('owner', 'admin', 'member'));
+
+/* Conversations used to have no owners, and let every member administrate them */
+UPDATE member SET "role" = 'admin';
+
+CREATE UNIQUE INDEX IF NOT EXISTS member_owner ON member ("conversation") WHERE "role" = 'owner';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS dm;
+ALTER TABLE "conversation" DROP COLUMN IF EXISTS dm;
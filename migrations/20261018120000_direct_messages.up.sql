@@ -0,0 +1,10 @@
+ALTER TABLE "conversation" ADD COLUMN IF NOT EXISTS dm BOOLEAN NOT NULL DEFAULT FALSE;
+
+/* At most one DM per pair of users, stored with usera < userb */
+CREATE TABLE IF NOT EXISTS dm (
+	"conversation" BYTEA PRIMARY KEY REFERENCES "conversation"(id) ON DELETE CASCADE,
+	usera BYTEA NOT NULL REFERENCES "user"(id),
+	userb BYTEA NOT NULL REFERENCES "user"(id),
+	CHECK (usera < userb),
+	UNIQUE (usera, userb)
+);
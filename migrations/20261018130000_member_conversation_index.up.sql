@@ -0,0 +1,2 @@
+/* member is otherwise only indexed by ("user", "conversation") */
+CREATE INDEX IF NOT EXISTS member_conversation ON member ("conversation");
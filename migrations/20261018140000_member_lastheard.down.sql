@@ -0,0 +1 @@
+ALTER TABLE member DROP COLUMN IF EXISTS lastheard;
@@ -0,0 +1,2 @@
+/* Unix time in milliseconds up to which the member has heard the conversation */
+ALTER TABLE member ADD COLUMN IF NOT EXISTS lastheard BIGINT;
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS hash_phone_number ON "user";
+DROP FUNCTION IF EXISTS hash_phone_number ();
+
+DROP INDEX IF EXISTS user_phone_hash;
+ALTER TABLE "user" DROP COLUMN IF EXISTS phone_hash;
+
+DROP EXTENSION IF EXISTS pgcrypto;
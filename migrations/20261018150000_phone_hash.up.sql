@@ -0,0 +1,23 @@
+/* Truncated SHA-256 of the E.164 form of phone numbers, for contact discovery */
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS phone_hash BYTEA;
+CREATE INDEX IF NOT EXISTS user_phone_hash ON "user" (phone_hash);
+
+/* Phone numbers are stored in international format, which is E.164 with spaces and dashes */
+CREATE OR REPLACE FUNCTION hash_phone_number () RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.phone_hash := substring(digest(regexp_replace(NEW.phone_number, '[^0-9+]', '', 'g'), 'sha256') FROM 1 FOR 8);
+		RETURN NEW;
+	END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS hash_phone_number ON "user";
+CREATE TRIGGER hash_phone_number
+	BEFORE INSERT OR UPDATE OF phone_number
+	ON "user"
+	FOR EACH ROW
+		EXECUTE PROCEDURE hash_phone_number();
+
+/* Existing users */
+UPDATE "user" SET phone_number = phone_number WHERE phone_hash IS NULL;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS user_name;
+
+DROP TRIGGER IF EXISTS touch_member_conversation ON member;
+DROP TRIGGER IF EXISTS touch_conversation ON "conversation";
+DROP FUNCTION IF EXISTS touch_member_conversation ();
+DROP FUNCTION IF EXISTS touch_conversation ();
+
+ALTER TABLE "conversation" DROP COLUMN IF EXISTS active_at;
@@ -0,0 +1,37 @@
+/* When the conversation or its members last changed, to list recent conversations first */
+ALTER TABLE "conversation" ADD COLUMN IF NOT EXISTS active_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE OR REPLACE FUNCTION touch_conversation () RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.active_at := NOW();
+		RETURN NEW;
+	END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION touch_member_conversation () RETURNS TRIGGER AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			UPDATE "conversation" SET active_at = NOW() WHERE id = OLD."conversation";
+		ELSE
+			UPDATE "conversation" SET active_at = NOW() WHERE id = NEW."conversation";
+		END IF;
+		RETURN NULL;
+	END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS touch_conversation ON "conversation";
+CREATE TRIGGER touch_conversation
+	BEFORE UPDATE OF title, picture
+	ON "conversation"
+	FOR EACH ROW
+		EXECUTE PROCEDURE touch_conversation();
+
+DROP TRIGGER IF EXISTS touch_member_conversation ON member;
+CREATE TRIGGER touch_member_conversation
+	AFTER INSERT OR DELETE
+	ON member
+	FOR EACH ROW
+		EXECUTE PROCEDURE touch_member_conversation();
+
+/* Contacts and members are listed by name */
+CREATE INDEX IF NOT EXISTS user_name ON "user" (first_name, last_name, id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS user_full_name_trgm;
+DROP INDEX IF EXISTS user_last_name_trgm;
+DROP INDEX IF EXISTS user_first_name_trgm;
+DROP INDEX IF EXISTS user_username_trgm;
+
+DROP EXTENSION IF EXISTS pg_trgm;
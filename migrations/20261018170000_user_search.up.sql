@@ -0,0 +1,7 @@
+/* Trigram indexes for prefix and fuzzy user search */
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS user_username_trgm ON "user" USING GIN (username gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS user_first_name_trgm ON "user" USING GIN (first_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS user_last_name_trgm ON "user" USING GIN (last_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS user_full_name_trgm ON "user" USING GIN ((first_name || ' ' || last_name) gin_trgm_ops);
@@ -0,0 +1,3 @@
+ALTER TABLE "user" DROP COLUMN IF EXISTS profile_pic_visibility;
+ALTER TABLE "user" DROP COLUMN IF EXISTS bio_visibility;
+ALTER TABLE "user" DROP COLUMN IF EXISTS phone_number_visibility;
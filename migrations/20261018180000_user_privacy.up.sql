@@ -0,0 +1,7 @@
+/* Who can see each private field of a user: everyone, contacts or nobody */
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS phone_number_visibility VARCHAR(16) NOT NULL DEFAULT 'contacts'
+	CHECK (phone_number_visibility IN ('everyone', 'contacts', 'nobody'));
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS bio_visibility VARCHAR(16) NOT NULL DEFAULT 'everyone'
+	CHECK (bio_visibility IN ('everyone', 'contacts', 'nobody'));
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS profile_pic_visibility VARCHAR(16) NOT NULL DEFAULT 'everyone'
+	CHECK (profile_pic_visibility IN ('everyone', 'contacts', 'nobody'));
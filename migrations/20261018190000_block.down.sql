@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS notify_block_delete ON block;
+DROP TRIGGER IF EXISTS notify_block_new ON block;
+DROP FUNCTION IF EXISTS notify_block_delete ();
+DROP FUNCTION IF EXISTS notify_block_new ();
+
+DROP TABLE IF EXISTS block;
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS block (
+	blocker BYTEA REFERENCES "user"(id),
+	blocked BYTEA REFERENCES "user"(id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (blocker, blocked),
+	CHECK (blocker <> blocked)
+);
+
+CREATE INDEX IF NOT EXISTS block_blocked ON block (blocked);
+
+CREATE OR REPLACE FUNCTION notify_block_new () RETURNS TRIGGER AS $$
+	BEGIN
+		PERFORM pg_notify('block_new', CONCAT(NEW.blocker, '+', NEW.blocked));
+		RETURN NULL;
+	END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_block_delete () RETURNS TRIGGER AS $$
+	BEGIN
+		PERFORM pg_notify('block_delete', CONCAT(OLD.blocker, '+', OLD.blocked));
+		RETURN NULL;
+	END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notify_block_new ON block;
+CREATE TRIGGER notify_block_new
+	AFTER INSERT
+	ON block
+	FOR EACH ROW
+		EXECUTE PROCEDURE notify_block_new();
+
+DROP TRIGGER IF EXISTS notify_block_delete ON block;
+CREATE TRIGGER notify_block_delete
+	AFTER DELETE
+	ON block
+	FOR EACH ROW
+		EXECUTE PROCEDURE notify_block_delete();
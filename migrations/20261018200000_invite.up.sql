@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS invite (
+	id BYTEA PRIMARY KEY,
+	"conversation" BYTEA NOT NULL REFERENCES "conversation"(id) ON DELETE CASCADE,
+	"user" BYTEA NOT NULL REFERENCES "user"(id),
+	inviter BYTEA NOT NULL REFERENCES "user"(id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	UNIQUE ("conversation", "user")
+);
+
+CREATE INDEX IF NOT EXISTS invite_user ON invite ("user", created_at DESC, id DESC);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS invite_link (
+	token BYTEA PRIMARY KEY,
+	"conversation" BYTEA NOT NULL REFERENCES "conversation"(id) ON DELETE CASCADE,
+	creator BYTEA NOT NULL REFERENCES "user"(id),
+	uses_left INTEGER CHECK (uses_left >= 0),
+	expires_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS invite_link_conversation ON invite_link ("conversation", created_at DESC, token DESC);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS conversation_deleted;
+ALTER TABLE "conversation" DROP COLUMN IF EXISTS deleted_by;
+ALTER TABLE "conversation" DROP COLUMN IF EXISTS deleted_at;
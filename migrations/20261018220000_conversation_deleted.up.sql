@@ -0,0 +1,5 @@
+/* Deleted conversations are kept for a while so they can be restored */
+ALTER TABLE "conversation" ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE "conversation" ADD COLUMN IF NOT EXISTS deleted_by BYTEA REFERENCES "user"(id);
+
+CREATE INDEX IF NOT EXISTS conversation_deleted ON "conversation" (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE member DROP COLUMN IF EXISTS notifications;
+ALTER TABLE member DROP COLUMN IF EXISTS muted_until;
+ALTER TABLE member DROP COLUMN IF EXISTS archived;
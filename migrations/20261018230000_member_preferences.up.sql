@@ -0,0 +1,3 @@
+ALTER TABLE member ADD COLUMN IF NOT EXISTS archived BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE member ADD COLUMN IF NOT EXISTS muted_until TIMESTAMPTZ;
+ALTER TABLE member ADD COLUMN IF NOT EXISTS notifications VARCHAR(16) NOT NULL DEFAULT 'all' CHECK (notifications IN ('all', 'mentions', 'none'));
@@ -0,0 +1 @@
+/* Owners can't be told apart from those given before, so they stay */
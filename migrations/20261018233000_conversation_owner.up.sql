@@ -0,0 +1,11 @@
+/* member_role made every member of existing conversations an admin, leaving them without an owner. Their earliest admin, as far as the table can tell, owns them now. DMs have no owner. */
+UPDATE member SET "role" = 'owner'
+	FROM (
+		SELECT DISTINCT ON (member."conversation") member."conversation", member.ctid AS row FROM member
+			JOIN "conversation" ON "conversation".id = member."conversation"
+			WHERE member."role" = 'admin'
+			AND NOT "conversation".dm
+			AND NOT EXISTS (SELECT 1 FROM member AS owner WHERE owner."conversation" = member."conversation" AND owner."role" = 'owner')
+			ORDER BY member."conversation", member.ctid
+	) AS earliest
+	WHERE member.ctid = earliest.row;
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS user_phone_hash_prefix;
+CREATE INDEX IF NOT EXISTS user_phone_hash ON "user" (phone_hash);
+
+CREATE OR REPLACE FUNCTION hash_phone_number () RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.phone_hash := substring(digest(regexp_replace(NEW.phone_number, '[^0-9+]', '', 'g'), 'sha256') FROM 1 FOR 8);
+		RETURN NEW;
+	END;
+$$ LANGUAGE plpgsql;
+
+UPDATE "user" SET phone_hash = substring(phone_hash FROM 1 FOR 8);
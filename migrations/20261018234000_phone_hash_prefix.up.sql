@@ -0,0 +1,12 @@
+/* Users are discovered by a short prefix of the SHA-256 of their phone number, and clients match the whole of it themselves */
+CREATE OR REPLACE FUNCTION hash_phone_number () RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.phone_hash := digest(regexp_replace(NEW.phone_number, '[^0-9+]', '', 'g'), 'sha256');
+		RETURN NEW;
+	END;
+$$ LANGUAGE plpgsql;
+
+UPDATE "user" SET phone_hash = digest(regexp_replace(phone_number, '[^0-9+]', '', 'g'), 'sha256');
+
+DROP INDEX IF EXISTS user_phone_hash;
+CREATE INDEX IF NOT EXISTS user_phone_hash_prefix ON "user" (substring(phone_hash FROM 1 FOR 2));
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS invite_expires_at;
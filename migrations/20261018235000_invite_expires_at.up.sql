@@ -0,0 +1,2 @@
+/* Expired invites are purged, oldest first */
+CREATE INDEX IF NOT EXISTS invite_expires_at ON invite (expires_at);
@@ -0,0 +1,735 @@
+// Code generated by gen.go; DO NOT EDIT.
+
+package migrations
+
+var files = map[string]string{
+	"1_initial.down.sql": `DROP TRIGGER IF EXISTS notify_permissions_delete ON "member";
+DROP TRIGGER IF EXISTS notify_permissions_new ON "member";
+DROP FUNCTION IF EXISTS notify_permissions_delete ();
+DROP FUNCTION IF EXISTS notify_permissions_new ();
+
+DROP TABLE IF EXISTS pinned_conversation;
+DROP TABLE IF EXISTS contact;
+DROP TABLE IF EXISTS member;
+DROP TABLE IF EXISTS "conversation";
+DROP TABLE IF EXISTS "user";
+`,
+	"1_initial.up.sql": `
+CREATE TABLE IF NOT EXISTS "user" (
+	id BYTEA PRIMARY KEY,
+	username VARCHAR(63555) UNIQUE,
+	bio VARCHAR(63535) DEFAULT '',
+	profile_pic VARCHAR(63535) DEFAULT '',
+	first_name VARCHAR(65535) DEFAULT '',
+	last_name VARCHAR(65535) DEFAULT '',
+	phone_number VARCHAR(32) UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS "conversation" (
+	id BYTEA PRIMARY KEY,
+	title VARCHAR(65535),
+	picture VARCHAR(63535)
+);
+
+CREATE TABLE IF NOT EXISTS member (
+	"user" BYTEA REFERENCES "user"(id),
+	"conversation" BYTEA REFERENCES "conversation"(id),
+	"pinned" BOOLEAN DEFAULT FALSE,
+	UNIQUE ("user", "conversation")
+);
+
+CREATE TABLE IF NOT EXISTS contact (
+	"user" BYTEA REFERENCES "user"(id),
+	contact BYTEA REFERENCES "user"(id),
+	UNIQUE ("user", contact)
+);
+
+CREATE TABLE IF NOT EXISTS pinned_conversation (
+	"user" BYTEA REFERENCES "user"(id),
+	"conversation" BYTEA REFERENCES "conversation"(id),
+	UNIQUE ("user", "conversation")
+);
+
+CREATE OR REPLACE FUNCTION notify_permissions_new () RETURNS TRIGGER AS $$
+	BEGIN
+		PERFORM pg_notify('member_new', CONCAT(NEW."user", '+', NEW."conversation"));
+		RETURN NULL;
+	END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_permissions_delete () RETURNS TRIGGER AS $$
+	BEGIN
+		PERFORM pg_notify('member_delete', CONCAT(OLD."user", '+', OLD."conversation"));
+		RETURN NULL;
+	END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_permissions_new
+	AFTER INSERT OR UPDATE
+	ON "member"
+	FOR EACH ROW
+		EXECUTE PROCEDURE notify_permissions_new();
+
+CREATE TRIGGER notify_permissions_delete
+	AFTER DELETE
+	ON "member"
+	FOR EACH ROW
+		EXECUTE PROCEDURE notify_permissions_delete();
+`,
+	"20261018100000_outbox.down.sql": `DROP TABLE IF EXISTS outbox;
+`,
+	"20261018100000_outbox.up.sql": `CREATE TABLE IF NOT EXISTS outbox (
+	id BIGSERIAL PRIMARY KEY,
+	event_id VARCHAR(64) UNIQUE NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	payload BYTEA NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	last_error TEXT,
+	sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending ON outbox (next_attempt_at, id) WHERE sent_at IS NULL;
+`,
+	"20261018110000_member_role.down.sql": `DROP INDEX IF EXISTS member_owner;
+ALTER TABLE member DROP COLUMN IF EXISTS "role";
+`,
+	"20261018110000_member_role.up.sql": `ALTER TABLE member ADD COLUMN IF NOT EXISTS "role" VARCHAR(16) NOT NULL DEFAULT 'member' CHECK ("role" IN ('owner', 'admin', 'member'));
+
+/* Conversations used to have no owners, and let every member administrate them */
+UPDATE member SET "role" = 'admin';
+
+CREATE UNIQUE INDEX IF NOT EXISTS member_owner ON member ("conversation") WHERE "role" = 'owner';
+`,
+	"20261018120000_direct_messages.down.sql": `DROP TABLE IF EXISTS dm;
+ALTER TABLE "conversation" DROP COLUMN IF EXISTS dm;
+`,
+	"20261018120000_direct_messages.up.sql": `ALTER TABLE "conversation" ADD COLUMN IF NOT EXISTS dm BOOLEAN NOT NULL DEFAULT FALSE;
+
+/* At most one DM per pair of users, stored with usera < userb */
+CREATE TABLE IF NOT EXISTS dm (
+	"conversation" BYTEA PRIMARY KEY REFERENCES "conversation"(id) ON DELETE CASCADE,
+	usera BYTEA NOT NULL REFERENCES "user"(id),
+	userb BYTEA NOT NULL REFERENCES "user"(id),
+	CHECK (usera < userb),
+	UNIQUE (usera, userb)
+);
+`,
+	"20261018130000_member_conversation_index.down.sql": `DROP INDEX IF EXISTS member_conversation;
+`,
+	"20261018130000_member_conversation_index.up.sql": `/* member is otherwise only indexed by ("user", "conversation") */
+CREATE INDEX IF NOT EXISTS member_conversation ON member ("conversation");
+`,
+	"20261018140000_member_lastheard.down.sql": `ALTER TABLE member DROP COLUMN IF EXISTS lastheard;
+`,
+	"20261018140000_member_lastheard.up.sql": `/* Unix time in milliseconds up to which the member has heard the conversation */
+ALTER TABLE member ADD COLUMN IF NOT EXISTS lastheard BIGINT;
+`,
+	"20261018150000_phone_hash.down.sql": `DROP TRIGGER IF EXISTS hash_phone_number ON "user";
+DROP FUNCTION IF EXISTS hash_phone_number ();
+
+DROP INDEX IF EXISTS user_phone_hash;
+ALTER TABLE "user" DROP COLUMN IF EXISTS phone_hash;
+
+DROP EXTENSION IF EXISTS pgcrypto;
+`,
+	"20261018150000_phone_hash.up.sql": `/* Truncated SHA-256 of the E.164 form of phone numbers, for contact discovery */
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS phone_hash BYTEA;
+CREATE INDEX IF NOT EXISTS user_phone_hash ON "user" (phone_hash);
+
+/* Phone numbers are stored in international format, which is E.164 with spaces and dashes */
+CREATE OR REPLACE FUNCTION hash_phone_number () RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.phone_hash := substring(digest(regexp_replace(NEW.phone_number, '[^0-9+]', '', 'g'), 'sha256') FROM 1 FOR 8);
+		RETURN NEW;
+	END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS hash_phone_number ON "user";
+CREATE TRIGGER hash_phone_number
+	BEFORE INSERT OR UPDATE OF phone_number
+	ON "user"
+	FOR EACH ROW
+		EXECUTE PROCEDURE hash_phone_number();
+
+/* Existing users */
+UPDATE "user" SET phone_number = phone_number WHERE phone_hash IS NULL;
+`,
+	"20261018160000_conversation_activity.down.sql": `DROP INDEX IF EXISTS user_name;
+
+DROP TRIGGER IF EXISTS touch_member_conversation ON member;
+DROP TRIGGER IF EXISTS touch_conversation ON "conversation";
+DROP FUNCTION IF EXISTS touch_member_conversation ();
+DROP FUNCTION IF EXISTS touch_conversation ();
+
+ALTER TABLE "conversation" DROP COLUMN IF EXISTS active_at;
+`,
+	"20261018160000_conversation_activity.up.sql": `/* When the conversation or its members last changed, to list recent conversations first */
+ALTER TABLE "conversation" ADD COLUMN IF NOT EXISTS active_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE OR REPLACE FUNCTION touch_conversation () RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.active_at := NOW();
+		RETURN NEW;
+	END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION touch_member_conversation () RETURNS TRIGGER AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			UPDATE "conversation" SET active_at = NOW() WHERE id = OLD."conversation";
+		ELSE
+			UPDATE "conversation" SET active_at = NOW() WHERE id = NEW."conversation";
+		END IF;
+		RETURN NULL;
+	END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS touch_conversation ON "conversation";
+CREATE TRIGGER touch_conversation
+	BEFORE UPDATE OF title, picture
+	ON "conversation"
+	FOR EACH ROW
+		EXECUTE PROCEDURE touch_conversation();
+
+DROP TRIGGER IF EXISTS touch_member_conversation ON member;
+CREATE TRIGGER touch_member_conversation
+	AFTER INSERT OR DELETE
+	ON member
+	FOR EACH ROW
+		EXECUTE PROCEDURE touch_member_conversation();
+
+/* Contacts and members are listed by name */
+CREATE INDEX IF NOT EXISTS user_name ON "user" (first_name, last_name, id);
+`,
+	"20261018170000_user_search.down.sql": `DROP INDEX IF EXISTS user_full_name_trgm;
+DROP INDEX IF EXISTS user_last_name_trgm;
+DROP INDEX IF EXISTS user_first_name_trgm;
+DROP INDEX IF EXISTS user_username_trgm;
+
+DROP EXTENSION IF EXISTS pg_trgm;
+`,
+	"20261018170000_user_search.up.sql": `/* Trigram indexes for prefix and fuzzy user search */
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS user_username_trgm ON "user" USING GIN (username gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS user_first_name_trgm ON "user" USING GIN (first_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS user_last_name_trgm ON "user" USING GIN (last_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS user_full_name_trgm ON "user" USING GIN ((first_name || ' ' || last_name) gin_trgm_ops);
+`,
+	"20261018180000_user_privacy.down.sql": `ALTER TABLE "user" DROP COLUMN IF EXISTS profile_pic_visibility;
+ALTER TABLE "user" DROP COLUMN IF EXISTS bio_visibility;
+ALTER TABLE "user" DROP COLUMN IF EXISTS phone_number_visibility;
+`,
+	"20261018180000_user_privacy.up.sql": `/* Who can see each private field of a user: everyone, contacts or nobody */
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS phone_number_visibility VARCHAR(16) NOT NULL DEFAULT 'contacts'
+	CHECK (phone_number_visibility IN ('everyone', 'contacts', 'nobody'));
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS bio_visibility VARCHAR(16) NOT NULL DEFAULT 'everyone'
+	CHECK (bio_visibility IN ('everyone', 'contacts', 'nobody'));
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS profile_pic_visibility VARCHAR(16) NOT NULL DEFAULT 'everyone'
+	CHECK (profile_pic_visibility IN ('everyone', 'contacts', 'nobody'));
+`,
+	"20261018190000_block.down.sql": `DROP TRIGGER IF EXISTS notify_block_delete ON block;
+DROP TRIGGER IF EXISTS notify_block_new ON block;
+DROP FUNCTION IF EXISTS notify_block_delete ();
+DROP FUNCTION IF EXISTS notify_block_new ();
+
+DROP TABLE IF EXISTS block;
+`,
+	"20261018190000_block.up.sql": `CREATE TABLE IF NOT EXISTS block (
+	blocker BYTEA REFERENCES "user"(id),
+	blocked BYTEA REFERENCES "user"(id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (blocker, blocked),
+	CHECK (blocker <> blocked)
+);
+
+CREATE INDEX IF NOT EXISTS block_blocked ON block (blocked);
+
+CREATE OR REPLACE FUNCTION notify_block_new () RETURNS TRIGGER AS $$
+	BEGIN
+		PERFORM pg_notify('block_new', CONCAT(NEW.blocker, '+', NEW.blocked));
+		RETURN NULL;
+	END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_block_delete () RETURNS TRIGGER AS $$
+	BEGIN
+		PERFORM pg_notify('block_delete', CONCAT(OLD.blocker, '+', OLD.blocked));
+		RETURN NULL;
+	END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notify_block_new ON block;
+CREATE TRIGGER notify_block_new
+	AFTER INSERT
+	ON block
+	FOR EACH ROW
+		EXECUTE PROCEDURE notify_block_new();
+
+DROP TRIGGER IF EXISTS notify_block_delete ON block;
+CREATE TRIGGER notify_block_delete
+	AFTER DELETE
+	ON block
+	FOR EACH ROW
+		EXECUTE PROCEDURE notify_block_delete();
+`,
+	"20261018200000_invite.down.sql": `DROP TABLE IF EXISTS invite;
+`,
+	"20261018200000_invite.up.sql": `CREATE TABLE IF NOT EXISTS invite (
+	id BYTEA PRIMARY KEY,
+	"conversation" BYTEA NOT NULL REFERENCES "conversation"(id) ON DELETE CASCADE,
+	"user" BYTEA NOT NULL REFERENCES "user"(id),
+	inviter BYTEA NOT NULL REFERENCES "user"(id),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	UNIQUE ("conversation", "user")
+);
+
+CREATE INDEX IF NOT EXISTS invite_user ON invite ("user", created_at DESC, id DESC);
+`,
+	"20261018210000_invite_link.down.sql": `DROP TABLE IF EXISTS invite_link;
+`,
+	"20261018210000_invite_link.up.sql": `CREATE TABLE IF NOT EXISTS invite_link (
+	token BYTEA PRIMARY KEY,
+	"conversation" BYTEA NOT NULL REFERENCES "conversation"(id) ON DELETE CASCADE,
+	creator BYTEA NOT NULL REFERENCES "user"(id),
+	uses_left INTEGER CHECK (uses_left >= 0),
+	expires_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS invite_link_conversation ON invite_link ("conversation", created_at DESC, token DESC);
+`,
+	"20261018220000_conversation_deleted.down.sql": `DROP INDEX IF EXISTS conversation_deleted;
+ALTER TABLE "conversation" DROP COLUMN IF EXISTS deleted_by;
+ALTER TABLE "conversation" DROP COLUMN IF EXISTS deleted_at;
+`,
+	"20261018220000_conversation_deleted.up.sql": `/* Deleted conversations are kept for a while so they can be restored */
+ALTER TABLE "conversation" ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE "conversation" ADD COLUMN IF NOT EXISTS deleted_by BYTEA REFERENCES "user"(id);
+
+CREATE INDEX IF NOT EXISTS conversation_deleted ON "conversation" (deleted_at) WHERE deleted_at IS NOT NULL;
+`,
+	"20261018230000_member_preferences.down.sql": `ALTER TABLE member DROP COLUMN IF EXISTS notifications;
+ALTER TABLE member DROP COLUMN IF EXISTS muted_until;
+ALTER TABLE member DROP COLUMN IF EXISTS archived;
+`,
+	"20261018230000_member_preferences.up.sql": `ALTER TABLE member ADD COLUMN IF NOT EXISTS archived BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE member ADD COLUMN IF NOT EXISTS muted_until TIMESTAMPTZ;
+ALTER TABLE member ADD COLUMN IF NOT EXISTS notifications VARCHAR(16) NOT NULL DEFAULT 'all' CHECK (notifications IN ('all', 'mentions', 'none'));
+`,
+	"20261018233000_conversation_owner.down.sql": `/* Owners can't be told apart from those given before, so they stay */
+`,
+	"20261018233000_conversation_owner.up.sql": `/* member_role made every member of existing conversations an admin, leaving them without an owner. Their earliest admin, as far as the table can tell, owns them now. DMs have no owner. */
+UPDATE member SET "role" = 'owner'
+	FROM (
+		SELECT DISTINCT ON (member."conversation") member."conversation", member.ctid AS row FROM member
+			JOIN "conversation" ON "conversation".id = member."conversation"
+			WHERE member."role" = 'admin'
+			AND NOT "conversation".dm
+			AND NOT EXISTS (SELECT 1 FROM member AS owner WHERE owner."conversation" = member."conversation" AND owner."role" = 'owner')
+			ORDER BY member."conversation", member.ctid
+	) AS earliest
+	WHERE member.ctid = earliest.row;
+`,
+	"20261018234000_phone_hash_prefix.down.sql": `DROP INDEX IF EXISTS user_phone_hash_prefix;
+CREATE INDEX IF NOT EXISTS user_phone_hash ON "user" (phone_hash);
+
+CREATE OR REPLACE FUNCTION hash_phone_number () RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.phone_hash := substring(digest(regexp_replace(NEW.phone_number, '[^0-9+]', '', 'g'), 'sha256') FROM 1 FOR 8);
+		RETURN NEW;
+	END;
+$$ LANGUAGE plpgsql;
+
+UPDATE "user" SET phone_hash = substring(phone_hash FROM 1 FOR 8);
+`,
+	"20261018234000_phone_hash_prefix.up.sql": `/* Users are discovered by a short prefix of the SHA-256 of their phone number, and clients match the whole of it themselves */
+CREATE OR REPLACE FUNCTION hash_phone_number () RETURNS TRIGGER AS $$
+	BEGIN
+		NEW.phone_hash := digest(regexp_replace(NEW.phone_number, '[^0-9+]', '', 'g'), 'sha256');
+		RETURN NEW;
+	END;
+$$ LANGUAGE plpgsql;
+
+UPDATE "user" SET phone_hash = digest(regexp_replace(phone_number, '[^0-9+]', '', 'g'), 'sha256');
+
+DROP INDEX IF EXISTS user_phone_hash;
+CREATE INDEX IF NOT EXISTS user_phone_hash_prefix ON "user" (substring(phone_hash FROM 1 FOR 2));
+`,
+	"20261018235000_invite_expires_at.down.sql": `DROP INDEX IF EXISTS invite_expires_at;
+`,
+	"20261018235000_invite_expires_at.up.sql": `/* Expired invites are purged, oldest first */
+CREATE INDEX IF NOT EXISTS invite_expires_at ON invite (expires_at);
+`,
+	"fixtures/1_users.sql": `INSERT INTO "user" (
+  id, username, bio, profile_pic, first_name, last_name, phone_number
+) VALUES (
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
+  'ambc',
+  '',
+  '',
+  'Ambrose',
+  'Chua',
+  '+65 9766 3827'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "user" (
+  id, username, bio, profile_pic, first_name, last_name, phone_number
+) VALUES (
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7',
+  'orcas',
+  '',
+  '',
+  'Daniel',
+  'Lim',
+  '+65 8737 7117'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "user" (
+  id, username, bio, profile_pic, first_name, last_name, phone_number
+) VALUES (
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'it',
+  '',
+  '',
+  'Isaac',
+  'Tay',
+  '+65 8181 6346'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "user" (
+  id, username, bio, profile_pic, first_name, last_name, phone_number
+) VALUES (
+  'u-fb91825f564a3cc110f11836fedea6f4',
+  'solderneer',
+  '',
+  '',
+  'Sudharshan',
+  '',
+  '+65 8143 8417'
+) ON CONFLICT DO NOTHING;
+`,
+	"fixtures/2_contacts.sql": `/* Ambrose */
+INSERT INTO "contact" (
+  "user", "contact"
+) VALUES (
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "contact" (
+  "user", "contact"
+) VALUES (
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
+  'u-23e608245d0866ea937f15876adb5ef6'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "contact" (
+  "user", "contact"
+) VALUES (
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
+  'u-fb91825f564a3cc110f11836fedea6f4'
+) ON CONFLICT DO NOTHING;
+
+/* Daniel */
+INSERT INTO "contact" (
+  "user", "contact"
+) VALUES (
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7',
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "contact" (
+  "user", "contact"
+) VALUES (
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7',
+  'u-23e608245d0866ea937f15876adb5ef6'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "contact" (
+  "user", "contact"
+) VALUES (
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7',
+  'u-fb91825f564a3cc110f11836fedea6f4'
+) ON CONFLICT DO NOTHING;
+
+/* Isaac */
+INSERT INTO "contact" (
+  "user", "contact"
+) VALUES (
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "contact" (
+  "user", "contact"
+) VALUES (
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "contact" (
+  "user", "contact"
+) VALUES (
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'u-fb91825f564a3cc110f11836fedea6f4'
+) ON CONFLICT DO NOTHING;
+
+/* Sudharshan */
+INSERT INTO "contact" (
+  "user", "contact"
+) VALUES (
+  'u-fb91825f564a3cc110f11836fedea6f4',
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "contact" (
+  "user", "contact"
+) VALUES (
+  'u-fb91825f564a3cc110f11836fedea6f4',
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "contact" (
+  "user", "contact"
+) VALUES (
+  'u-fb91825f564a3cc110f11836fedea6f4',
+  'u-23e608245d0866ea937f15876adb5ef6'
+) ON CONFLICT DO NOTHING;
+`,
+	"fixtures/3_dms.sql": `/* Ambrose-Daniel */
+INSERT INTO "conversation" (
+  "id", "dm", "title", "picture"
+) VALUES (
+  'c-f614f9c3670ad0475e819d76397abf0d',
+  TRUE,
+  'Ambrose-Daniel',
+  ''
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
+  'c-f614f9c3670ad0475e819d76397abf0d'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7',
+  'c-f614f9c3670ad0475e819d76397abf0d'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "dm" (
+  "conversation", "usera", "userb"
+) VALUES (
+  'c-f614f9c3670ad0475e819d76397abf0d',
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7'
+) ON CONFLICT DO NOTHING;
+
+/* Ambrose-Isaac */
+INSERT INTO "conversation" (
+  "id", "dm", "title", "picture"
+) VALUES (
+  'c-d218888bdf510bbe1628d9983d75560f',
+  TRUE,
+  'Ambrose-Isaac',
+  ''
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
+  'c-d218888bdf510bbe1628d9983d75560f'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'c-d218888bdf510bbe1628d9983d75560f'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "dm" (
+  "conversation", "usera", "userb"
+) VALUES (
+  'c-d218888bdf510bbe1628d9983d75560f',
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2'
+) ON CONFLICT DO NOTHING;
+
+/* Ambrose-Sudharshan */
+INSERT INTO "conversation" (
+  "id", "dm", "title", "picture"
+) VALUES (
+  'c-fab2c2fb3befdbb2fe7abf444cbe3846',
+  TRUE,
+  'Ambrose-Sudharshan',
+  ''
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
+  'c-fab2c2fb3befdbb2fe7abf444cbe3846'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-fb91825f564a3cc110f11836fedea6f4',
+  'c-fab2c2fb3befdbb2fe7abf444cbe3846'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "dm" (
+  "conversation", "usera", "userb"
+) VALUES (
+  'c-fab2c2fb3befdbb2fe7abf444cbe3846',
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
+  'u-fb91825f564a3cc110f11836fedea6f4'
+) ON CONFLICT DO NOTHING;
+
+/* Daniel-Isaac */
+INSERT INTO "conversation" (
+  "id", "dm", "title", "picture"
+) VALUES (
+  'c-a1db4a9455dbc6c11ea2fa36f6bfa782',
+  TRUE,
+  'Daniel-Isaac',
+  ''
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7',
+  'c-a1db4a9455dbc6c11ea2fa36f6bfa782'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'c-a1db4a9455dbc6c11ea2fa36f6bfa782'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "dm" (
+  "conversation", "usera", "userb"
+) VALUES (
+  'c-a1db4a9455dbc6c11ea2fa36f6bfa782',
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7'
+) ON CONFLICT DO NOTHING;
+
+/* Daniel-Sudharshan */
+INSERT INTO "conversation" (
+  "id", "dm", "title", "picture"
+) VALUES (
+  'c-a3715860dcd95d1a105c12b7379e6d34',
+  TRUE,
+  'Daniel-Sudharshan',
+  ''
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7',
+  'c-a3715860dcd95d1a105c12b7379e6d34'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-fb91825f564a3cc110f11836fedea6f4',
+  'c-a3715860dcd95d1a105c12b7379e6d34'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "dm" (
+  "conversation", "usera", "userb"
+) VALUES (
+  'c-a3715860dcd95d1a105c12b7379e6d34',
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7',
+  'u-fb91825f564a3cc110f11836fedea6f4'
+) ON CONFLICT DO NOTHING;
+
+/* Isaac-Sudharshan */
+INSERT INTO "conversation" (
+  "id", "dm", "title", "picture"
+) VALUES (
+  'c-6f2ba396fb53961ff8a6ba9c5d286a25',
+  TRUE,
+  'Isaac-Sudharshan',
+  ''
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'c-6f2ba396fb53961ff8a6ba9c5d286a25'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-fb91825f564a3cc110f11836fedea6f4',
+  'c-6f2ba396fb53961ff8a6ba9c5d286a25'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "dm" (
+  "conversation", "usera", "userb"
+) VALUES (
+  'c-6f2ba396fb53961ff8a6ba9c5d286a25',
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'u-fb91825f564a3cc110f11836fedea6f4'
+) ON CONFLICT DO NOTHING;
+`,
+	"fixtures/4_group.sql": `INSERT INTO "conversation" (
+  "id", "dm", "title", "picture"
+) VALUES (
+  'c-d73b6afa2fe3685faad28eba36d8cd0a',
+  FALSE,
+  'AWESOME',
+  ''
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation", "role"
+) VALUES (
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
+  'c-d73b6afa2fe3685faad28eba36d8cd0a',
+  'owner'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7',
+  'c-d73b6afa2fe3685faad28eba36d8cd0a'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'c-d73b6afa2fe3685faad28eba36d8cd0a'
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO "member" (
+  "user", "conversation"
+) VALUES (
+  'u-fb91825f564a3cc110f11836fedea6f4',
+  'c-d73b6afa2fe3685faad28eba36d8cd0a'
+) ON CONFLICT DO NOTHING;
+`,
+}
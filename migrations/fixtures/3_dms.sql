@@ -22,6 +22,14 @@ INSERT INTO "member" (
   'c-f614f9c3670ad0475e819d76397abf0d'
 ) ON CONFLICT DO NOTHING;
 
+INSERT INTO "dm" (
+  "conversation", "usera", "userb"
+) VALUES (
+  'c-f614f9c3670ad0475e819d76397abf0d',
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7'
+) ON CONFLICT DO NOTHING;
+
 /* Ambrose-Isaac */
 INSERT INTO "conversation" (
   "id", "dm", "title", "picture"
@@ -46,6 +54,14 @@ INSERT INTO "member" (
   'c-d218888bdf510bbe1628d9983d75560f'
 ) ON CONFLICT DO NOTHING;
 
+INSERT INTO "dm" (
+  "conversation", "usera", "userb"
+) VALUES (
+  'c-d218888bdf510bbe1628d9983d75560f',
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2'
+) ON CONFLICT DO NOTHING;
+
 /* Ambrose-Sudharshan */
 INSERT INTO "conversation" (
   "id", "dm", "title", "picture"
@@ -70,6 +86,14 @@ INSERT INTO "member" (
   'c-fab2c2fb3befdbb2fe7abf444cbe3846'
 ) ON CONFLICT DO NOTHING;
 
+INSERT INTO "dm" (
+  "conversation", "usera", "userb"
+) VALUES (
+  'c-fab2c2fb3befdbb2fe7abf444cbe3846',
+  'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
+  'u-fb91825f564a3cc110f11836fedea6f4'
+) ON CONFLICT DO NOTHING;
+
 /* Daniel-Isaac */
 INSERT INTO "conversation" (
   "id", "dm", "title", "picture"
@@ -94,6 +118,14 @@ INSERT INTO "member" (
   'c-a1db4a9455dbc6c11ea2fa36f6bfa782'
 ) ON CONFLICT DO NOTHING;
 
+INSERT INTO "dm" (
+  "conversation", "usera", "userb"
+) VALUES (
+  'c-a1db4a9455dbc6c11ea2fa36f6bfa782',
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7'
+) ON CONFLICT DO NOTHING;
+
 /* Daniel-Sudharshan */
 INSERT INTO "conversation" (
   "id", "dm", "title", "picture"
@@ -118,6 +150,14 @@ INSERT INTO "member" (
   'c-a3715860dcd95d1a105c12b7379e6d34'
 ) ON CONFLICT DO NOTHING;
 
+INSERT INTO "dm" (
+  "conversation", "usera", "userb"
+) VALUES (
+  'c-a3715860dcd95d1a105c12b7379e6d34',
+  'u-dc9537ca645ff34b4f289b6bd7aa08b7',
+  'u-fb91825f564a3cc110f11836fedea6f4'
+) ON CONFLICT DO NOTHING;
+
 /* Isaac-Sudharshan */
 INSERT INTO "conversation" (
   "id", "dm", "title", "picture"
@@ -141,3 +181,11 @@ INSERT INTO "member" (
   'u-fb91825f564a3cc110f11836fedea6f4',
   'c-6f2ba396fb53961ff8a6ba9c5d286a25'
 ) ON CONFLICT DO NOTHING;
+
+INSERT INTO "dm" (
+  "conversation", "usera", "userb"
+) VALUES (
+  'c-6f2ba396fb53961ff8a6ba9c5d286a25',
+  'u-23e608245d0866ea937f15876adb5ef6',
+  'u-fb91825f564a3cc110f11836fedea6f4'
+) ON CONFLICT DO NOTHING;
@@ -8,10 +8,11 @@ INSERT INTO "conversation" (
 ) ON CONFLICT DO NOTHING;
 
 INSERT INTO "member" (
-  "user", "conversation"
+  "user", "conversation", "role"
 ) VALUES (
   'u-7f48e2f2b6f7e4d1f9c864e48bc2b0f2',
-  'c-d73b6afa2fe3685faad28eba36d8cd0a'
+  'c-d73b6afa2fe3685faad28eba36d8cd0a',
+  'owner'
 ) ON CONFLICT DO NOTHING;
 
 INSERT INTO "member" (
@@ -0,0 +1,57 @@
+// +build ignore
+
+// gen embeds the migrations and fixtures into files.go. Run it with
+// go generate after changing any of them.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func main() {
+	names := make([]string, 0)
+	for _, pattern := range []string{"*.sql", "fixtures/*.sql"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Fatal(err)
+		}
+		names = append(names, matches...)
+	}
+	sort.Strings(names)
+
+	b := &bytes.Buffer{}
+	fmt.Fprint(b, "// Code generated by gen.go; DO NOT EDIT.\n\npackage migrations\n\n")
+	fmt.Fprint(b, "var files = map[string]string{\n")
+	for _, name := range names {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(b, "%q: %s,\n", filepath.ToSlash(name), quote(string(content)))
+	}
+	fmt.Fprint(b, "}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = ioutil.WriteFile("files.go", src, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// quote keeps SQL readable in raw strings, unless it has backquotes
+func quote(s string) string {
+	if strings.Contains(s, "`") || strings.Contains(s, "\r") {
+		return fmt.Sprintf("%q", s)
+	}
+	return "`" + s + "`"
+}
@@ -0,0 +1,123 @@
+// +build integration
+
+package migrations
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+)
+
+// Versions of the migrations that gave members their roles, and owners to
+// conversations left without one
+const (
+	memberRoleVersion        = 20261018110000
+	conversationOwnerVersion = 20261018233000
+)
+
+// downTo reverts every applied migration from version on
+func downTo(t *testing.T, db *sql.DB, version int64) {
+	statuses, err := List(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, status := range statuses {
+		if status.Version >= version && status.AppliedAt != nil {
+			n++
+		}
+	}
+	if _, err := Down(db, n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// owners returns the owner of each conversation in conversations, failing if
+// any of them has several or none, or members other than admins
+func owners(t *testing.T, db *sql.DB, conversations ...string) map[string]string {
+	result := make(map[string]string)
+	for _, conversation := range conversations {
+		rows, err := db.Query(`SELECT "user", "role" FROM member WHERE "conversation" = $1`, conversation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var user, role string
+			if err := rows.Scan(&user, &role); err != nil {
+				t.Fatal(err)
+			}
+			switch role {
+			case "owner":
+				if result[conversation] != "" {
+					t.Errorf("Want one owner of %s, got %s and %s", conversation, result[conversation], user)
+				}
+				result[conversation] = user
+			case "admin":
+			default:
+				t.Errorf("Want the other members of %s to be admins, got %s for %s", conversation, role, user)
+			}
+		}
+		rows.Close()
+		if result[conversation] == "" {
+			t.Errorf("Want an owner of %s, got none", conversation)
+		}
+	}
+	return result
+}
+
+func TestMemberRoleOwners(t *testing.T) {
+	godotenv.Load("../.env")
+	db, err := sql.Open("postgres", os.Getenv("POSTGRES"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := Up(db); err != nil {
+		t.Fatal(err)
+	}
+	defer Up(db)
+
+	// Members of conversations from before roles
+	downTo(t, db, memberRoleVersion)
+	_, err = db.Exec(`
+		TRUNCATE "user", "conversation", member, contact, pinned_conversation CASCADE;
+		INSERT INTO "user" (id, phone_number) VALUES ('u-legacy1', '+65 9000 1001'), ('u-legacy2', '+65 9000 1002'), ('u-legacy3', '+65 9000 1003');
+		INSERT INTO "conversation" (id) VALUES ('c-legacy1'), ('c-legacy2');
+		INSERT INTO member ("user", "conversation") VALUES ('u-legacy2', 'c-legacy1'), ('u-legacy1', 'c-legacy1'), ('u-legacy3', 'c-legacy1');
+		INSERT INTO member ("user", "conversation") VALUES ('u-legacy3', 'c-legacy2');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Up(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Updates move rows, so which admin is earliest is up to Postgres
+	owners(t, db, "c-legacy1", "c-legacy2")
+
+	// DMs came after roles, and have no owner even if owners are given again
+	_, err = db.Exec(`
+		INSERT INTO "conversation" (id, dm) VALUES ('c-legacydm', TRUE);
+		INSERT INTO member ("user", "conversation", "role") VALUES ('u-legacy1', 'c-legacydm', 'member'), ('u-legacy2', 'c-legacydm', 'member');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`DELETE FROM schema_migrations WHERE version = $1`, conversationOwnerVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Up(db); err != nil {
+		t.Fatal(err)
+	}
+	owners(t, db, "c-legacy1", "c-legacy2")
+	var found int
+	err = db.QueryRow(`SELECT 1 FROM member WHERE "conversation" = 'c-legacydm' AND "role" = 'owner'`).Scan(&found)
+	if err != sql.ErrNoRows {
+		t.Errorf("Want DMs to stay without an owner, got %v", err)
+	}
+}
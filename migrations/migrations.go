@@ -0,0 +1,304 @@
+// Package migrations holds the versioned schema of backend-core, embedded
+// in the binary, and applies it to Postgres.
+//
+// Each migration is a pair of VERSION_NAME.up.sql and VERSION_NAME.down.sql
+// files, applied in version order. Applied versions are recorded in the
+// schema_migrations table. Fixtures under fixtures/ are seed data for
+// development and are only ever applied on request.
+package migrations
+
+//go:generate go run gen.go
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Arbitrary key, so concurrent instances migrate one at a time
+const lockKey = 0x62656570
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	AppliedAt *time.Time // nil when pending
+}
+
+// All returns the embedded migrations in version order
+func All() ([]Migration, error) {
+	byVersion := make(map[int64]*Migration)
+	for name, content := range files {
+		if path.Dir(name) != "." {
+			continue
+		}
+		var direction string
+		base := name
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+			base = strings.TrimSuffix(name, ".up.sql")
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+			base = strings.TrimSuffix(name, ".down.sql")
+		default:
+			return nil, fmt.Errorf("migrations: %s is neither up nor down", name)
+		}
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || len(parts) < 2 {
+			return nil, fmt.Errorf("migrations: %s is not named VERSION_NAME", name)
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[1] {
+			return nil, fmt.Errorf("migrations: version %d is used by both %s and %s", version, m.Name, parts[1])
+		}
+		if direction == "up" {
+			m.Up = content
+		} else {
+			m.Down = content
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrations: %d_%s needs both up and down", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Fixtures returns the embedded seed data in the order it should be applied
+func Fixtures() []string {
+	names := make([]string, 0)
+	for name := range files {
+		if path.Dir(name) == "fixtures" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	fixtures := make([]string, 0, len(names))
+	for _, name := range names {
+		fixtures = append(fixtures, files[name])
+	}
+	return fixtures
+}
+
+// Up applies all pending migrations, returning how many were applied
+func Up(db *sql.DB) (int, error) {
+	migrations, err := All()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = locked(db, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			err := apply(conn, m.Up, `
+				INSERT INTO schema_migrations (version, name) VALUES ($1, $2)
+			`, m.Version, m.Name)
+			if err != nil {
+				return fmt.Errorf("migrations: up %d_%s: %v", m.Version, m.Name, err)
+			}
+			count += 1
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down reverts the last n applied migrations, returning how many were
+// reverted
+func Down(db *sql.DB, n int) (int, error) {
+	migrations, err := All()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = locked(db, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for i := len(migrations) - 1; i >= 0 && count < n; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			err := apply(conn, m.Down, `
+				DELETE FROM schema_migrations WHERE version = $1
+			`, m.Version)
+			if err != nil {
+				return fmt.Errorf("migrations: down %d_%s: %v", m.Version, m.Name, err)
+			}
+			count += 1
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Force marks every migration up to and including version as applied and
+// the rest as pending, without running any of them. It is meant for
+// databases whose schema was set up by hand.
+func Force(db *sql.DB, version int64) error {
+	migrations, err := All()
+	if err != nil {
+		return err
+	}
+
+	return locked(db, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		ctx := context.Background()
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version > $1`, version)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if m.Version > version {
+				break
+			}
+			_, err = tx.Exec(`
+				INSERT INTO schema_migrations (version, name) VALUES ($1, $2)
+					ON CONFLICT DO NOTHING
+			`, m.Version, m.Name)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// List returns every migration and when it was applied
+func List(db *sql.DB) ([]Status, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(migrations))
+	err = locked(db, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, m := range migrations {
+			status := Status{Migration: m}
+			if at, ok := applied[m.Version]; ok {
+				status.AppliedAt = &at
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Seed applies the fixtures in a single transaction
+func Seed(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, fixture := range Fixtures() {
+		_, err = tx.Exec(fixture)
+		if err != nil {
+			return fmt.Errorf("migrations: fixtures: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// locked runs f holding the migration lock, with the versions applied so far
+func locked(db *sql.DB, f func(*sql.Conn, map[int64]time.Time) error) error {
+	ctx := context.Background()
+
+	// Session locks belong to a connection, so hold on to one
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(256) NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = at
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return f(conn, applied)
+}
+
+// apply runs a migration and records it in the same transaction
+func apply(conn *sql.Conn, migration string, record string, args ...interface{}) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(migration)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(record, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
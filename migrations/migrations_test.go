@@ -0,0 +1,52 @@
+// +build unit
+
+package migrations
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmbedded(t *testing.T) {
+	names := make([]string, 0)
+	for _, pattern := range []string{"*.sql", "fixtures/*.sql"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, matches...)
+	}
+
+	if len(names) != len(files) {
+		t.Errorf("%d files on disk but %d embedded, run go generate", len(names), len(files))
+	}
+	for _, name := range names {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files[filepath.ToSlash(name)] != string(content) {
+			t.Errorf("%s is out of date, run go generate", name)
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	migrations, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) < 1 || migrations[0].Version != 1 {
+		t.Fatalf("expected migrations to start at 1, got %v", migrations)
+	}
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			t.Errorf("%d_%s is out of order", migrations[i].Version, migrations[i].Name)
+		}
+	}
+
+	if len(Fixtures()) < 1 {
+		t.Error("expected fixtures")
+	}
+}
@@ -1,101 +1,200 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
+	"sync"
 
 	"github.com/nats-io/go-nats"
+
+	"backend/core/event"
 )
 
 func (h *Handler) ContactHandler(msg *nats.Msg) {
 	// Validate JSON
-	updateMsg := UpdateMsg{}
-	err := json.Unmarshal(msg.Data, &updateMsg)
+	envelope, err := event.Parse(msg.Data)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	if h.seen.Seen(envelope.ID) {
+		return
+	}
 
 	contact := Contact{}
-	err = json.Unmarshal([]byte(updateMsg.Data), &contact)
+	err = envelope.Decode(&contact)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	// Transmit
-	for _, conn := range h.contactConnections {
-		conn <- msg.Data
-	}
+	// Transmit, contact lists are only visible to their owner
+	h.publish(contactTopic, envelope, msg.Data, func(user string) bool {
+		return user == contact.UserA
+	})
 }
 
 func (h *Handler) ConversationHandler(msg *nats.Msg) {
 	// Validate JSON
-	updateMsg := UpdateMsg{}
-	err := json.Unmarshal(msg.Data, &updateMsg)
+	envelope, err := event.Parse(msg.Data)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	if h.seen.Seen(envelope.ID) {
+		return
+	}
 
 	conversation := Conversation{}
-	err = json.Unmarshal([]byte(updateMsg.Data), &conversation)
+	err = envelope.Decode(&conversation)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	// Transmit
-	for _, conn := range h.conversationConnections {
-		conn <- msg.Data
+	// New conversations may arrive before their members do
+	if envelope.Subject == event.ConversationCreated {
+		err = h.permissions.Sync(conversation.ID)
+		if err != nil {
+			log.Println(err)
+		}
 	}
+
+	// Transmit to members
+	h.publish(conversationTopic, envelope, msg.Data, func(user string) bool {
+		return h.permissions.Member(user, conversation.ID)
+	})
+}
+
+func (h *Handler) InviteHandler(msg *nats.Msg) {
+	// Validate JSON
+	envelope, err := event.Parse(msg.Data)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if h.seen.Seen(envelope.ID) {
+		return
+	}
+
+	invite := Invite{}
+	err = envelope.Decode(&invite)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// Transmit, only the invited user and the inviter are involved
+	h.publish(inviteTopic, envelope, msg.Data, func(user string) bool {
+		return user == invite.User || user == invite.Inviter
+	})
 }
 
 func (h *Handler) UserHandler(msg *nats.Msg) {
 	// Validate JSON
-	updateMsg := UpdateMsg{}
-	err := json.Unmarshal(msg.Data, &updateMsg)
+	envelope, err := event.Parse(msg.Data)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	if h.seen.Seen(envelope.ID) {
+		return
+	}
 
 	user := User{}
-	err = json.Unmarshal([]byte(updateMsg.Data), &user)
+	err = envelope.Decode(&user)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
 	// Transmit
-	for _, conn := range h.userConnections {
-		conn <- msg.Data
-	}
+	h.publish(userTopic, envelope, msg.Data, nil)
 }
 
 func (h *Handler) MemberHandler(msg *nats.Msg) {
 	// Validate JSON
-	updateMsg := UpdateMsg{}
-	err := json.Unmarshal(msg.Data, &updateMsg)
+	envelope, err := event.Parse(msg.Data)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	if h.seen.Seen(envelope.ID) {
+		return
+	}
 
 	member := Member{}
-	err = json.Unmarshal([]byte(updateMsg.Data), &member)
+	err = envelope.Decode(&member)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	// Get transmit channel
-	if channels, ok := h.memberConnections[member.Conversation]; ok {
-		// Transmit
-		for _, conn := range channels {
-			conn <- msg.Data
+	// Without Postgres notifications, memberships follow the events
+	if !h.notified {
+		switch envelope.Subject {
+		case event.MemberCreated:
+			h.permissions.set(member.User, member.Conversation, true)
+		case event.MemberDeleted:
+			h.permissions.set(member.User, member.Conversation, false)
 		}
-	} else {
-		log.Printf("member conversation %s not found\n", member.Conversation)
 	}
+
+	// Only the member's own devices care how far it has heard, or how it
+	// wants to be notified
+	if envelope.Subject == event.MemberLastHeard || envelope.Subject == event.MemberPreferences {
+		h.publish(memberTopic(member.Conversation), envelope, msg.Data, func(user string) bool {
+			return user == member.User
+		})
+		return
+	}
+
+	// Transmit to members, and to the member the event is about
+	h.publish(memberTopic(member.Conversation), envelope, msg.Data, func(user string) bool {
+		return user == member.User || h.permissions.Member(user, member.Conversation)
+	})
+}
+
+// publish sends an event to the clients of topic that allow admits, leaving
+// out users blocked by the user who caused it. A nil allow admits everyone.
+func (h *Handler) publish(topic string, envelope *event.Envelope, msg []byte, allow func(user string) bool) {
+	h.hub.Publish(topic, msg, func(user string) bool {
+		if h.blocks.Blocked(user, envelope.Actor) {
+			return false
+		}
+		return allow == nil || allow(user)
+	})
+}
+
+// Number of event IDs remembered to drop duplicate deliveries
+const seenEventsSize = 1024
+
+// seenEvents remembers the most recent event IDs, since the outbox may
+// deliver an event more than once
+type seenEvents struct {
+	mu    sync.Mutex
+	ids   map[string]bool
+	order []string
+	next  int
+}
+
+func newSeenEvents(size int) *seenEvents {
+	return &seenEvents{
+		ids:   make(map[string]bool),
+		order: make([]string, size),
+	}
+}
+
+// Seen records id, reporting whether it was already recorded
+func (s *seenEvents) Seen(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ids[id] {
+		return true
+	}
+	delete(s.ids, s.order[s.next])
+	s.order[s.next] = id
+	s.next = (s.next + 1) % len(s.order)
+	s.ids[id] = true
+	return false
 }
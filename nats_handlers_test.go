@@ -0,0 +1,210 @@
+// +build unit
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+
+	"backend/core/event"
+	"backend/core/store/memory"
+)
+
+func receive(t *testing.T, client *Client) *event.Envelope {
+	select {
+	case msg := <-client.Messages():
+		envelope, err := event.Parse(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return envelope
+	case <-time.After(5 * time.Second):
+		t.Fatal("Want an event, got none")
+	}
+	return nil
+}
+
+func TestNatsDelivery(t *testing.T) {
+	s := runNatsServer(t)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	m := memory.New(nil)
+	h := NewStoreHandler(m, nc)
+
+	t.Run("User", func(t *testing.T) {
+		client := h.hub.Register(userTopic, "u-b")
+		defer h.hub.Unregister(userTopic, client)
+
+		event.Publish(nc, event.UserUpdated, "u-a", &User{ID: "u-a", FirstName: "Test"})
+		nc.Flush()
+
+		envelope := receive(t, client)
+		user := User{}
+		envelope.Decode(&user)
+		if envelope.Subject != event.UserUpdated || user.ID != "u-a" || user.FirstName != "Test" {
+			t.Errorf("Want the published user, got %s %v", envelope.Subject, user)
+		}
+	})
+
+	t.Run("Contact", func(t *testing.T) {
+		client := h.hub.Register(contactTopic, "u-a")
+		defer h.hub.Unregister(contactTopic, client)
+
+		event.Publish(nc, event.ContactCreated, "u-b", &Contact{UserA: "u-b", UserB: "u-a"})
+		event.Publish(nc, event.ContactCreated, "u-a", &Contact{UserA: "u-a", UserB: "u-b"})
+		nc.Flush()
+
+		// Only the contact owned by the subscriber arrives
+		envelope := receive(t, client)
+		contact := Contact{}
+		envelope.Decode(&contact)
+		if contact.UserA != "u-a" {
+			t.Errorf("Want a contact owned by u-a, got %v", contact)
+		}
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		client := h.hub.Register(userTopic, "u-b")
+		defer h.hub.Unregister(userTopic, client)
+
+		// The outbox may relay an event twice
+		envelope, _ := event.New(event.UserUpdated, "u-a", &User{ID: "u-a"})
+		b, _ := json.Marshal(envelope)
+		nc.Publish(event.UserUpdated, b)
+		nc.Publish(event.UserUpdated, b)
+		event.Publish(nc, event.UserUpdated, "u-c", &User{ID: "u-c"})
+		nc.Flush()
+
+		if got := receive(t, client); got.ID != envelope.ID {
+			t.Errorf("Want event %s, got %s", envelope.ID, got.ID)
+		}
+		if got := receive(t, client); got.Actor != "u-c" {
+			t.Errorf("Want the duplicate to be dropped, got %s from %s", got.ID, got.Actor)
+		}
+	})
+	t.Run("LastHeard", func(t *testing.T) {
+		topic := memberTopic("c-a")
+		own := h.hub.Register(topic, "u-a")
+		defer h.hub.Unregister(topic, own)
+		other := h.hub.Register(topic, "u-b")
+		defer h.hub.Unregister(topic, other)
+
+		event.Publish(nc, event.MemberLastHeard, "u-a", &LastHeard{User: "u-a", Conversation: "c-a", LastHeard: 1})
+		event.Publish(nc, event.MemberUpdated, "u-b", &Member{User: "u-b", Conversation: "c-a"})
+		nc.Flush()
+
+		if got := receive(t, own); got.Subject != event.MemberLastHeard {
+			t.Errorf("Want %s, got %s", event.MemberLastHeard, got.Subject)
+		}
+		// Other members never see it
+		if got := receive(t, other); got.Subject != event.MemberUpdated {
+			t.Errorf("Want %s, got %s", event.MemberUpdated, got.Subject)
+		}
+	})
+	t.Run("Preferences", func(t *testing.T) {
+		topic := memberTopic("c-a")
+		own := h.hub.Register(topic, "u-a")
+		defer h.hub.Unregister(topic, own)
+		other := h.hub.Register(topic, "u-b")
+		defer h.hub.Unregister(topic, other)
+
+		event.Publish(nc, event.MemberPreferences, "u-a", &MemberPreferences{User: "u-a", Conversation: "c-a", Notifications: NotifyNone})
+		event.Publish(nc, event.MemberUpdated, "u-b", &Member{User: "u-b", Conversation: "c-a"})
+		nc.Flush()
+
+		if got := receive(t, own); got.Subject != event.MemberPreferences {
+			t.Errorf("Want %s, got %s", event.MemberPreferences, got.Subject)
+		}
+		// Other members never see it
+		if got := receive(t, other); got.Subject != event.MemberUpdated {
+			t.Errorf("Want %s, got %s", event.MemberUpdated, got.Subject)
+		}
+	})
+	t.Run("Invite", func(t *testing.T) {
+		invited := h.hub.Register(inviteTopic, "u-b")
+		defer h.hub.Unregister(inviteTopic, invited)
+		other := h.hub.Register(inviteTopic, "u-c")
+		defer h.hub.Unregister(inviteTopic, other)
+
+		event.Publish(nc, event.InviteCreated, "u-a", &Invite{ID: "i-a", Conversation: "c-a", User: "u-b", Inviter: "u-a"})
+		event.Publish(nc, event.InviteCreated, "u-a", &Invite{ID: "i-b", Conversation: "c-a", User: "u-c", Inviter: "u-a"})
+		nc.Flush()
+
+		// Nobody sees invites of others
+		invite := Invite{}
+		receive(t, invited).Decode(&invite)
+		if invite.ID != "i-a" {
+			t.Errorf("Want invite i-a, got %v", invite)
+		}
+		receive(t, other).Decode(&invite)
+		if invite.ID != "i-b" {
+			t.Errorf("Want invite i-b, got %v", invite)
+		}
+	})
+	t.Run("Conversation", func(t *testing.T) {
+		registerAll(t, m, "u-x", "u-z")
+
+		// Subscribed from before the conversation
+		for _, user := range []string{"u-x", "u-z"} {
+			if err := h.permissions.Acquire(user); err != nil {
+				t.Fatal(err)
+			}
+			defer h.permissions.Release(user)
+		}
+		owner := h.hub.Register(conversationTopic, "u-x")
+		defer h.hub.Unregister(conversationTopic, owner)
+		other := h.hub.Register(conversationTopic, "u-z")
+		defer h.hub.Unregister(conversationTopic, other)
+		added := h.hub.Register(memberTopic("c-x"), "u-z")
+		defer h.hub.Unregister(memberTopic("c-x"), added)
+
+		conversation := Conversation{ID: "c-x"}
+		if err := m.Conversations().Create(&conversation, "u-x"); err != nil {
+			t.Fatal(err)
+		}
+		event.Publish(nc, event.ConversationCreated, "u-x", &conversation)
+		nc.Flush()
+		if got := receive(t, owner); got.Subject != event.ConversationCreated {
+			t.Errorf("Want %s, got %s", event.ConversationCreated, got.Subject)
+		}
+
+		// Without Postgres, members are let in by their events
+		member := Member{User: "u-z", Conversation: "c-x", Role: RoleMember}
+		if err := m.Conversations().AddMember(member); err != nil {
+			t.Fatal(err)
+		}
+		event.Publish(nc, event.MemberCreated, "u-x", &member)
+		nc.Flush()
+		receive(t, added)
+		event.Publish(nc, event.ConversationUpdated, "u-x", &conversation)
+		nc.Flush()
+		if got := receive(t, other); got.Subject != event.ConversationUpdated {
+			t.Errorf("Want %s only once a member, got %s", event.ConversationUpdated, got.Subject)
+		}
+	})
+	t.Run("Blocked", func(t *testing.T) {
+		client := h.hub.Register(userTopic, "u-b")
+		defer h.hub.Unregister(userTopic, client)
+
+		// u-a blocked u-b
+		h.blocks.users["u-b"] = &cachedUser{refs: 1, keys: map[string]bool{"u-a": true}}
+		defer h.blocks.Release("u-b")
+
+		event.Publish(nc, event.UserUpdated, "u-a", &User{ID: "u-a"})
+		event.Publish(nc, event.UserUpdated, "u-c", &User{ID: "u-c"})
+		nc.Flush()
+
+		if got := receive(t, client); got.Actor != "u-c" {
+			t.Errorf("Want events of the blocker to be left out, got one from %s", got.Actor)
+		}
+	})
+}
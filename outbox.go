@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nats-io/go-nats"
+
+	"backend/core/event"
+)
+
+const (
+	outboxInterval   = 5 * time.Second // how often pending events are polled for
+	outboxBatchSize  = 100             // events relayed per transaction
+	outboxMaxBackoff = 5 * time.Minute // longest wait between retries of an event
+	outboxRetention  = 24 * time.Hour  // how long sent events are kept around
+	outboxFlush      = 5 * time.Second // how long NATs has to acknowledge a batch
+)
+
+// Outbox stores events in the same transaction as the change they describe,
+// and relays them to NATs afterwards. Events are delivered at least once;
+// consumers should use the envelope ID to drop duplicates.
+type Outbox struct {
+	db *sql.DB
+	nc *nats.Conn
+
+	wake chan struct{}
+}
+
+func NewOutbox(db *sql.DB, nc *nats.Conn) *Outbox {
+	return &Outbox{
+		db:   db,
+		nc:   nc,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Enqueue wraps each payload in an event envelope and stores them as part of
+// tx, in a single statement. Nothing is stored if NATs isn't configured.
+func (o *Outbox) Enqueue(tx *sql.Tx, subject string, actor string, payloads ...interface{}) error {
+	if o.nc == nil || len(payloads) < 1 {
+		return nil
+	}
+
+	ids := make([]string, len(payloads))
+	bodies := make([][]byte, len(payloads))
+	for i, payload := range payloads {
+		envelope, err := event.New(subject, actor, payload)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(envelope)
+		if err != nil {
+			return err
+		}
+		ids[i] = envelope.ID
+		bodies[i] = b
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO outbox (event_id, subject, payload)
+			SELECT event_id, $2, payload FROM unnest($1::VARCHAR[], $3::BYTEA[]) AS e(event_id, payload)
+	`, pq.Array(ids), subject, pq.Array(bodies))
+	return err
+}
+
+// Wake makes the relay look for pending events immediately
+func (o *Outbox) Wake() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Relay publishes pending events until the process exits. It blocks, so it
+// should be run in its own goroutine.
+func (o *Outbox) Relay() {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the backlog before waiting again
+		for {
+			n, err := o.relay()
+			if err != nil {
+				log.Print(err)
+				break
+			}
+			if n < outboxBatchSize {
+				break
+			}
+		}
+
+		err := o.clean()
+		if err != nil {
+			log.Print(err)
+		}
+
+		select {
+		case <-o.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+type outboxEvent struct {
+	id       int64
+	subject  string
+	payload  []byte
+	attempts int
+}
+
+// relay publishes one batch of pending events, returning how many were found
+func (o *Outbox) relay() (int, error) {
+	tx, err := o.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Other instances skip the rows we are relaying
+	rows, err := tx.Query(`
+		SELECT id, subject, payload, attempts FROM outbox
+		WHERE sent_at IS NULL AND next_attempt_at <= NOW()
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	events := make([]outboxEvent, 0)
+	for rows.Next() {
+		e := outboxEvent{}
+		if err := rows.Scan(&e.id, &e.subject, &e.payload, &e.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(events) < 1 {
+		return 0, nil
+	}
+
+	// Publish, and wait for NATs to have received the whole batch
+	var publishErr error
+	for _, e := range events {
+		publishErr = o.nc.Publish(e.subject, e.payload)
+		if publishErr != nil {
+			break
+		}
+	}
+	if publishErr == nil {
+		publishErr = o.nc.FlushTimeout(outboxFlush)
+	}
+
+	for _, e := range events {
+		if publishErr == nil {
+			_, err = tx.Exec(`
+				UPDATE outbox SET sent_at = NOW(), attempts = attempts + 1, last_error = NULL WHERE id = $1
+			`, e.id)
+		} else {
+			_, err = tx.Exec(`
+				UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3 WHERE id = $1
+			`, e.id, time.Now().Add(outboxBackoff(e.attempts+1)), publishErr.Error())
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	if publishErr != nil {
+		return 0, publishErr
+	}
+	return len(events), nil
+}
+
+// clean forgets events that were sent a while ago
+func (o *Outbox) clean() error {
+	_, err := o.db.Exec(`
+		DELETE FROM outbox WHERE sent_at < $1
+	`, time.Now().Add(-outboxRetention))
+	return err
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	backoff := time.Second
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i += 1 {
+		backoff *= 2
+	}
+	if backoff > outboxMaxBackoff {
+		backoff = outboxMaxBackoff
+	}
+	return backoff
+}
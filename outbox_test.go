@@ -0,0 +1,94 @@
+// +build integration
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/go-nats"
+
+	"backend/core/event"
+)
+
+func TestOutbox(t *testing.T) {
+	db := connect()
+	defer db.Close()
+	s := runNatsServer(t)
+	defer s.Shutdown()
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	h := NewHandler(db, nc)
+	r := NewRouter(h)
+
+	t.Run("Relay", testOutboxRelay(db, nc, r))
+}
+
+func testOutboxRelay(db *sql.DB, nc *nats.Conn, router http.Handler) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		sub, err := nc.SubscribeSync(event.UserCreated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Unsubscribe()
+
+		mockUser := User{
+			PhoneNumber: "+65 9999 2001",
+			FirstName:   "Outbox",
+			LastName:    "User",
+		}
+		b, _ := json.Marshal(mockUser)
+
+		// Test
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/user", bytes.NewBuffer(b))
+
+		router.ServeHTTP(w, r)
+		assertCode(t, w, 200)
+
+		createdUser := User{}
+		json.NewDecoder(w.Body).Decode(&createdUser)
+
+		// Assert
+		msg, err := sub.NextMsg(10 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelope, err := event.Parse(msg.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := User{}
+		envelope.Decode(&got)
+		if got.ID != createdUser.ID {
+			t.Errorf("Want event for user %s, got %s", createdUser.ID, got.ID)
+		}
+		if got.PhoneNumber != "" {
+			t.Errorf("Want the phone number left out of events by default, got %s", got.PhoneNumber)
+		}
+
+		assertDB(t, db, `SELECT * FROM outbox WHERE event_id = $1`, envelope.ID)
+
+		// Marking as sent happens after the publish, so allow it a moment
+		for i := 0; i < 50; i += 1 {
+			var sent bool
+			db.QueryRow(`SELECT sent_at IS NOT NULL FROM outbox WHERE event_id = $1`, envelope.ID).Scan(&sent)
+			if sent {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Error("Want outbox event to be marked as sent")
+
+	}
+}
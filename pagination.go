@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 50  // rows per page when no limit is supplied
+	maxPageLimit     = 200 // most rows per page
+)
+
+var errInvalidPage = &Error{Status: http.StatusBadRequest, Code: CodeInvalidPage, Message: "invalid limit or cursor"}
+
+// Page is a window of a list endpoint. Lists are sorted on a key unique to
+// every row, and a page starts right after the key in its cursor.
+type Page struct {
+	Limit int      // rows to return
+	After []string // sort key of the last row of the previous page, nil on the first page
+}
+
+// ParsePage reads the limit and cursor query parameters. keySize is the
+// number of values in the sort key of the list.
+func ParsePage(r *http.Request, keySize int) (*Page, error) {
+	page := &Page{Limit: defaultPageLimit}
+
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return nil, errInvalidPage
+		}
+		page.Limit = n
+	}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, errInvalidPage
+		}
+		err = json.Unmarshal(b, &page.After)
+		if err != nil || len(page.After) != keySize {
+			return nil, errInvalidPage
+		}
+	}
+
+	return page, nil
+}
+
+// First reports whether this is the first page
+func (p *Page) First() bool {
+	return p.After == nil
+}
+
+// Key returns the i-th value of the sort key to start after, or def on the
+// first page, so queries can take it as a parameter either way
+func (p *Page) Key(i int, def string) string {
+	if p.First() {
+		return def
+	}
+	return p.After[i]
+}
+
+// Fetch is how many rows to select: one more than the limit, to find out
+// whether there is a next page
+func (p *Page) Fetch() int {
+	return p.Limit + 1
+}
+
+// More reports whether n selected rows leave a next page
+func (p *Page) More(n int) bool {
+	return n > p.Limit
+}
+
+// SetNext points the client at the page after the row with sort key key, with
+// a Link header and an X-Next-Cursor header. Call it before writing the body.
+func SetNext(w http.ResponseWriter, r *http.Request, page *Page, key ...string) {
+	b, _ := json.Marshal(key)
+	cursor := base64.RawURLEncoding.EncodeToString(b)
+
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	query.Set("limit", strconv.Itoa(page.Limit))
+	next := *r.URL
+	next.RawQuery = query.Encode()
+
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	w.Header().Set("X-Next-Cursor", cursor)
+}
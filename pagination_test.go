@@ -0,0 +1,77 @@
+// +build unit
+
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		target string
+		limit  int
+		valid  bool
+	}{
+		{"/list", defaultPageLimit, true},
+		{"/list?limit=10", 10, true},
+		{"/list?limit=0", 0, false},
+		{"/list?limit=1000", 0, false},
+		{"/list?limit=ten", 0, false},
+		{"/list?cursor=!!!", 0, false},
+		{"/list?cursor=WyJhIl0", 0, false}, // ["a"], too short a key
+	}
+
+	for _, test := range tests {
+		page, err := ParsePage(httptest.NewRequest("GET", test.target, nil), 2)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: want valid %t, got %v", test.target, test.valid, err)
+			continue
+		}
+		if err == nil && (page.Limit != test.limit || !page.First()) {
+			t.Errorf("%s: want a first page of %d, got %v", test.target, test.limit, page)
+		}
+	}
+}
+
+func TestSetNext(t *testing.T) {
+	r := httptest.NewRequest("GET", "/list?limit=2&other=kept", nil)
+	page, _ := ParsePage(r, 2)
+
+	// Next
+	w := httptest.NewRecorder()
+	SetNext(w, r, page, "a", "b")
+
+	link := w.Header().Get("Link")
+	if !strings.HasPrefix(link, "</list?") || !strings.HasSuffix(link, `>; rel="next"`) {
+		t.Fatalf("Want a Link to the next page, got %s", link)
+	}
+	next, err := url.Parse(strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := next.Query().Get("other"); got != "kept" {
+		t.Errorf("Want other parameters kept, got %s", got)
+	}
+	if got, want := next.Query().Get("cursor"), w.Header().Get("X-Next-Cursor"); got != want {
+		t.Errorf("Want cursor %s in the Link, got %s", want, got)
+	}
+
+	// Round trip
+	page, err = ParsePage(httptest.NewRequest("GET", next.String(), nil), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Limit != 2 || !reflect.DeepEqual(page.After, []string{"a", "b"}) {
+		t.Errorf("Want to continue after [a b] 2 at a time, got %v", page)
+	}
+	if got := page.Key(1, "default"); got != "b" {
+		t.Errorf("Want key b, got %s", got)
+	}
+	if !page.More(3) || page.More(2) {
+		t.Error("Want a next page only when more than the limit was fetched")
+	}
+}
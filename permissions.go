@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	"backend/core/store"
+)
+
+// How long a user who left a conversation still receives its events, so that
+// they get to see the event that removed them
+const leaveGracePeriod = 30 * time.Second
+
+// Permissions caches the conversation memberships of users with open
+// subscriptions, kept current by the member_new/member_delete triggers, or
+// by member events without Postgres.
+type Permissions struct {
+	*notifyCache
+	store store.Store
+}
+
+func NewPermissions(s store.Store) *Permissions {
+	return &Permissions{newNotifyCache(s.Conversations().IDs, "member_new", "member_delete", func(user, conversation string) (string, string) {
+		return user, conversation
+	}, leaveGracePeriod), s}
+}
+
+// Member reports whether user is, or very recently was, a member of
+// conversation. Only users acquired into the cache are ever members.
+func (p *Permissions) Member(user, conversation string) bool {
+	return p.has(user, conversation)
+}
+
+// Sync reloads the members of a conversation for cached users. An event
+// announcing a new conversation may overtake its member_new notification.
+func (p *Permissions) Sync(conversation string) error {
+	users, err := p.store.Conversations().MemberIDs(conversation)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		p.set(user, conversation, true)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/guregu/null.v3"
+
+	"backend/core/event"
+	"backend/core/store"
+)
+
+func validNotifications(level string) bool {
+	return level == NotifyAll || level == NotifyMentions || level == NotifyNone
+}
+
+// decodeField unmarshals body[key] into v, leaving v alone if key is absent
+func decodeField(body map[string]json.RawMessage, key string, v interface{}) error {
+	raw, ok := body[key]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func (h *Handler) UpdateMemberPreferences(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse, preferences left out stay as they are
+	userID := r.Context().Value("user").(string)
+	conversationID := p.ByName("conversation")
+	body := make(map[string]json.RawMessage)
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&body)
+	if err != nil {
+		writeError(w, r, malformed(err))
+		return
+	}
+	var archived null.Bool
+	var mutedUntil null.Time
+	var notifications null.String
+	violations := make([]FieldError, 0)
+	if decodeField(body, "archived", &archived) != nil {
+		violations = append(violations, FieldError{"archived", FieldInvalid, "must be a boolean"})
+	} else if _, ok := body["archived"]; ok && !archived.Valid {
+		violations = append(violations, FieldError{"archived", FieldInvalid, "can't be null"})
+	}
+	if decodeField(body, "muted_until", &mutedUntil) != nil {
+		violations = append(violations, FieldError{"muted_until", FieldInvalid, "must be an RFC 3339 time"})
+	}
+	_, setMuted := body["muted_until"] // null unmutes
+	if decodeField(body, "notifications", &notifications) != nil {
+		violations = append(violations, FieldError{"notifications", FieldInvalid, "must be a string"})
+	} else if _, ok := body["notifications"]; ok && !validNotifications(notifications.String) {
+		violations = append(violations, FieldError{"notifications", FieldInvalid, "must be all, mentions or none"})
+	}
+
+	// Validate
+	if len(violations) > 0 {
+		writeError(w, r, invalid(violations...))
+		return
+	}
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Update
+	preferences, err := tx.Conversations().SetPreferences(userID, conversationID, store.PreferenceChanges{
+		Archived:      archived,
+		Mute:          setMuted,
+		MutedUntil:    mutedUntil,
+		Notifications: notifications,
+	})
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs
+	err = tx.Enqueue(event.MemberPreferences, userID, &preferences)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preferences)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"backend/core/event"
+	"backend/core/store"
+)
+
+// Who can see a private field of a user
+const (
+	VisibilityEveryone = store.VisibilityEveryone
+	VisibilityContacts = store.VisibilityContacts
+	VisibilityNobody   = store.VisibilityNobody
+)
+
+func validVisibility(visibility string) bool {
+	switch visibility {
+	case VisibilityEveryone, VisibilityContacts, VisibilityNobody:
+		return true
+	}
+	return false
+}
+
+// audience is how the viewer of a user relates to them
+type audience struct {
+	Self    bool // the viewer is the user
+	Contact bool // the user has the viewer as a contact
+	Saved   bool // the viewer has the user as a contact
+}
+
+func (a audience) sees(visibility string) bool {
+	switch visibility {
+	case VisibilityEveryone:
+		return true
+	case VisibilityContacts:
+		return a.Self || a.Contact
+	}
+	return a.Self
+}
+
+// applyPrivacy clears the fields of user that a can't see. Contacts are added
+// by phone number, so users who saved someone as a contact always see theirs.
+func applyPrivacy(user *User, privacy Privacy, a audience) {
+	if !a.sees(privacy.PhoneNumber) && !a.Saved {
+		user.PhoneNumber = ""
+	}
+	if !a.sees(privacy.Bio) {
+		user.Bio = ""
+	}
+	if !a.sees(privacy.ProfilePic) {
+		user.ProfilePic = ""
+	}
+}
+
+// shapeUsers applies the privacy settings of users for viewer. An empty
+// viewer is anyone at all.
+func shapeUsers(s store.UserStore, viewer string, users []User) error {
+	if len(users) < 1 {
+		return nil
+	}
+
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+
+	relations, err := s.Relations(viewer, ids)
+	if err != nil {
+		return err
+	}
+
+	// Users that weren't found show nothing private
+	for i := range users {
+		relation, ok := relations[users[i].ID]
+		if !ok {
+			relation.Privacy = Privacy{PhoneNumber: VisibilityNobody, Bio: VisibilityNobody, ProfilePic: VisibilityNobody}
+		}
+		applyPrivacy(&users[i], relation.Privacy, audience{
+			Self:    viewer != "" && users[i].ID == viewer,
+			Contact: relation.Contact,
+			Saved:   relation.Saved,
+		})
+	}
+	return nil
+}
+
+// shapeUser is shapeUsers for a single user
+func shapeUser(s store.UserStore, viewer string, user *User) error {
+	users := []User{*user}
+	err := shapeUsers(s, viewer, users)
+	*user = users[0]
+	return err
+}
+
+func (h *Handler) GetPrivacy(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+
+	// Select
+	privacy, err := h.store.Users().Privacy(userID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(privacy)
+}
+
+func (h *Handler) SetPrivacy(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	privacy := Privacy{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&privacy)
+	if err != nil {
+		writeError(w, r, malformed(err))
+		return
+	}
+
+	// Validate
+	violations := make([]FieldError, 0)
+	fields := []string{"phone_number", "bio", "profile_pic"}
+	for i, visibility := range []string{privacy.PhoneNumber, privacy.Bio, privacy.ProfilePic} {
+		if !validVisibility(visibility) {
+			violations = append(violations, FieldError{fields[i], FieldInvalid, "must be everyone, contacts or nobody"})
+		}
+	}
+	if len(violations) > 0 {
+		writeError(w, r, invalid(violations...))
+		return
+	}
+
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Update
+	user, err := tx.Users().SetPrivacy(userID, privacy)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs, user events are for everyone
+	applyPrivacy(&user, privacy, audience{})
+	err = tx.Enqueue(event.UserUpdated, userID, &user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(privacy)
+}
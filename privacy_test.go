@@ -0,0 +1,37 @@
+// +build unit
+
+package main
+
+import (
+	"testing"
+)
+
+func TestApplyPrivacy(t *testing.T) {
+	user := User{ID: "u-a", Bio: "bio", ProfilePic: "pic", PhoneNumber: "+65 9999 0001"}
+	everyone := Privacy{PhoneNumber: VisibilityEveryone, Bio: VisibilityEveryone, ProfilePic: VisibilityEveryone}
+	contacts := Privacy{PhoneNumber: VisibilityContacts, Bio: VisibilityContacts, ProfilePic: VisibilityContacts}
+	nobody := Privacy{PhoneNumber: VisibilityNobody, Bio: VisibilityNobody, ProfilePic: VisibilityNobody}
+
+	tests := []struct {
+		name     string
+		privacy  Privacy
+		audience audience
+		want     User
+	}{
+		{"Everyone", everyone, audience{}, user},
+		{"Contacts to stranger", contacts, audience{}, User{ID: "u-a"}},
+		{"Contacts to contact", contacts, audience{Contact: true}, user},
+		{"Nobody to contact", nobody, audience{Contact: true}, User{ID: "u-a"}},
+		{"Nobody to self", nobody, audience{Self: true}, user},
+		{"Nobody to saved", nobody, audience{Saved: true}, User{ID: "u-a", PhoneNumber: user.PhoneNumber}},
+		{"Mixed", Privacy{PhoneNumber: VisibilityNobody, Bio: VisibilityEveryone, ProfilePic: VisibilityContacts}, audience{}, User{ID: "u-a", Bio: "bio"}},
+	}
+
+	for _, test := range tests {
+		got := user
+		applyPrivacy(&got, test.privacy, test.audience)
+		if got != test.want {
+			t.Errorf("%s: want %v, got %v", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"backend/core/event"
+)
+
+const (
+	purgeInterval  = time.Minute // how often deleted conversations and expired invites are looked for
+	purgeBatchSize = 100         // conversations or invites purged per transaction
+)
+
+// How long deleted conversations can be restored, set with
+// CONVERSATION_RETENTION
+const defaultConversationRetention = 30 * 24 * time.Hour
+
+var conversationRetention = defaultConversationRetention
+
+// Purge removes conversations deleted longer than the retention ago for good,
+// and invites past their expiry, until the process exits. It blocks, so it
+// should be run in its own goroutine.
+func (h *Handler) Purge() {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the backlogs before waiting again
+		drain(h.purge)
+		drain(h.purgeInvites)
+
+		<-ticker.C
+	}
+}
+
+// drain runs purge until it finds less than a full batch
+func drain(purge func() (int, error)) {
+	for {
+		n, err := purge()
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if n < purgeBatchSize {
+			return
+		}
+	}
+}
+
+// purge removes one batch of expired conversations, returning how many were
+// found
+func (h *Handler) purge() (int, error) {
+	tx, err := h.store.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Other instances skip the conversations we are purging
+	expired, err := tx.Conversations().Expired(conversationRetention, purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(expired) < 1 {
+		return 0, nil
+	}
+	ids := make([]string, len(expired))
+	for i, trashed := range expired {
+		ids[i] = trashed.Conversation
+	}
+
+	// Delete, invites and links go along
+	err = tx.Conversations().Purge(ids)
+	if err != nil {
+		return 0, err
+	}
+
+	// Publish NATs, on behalf of whoever deleted them
+	for _, trashed := range expired {
+		err = tx.Enqueue(event.ConversationDeleted, trashed.By, &Conversation{
+			ID: trashed.Conversation,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	h.outbox.Wake()
+	return len(ids), nil
+}
+
+// purgeInvites removes one batch of expired invites, returning how many were
+// found. Nobody is told, as expired invites were already hidden.
+func (h *Handler) purgeInvites() (int, error) {
+	return h.store.Conversations().PurgeInvites(purgeBatchSize)
+}
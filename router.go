@@ -9,41 +9,69 @@ func NewRouter(h *Handler) *httprouter.Router {
 
 	// Users
 	router.POST("/user", h.CreateUser)
-	router.GET("/user", h.GetUserByPhone)
-	router.GET("/user/id/:user", h.GetUser)
-	router.GET("/user/username/:username", h.GetUserByUsername)
+	router.GET("/user", AuthMiddleware(h.GetUserByPhone))
+	router.GET("/user/id/:user", AuthMiddleware(h.GetUser))
+	router.GET("/user/username/:username", AuthMiddleware(h.GetUserByUsername))
 	router.PATCH("/user", AuthMiddleware(h.UpdateUser))
+	router.POST("/user/discover", AuthMiddleware(h.DiscoverUsers))
+	router.GET("/user/search", AuthMiddleware(h.SearchUsers))
+	router.GET("/user/privacy", AuthMiddleware(h.GetPrivacy))
+	router.PUT("/user/privacy", AuthMiddleware(h.SetPrivacy))
+
+	// Blocks
+	router.GET("/user/block", AuthMiddleware(h.GetBlockedUsers))
+	router.PUT("/user/block/:user", AuthMiddleware(h.BlockUser))
+	router.DELETE("/user/block/:user", AuthMiddleware(h.UnblockUser))
 
 	// Conversations
 	router.POST("/user/conversation", AuthMiddleware(h.CreateConversation))
-	router.GET("/user/conversation", AuthMiddleware(h.GetConversations)) // USER MEMBER CONVERSATION
+	router.GET("/user/conversation", AuthMiddleware(h.GetConversations)) // USER MEMBER CONVERSATION, ?members= -> GetConversationsByMembers
 	router.DELETE("/user/conversation/:conversation", AuthMiddleware(h.DeleteConversation))
-	//router.GET("/user/:user/conversation/bymembers/", h.GetConversationsByMembers) // TODO
 	router.GET("/user/conversation/:conversation", AuthMiddleware(h.GetConversation))      // USER MEMBER CONVERSATION
 	router.PATCH("/user/conversation/:conversation", AuthMiddleware(h.UpdateConversation)) // USER MEMBER CONVERSATION ADMIN=true -> update conversation title
-	//router.DELETE("/user/:user/conversation/:conversation", h.DeleteConversation) // USER MEMBER CONVERSATION -> delete membership
+	router.POST("/user/conversation/:conversation/restore", AuthMiddleware(h.RestoreConversation))
 	router.POST("/user/conversation/:conversation/pin", AuthMiddleware(h.PinConversation))
 	router.DELETE("/user/conversation/:conversation/pin", AuthMiddleware(h.UnpinConversation))
-	router.POST("/user/conversation/:conversation/member", AuthMiddleware(h.CreateConversationMember)) // USER MEMBER CONVERSATION ADMIN=true -> create new membership
-	router.GET("/user/conversation/:conversation/member", AuthMiddleware(h.GetConversationMembers))    // USER MEMBER CONVERSATION
-	//router.DELETE("/user/:user/conversation/:conversation/member/:member", h.DeleteConversationMember) // USER MEMBER CONVERSATION ADMIN=true -> delete membership
+	router.PATCH("/user/conversation/:conversation/preferences", AuthMiddleware(h.UpdateMemberPreferences))
+	router.POST("/user/conversation/:conversation/member", AuthMiddleware(h.CreateConversationMember))                 // USER MEMBER CONVERSATION ADMIN=true -> create new membership
+	router.GET("/user/conversation/:conversation/member", AuthMiddleware(h.GetConversationMembers))                    // USER MEMBER CONVERSATION
+	router.GET("/user/conversation/:conversation/invite", AuthMiddleware(h.GetConversationInvites))                    // USER MEMBER CONVERSATION ADMIN=true
+	router.POST("/user/conversation/:conversation/link", AuthMiddleware(h.CreateInviteLink))                           // USER MEMBER CONVERSATION ADMIN=true
+	router.GET("/user/conversation/:conversation/link", AuthMiddleware(h.GetInviteLinks))                              // USER MEMBER CONVERSATION ADMIN=true
+	router.DELETE("/user/conversation/:conversation/link/:token", AuthMiddleware(h.RevokeInviteLink))                  // USER MEMBER CONVERSATION ADMIN=true
+	router.DELETE("/user/conversation/:conversation/member", AuthMiddleware(h.LeaveConversation))                      // USER MEMBER CONVERSATION -> delete membership
+	router.DELETE("/user/conversation/:conversation/member/:member", AuthMiddleware(h.DeleteConversationMember))       // USER MEMBER CONVERSATION ADMIN=true -> delete membership
+	router.POST("/user/conversation/:conversation/member/:member/admin", AuthMiddleware(h.PromoteConversationMember))  // USER MEMBER CONVERSATION ADMIN=true -> promote member to admin
+	router.DELETE("/user/conversation/:conversation/member/:member/admin", AuthMiddleware(h.DemoteConversationMember)) // USER MEMBER CONVERSATION OWNER=true -> demote admin to member
+	router.POST("/user/conversation/:conversation/owner", AuthMiddleware(h.TransferConversation))                      // USER MEMBER CONVERSATION OWNER=true -> transfer ownership
+	router.PUT("/user/dm/:user", AuthMiddleware(h.GetOrCreateDM))                                                      // USER MEMBER CONVERSATION DM=true
+
+	// Invites
+	router.GET("/user/invite", AuthMiddleware(h.GetInvites))
+	router.POST("/user/invite/:invite/accept", AuthMiddleware(h.AcceptInvite))
+	router.POST("/user/invite/:invite/decline", AuthMiddleware(h.DeclineInvite))
+
+	// Invite links
+	router.POST("/user/link/:token", AuthMiddleware(h.RedeemInviteLink))
 
 	// Last heard
-	//router.GET("/user/:user/lastheard/:conversation", h.GetLastheard)
-	//router.PUT("/user/:user/lastheard/:conversation", h.SetLastheard)
+	router.GET("/user/lastheard/:conversation", AuthMiddleware(h.GetLastHeard)) // USER MEMBER CONVERSATION
+	router.PUT("/user/lastheard/:conversation", AuthMiddleware(h.SetLastHeard)) // USER MEMBER CONVERSATION
 
 	// Contacts
 	router.POST("/user/contact", AuthMiddleware(h.CreateContact))
 	router.GET("/user/contact", AuthMiddleware(h.GetContacts))
-	//router.GET("/user/:user/contact/:contact", h.GetContact)
-	//router.DELETE("/user/:user/contact/:contact", h.DeleteContact)
-	//router.GET("/user/:user/contact/:contact/conversation/", h.GetContactConversations)
+	router.POST("/user/contact/sync", AuthMiddleware(h.SyncContacts))
+	router.GET("/user/contact/:contact", AuthMiddleware(h.GetContact))
+	router.DELETE("/user/contact/:contact", AuthMiddleware(h.DeleteContact))
+	router.GET("/user/contact/:contact/conversation", AuthMiddleware(h.GetContactConversations)) // USER MEMBER CONVERSATION, MEMBER CONTACT
 
 	// Subscribe
 	router.GET("/user/subscribe/contact", AuthMiddleware(h.SubscribeContact))
 	router.GET("/user/subscribe/conversation", AuthMiddleware(h.SubscribeConversation))
 	router.GET("/user/subscribe", AuthMiddleware(h.SubscribeUser))
 	router.GET("/user/subscribe/conversation/:conversation/member", AuthMiddleware(h.SubscribeMember))
+	router.GET("/user/subscribe/invite", AuthMiddleware(h.SubscribeInvite))
 
 	return router
 }
@@ -0,0 +1,123 @@
+package memory
+
+import (
+	"sort"
+
+	"backend/core/store"
+)
+
+type contacts struct {
+	access
+}
+
+func (s *contacts) Create(user string, contact string) error {
+	return s.write(func(d *data) error {
+		if d.contacts[pair{user, contact}] {
+			return store.ErrConflict
+		}
+		if _, ok := d.users[user]; !ok {
+			return store.ErrReference
+		}
+		if _, ok := d.users[contact]; !ok {
+			return store.ErrReference
+		}
+		d.contacts[pair{user, contact}] = true
+		return nil
+	})
+}
+
+func (s *contacts) CreateAll(user string, ids []string) ([]string, error) {
+	created := make([]string, 0)
+	err := s.write(func(d *data) error {
+		if _, ok := d.users[user]; !ok && len(ids) > 0 {
+			return store.ErrReference
+		}
+		for _, contact := range ids {
+			if _, ok := d.users[contact]; !ok {
+				return store.ErrReference
+			}
+		}
+		for _, contact := range ids {
+			if !d.contacts[pair{user, contact}] {
+				d.contacts[pair{user, contact}] = true
+				created = append(created, contact)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *contacts) Delete(user string, contact string) error {
+	return s.write(func(d *data) error {
+		if !d.contacts[pair{user, contact}] {
+			return store.ErrNotFound
+		}
+		delete(d.contacts, pair{user, contact})
+		return nil
+	})
+}
+
+func (s *contacts) Get(user string, contact string) (store.User, error) {
+	result := store.User{}
+	err := s.read(func(d *data) error {
+		if !d.contacts[pair{user, contact}] {
+			return store.ErrNotFound
+		}
+		result = d.users[contact].User
+		return nil
+	})
+	return result, err
+}
+
+func (s *contacts) Exists(user string, contact string) (bool, error) {
+	exists := false
+	s.read(func(d *data) error {
+		exists = d.contacts[pair{user, contact}]
+		return nil
+	})
+	return exists, nil
+}
+
+func (s *contacts) List(user string, after *store.User, limit int) ([]store.User, error) {
+	result := make([]store.User, 0)
+	s.read(func(d *data) error {
+		for key := range d.contacts {
+			if key[0] == user {
+				result = append(result, d.users[key[1]].User)
+			}
+		}
+		return nil
+	})
+	return page(result, after, limit), nil
+}
+
+// namesBefore reports whether a is listed before b, by name and then ID
+func namesBefore(a store.User, b store.User) bool {
+	if a.FirstName != b.FirstName {
+		return a.FirstName < b.FirstName
+	}
+	if a.LastName != b.LastName {
+		return a.LastName < b.LastName
+	}
+	return a.ID < b.ID
+}
+
+// page sorts users by name, returning at most limit of them after the user
+// after if not nil
+func page(users []store.User, after *store.User, limit int) []store.User {
+	result := make([]store.User, 0, len(users))
+	for _, u := range users {
+		if after == nil || namesBefore(*after, u) {
+			result = append(result, u)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return namesBefore(result[i], result[j]) })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
@@ -0,0 +1,783 @@
+package memory
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+
+	"backend/core/store"
+)
+
+type conversations struct {
+	access
+}
+
+// view is conversation as seen by its member m
+func view(c conversation, m member) store.Conversation {
+	result := c.Conversation
+	result.Pinned = m.Pinned
+	result.Role = m.Role
+	result.LastHeard = m.lastHeard
+	result.Archived = m.archived
+	result.MutedUntil = m.mutedUntil
+	result.Notifications = m.notifications
+	return result
+}
+
+// touch marks conversation as active, like the touch_conversation triggers
+func (d *data) touch(id string) {
+	if c, ok := d.conversations[id]; ok {
+		c.ActiveAt = now()
+		d.conversations[id] = c
+	}
+}
+
+// owner returns the owner of conversation, if it has one
+func (d *data) owner(conversation string) (string, bool) {
+	for key, m := range d.members {
+		if key[1] == conversation && m.Role == store.RoleOwner {
+			return key[0], true
+		}
+	}
+	return "", false
+}
+
+// addMember checks and inserts m, like an INSERT into member
+func (d *data) addMember(m store.Member) error {
+	if _, ok := d.members[pair{m.User, m.Conversation}]; ok {
+		return store.ErrConflict
+	}
+	if _, ok := d.owner(m.Conversation); ok && m.Role == store.RoleOwner {
+		return store.ErrConflict
+	}
+	if _, ok := d.users[m.User]; !ok {
+		return store.ErrReference
+	}
+	if _, ok := d.conversations[m.Conversation]; !ok {
+		return store.ErrReference
+	}
+
+	d.members[pair{m.User, m.Conversation}] = member{
+		Member:        m,
+		notifications: store.NotifyAll,
+	}
+	d.touch(m.Conversation)
+	return nil
+}
+
+func (s *conversations) Create(c *store.Conversation, owner string) error {
+	return s.write(func(d *data) error {
+		if _, ok := d.conversations[c.ID]; ok {
+			return store.ErrConflict
+		}
+		if _, ok := d.users[owner]; !ok {
+			return store.ErrReference
+		}
+
+		c.ActiveAt = now()
+		d.conversations[c.ID] = conversation{
+			Conversation: store.Conversation{
+				ID:       c.ID,
+				Title:    c.Title,
+				DM:       c.DM,
+				Picture:  c.Picture,
+				ActiveAt: c.ActiveAt,
+			},
+		}
+		return d.addMember(store.Member{
+			User:         owner,
+			Conversation: c.ID,
+			Role:         store.RoleOwner,
+		})
+	})
+}
+
+func (s *conversations) Update(id string, title null.String, picture null.String) error {
+	return s.write(func(d *data) error {
+		c, ok := d.conversations[id]
+		if !ok {
+			return store.ErrNotFound
+		}
+		c.Title = title
+		c.Picture = picture
+		c.ActiveAt = now()
+		d.conversations[id] = c
+		return nil
+	})
+}
+
+func (s *conversations) Trash(id string, user string) error {
+	return s.write(func(d *data) error {
+		c, ok := d.conversations[id]
+		if !ok || c.deleted() {
+			return store.ErrNotFound
+		}
+		if _, ok := d.users[user]; !ok {
+			return store.ErrReference
+		}
+		c.deletedAt = now()
+		c.deletedBy = user
+		d.conversations[id] = c
+		return nil
+	})
+}
+
+func (s *conversations) Restore(id string, retention time.Duration) (store.Conversation, error) {
+	result := store.Conversation{}
+	err := s.write(func(d *data) error {
+		c, ok := d.conversations[id]
+		if !ok || !c.deletedAt.After(now().Add(-retention)) {
+			return store.ErrNotFound
+		}
+		c.deletedAt = time.Time{}
+		c.deletedBy = ""
+		d.conversations[id] = c
+		result = c.Conversation
+		return nil
+	})
+	return result, err
+}
+
+func (s *conversations) Delete(id string) error {
+	return s.write(func(d *data) error {
+		if _, ok := d.conversations[id]; !ok {
+			return store.ErrNotFound
+		}
+		for key := range d.members {
+			if key[1] == id {
+				return store.ErrReference
+			}
+		}
+
+		d.deleteConversation(id)
+		return nil
+	})
+}
+
+// deleteConversation removes conversation, and what goes with it like ON
+// DELETE CASCADE: its DM, invites and links
+func (d *data) deleteConversation(id string) {
+	delete(d.conversations, id)
+	for key, conversation := range d.dms {
+		if conversation == id {
+			delete(d.dms, key)
+		}
+	}
+	for key := range d.invites {
+		if key[0] == id {
+			delete(d.invites, key)
+		}
+	}
+	for token, link := range d.links {
+		if link.Conversation == id {
+			delete(d.links, token)
+		}
+	}
+}
+
+func (s *conversations) Lock(id string) error {
+	// Transactions hold every lock already
+	return s.read(func(d *data) error {
+		if _, ok := d.conversations[id]; !ok {
+			return store.ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *conversations) DM(id string, user string, other string) (string, bool, error) {
+	// Pairs are stored in order
+	key := pair{user, other}
+	if key[0] > key[1] {
+		key[0], key[1] = key[1], key[0]
+	}
+
+	result, created := "", false
+	err := s.write(func(d *data) error {
+		if conversation, ok := d.dms[key]; ok {
+			result = conversation
+			return nil
+		}
+
+		if key[0] == key[1] {
+			return errors.New("memory: a DM is between two users")
+		}
+		if _, ok := d.conversations[id]; ok {
+			return store.ErrConflict
+		}
+		for _, u := range key {
+			if _, ok := d.users[u]; !ok {
+				return store.ErrReference
+			}
+		}
+		d.conversations[id] = conversation{
+			Conversation: store.Conversation{
+				ID:       id,
+				DM:       true,
+				ActiveAt: now(),
+			},
+		}
+		d.dms[key] = id
+		result, created = id, true
+		return nil
+	})
+	return result, created, err
+}
+
+func (s *conversations) Get(user string, id string) (store.Conversation, error) {
+	result := store.Conversation{}
+	err := s.read(func(d *data) error {
+		m, ok := d.members[pair{user, id}]
+		c := d.conversations[id]
+		if !ok || c.deleted() {
+			return store.ErrNotFound
+		}
+		result = view(c, m)
+		return nil
+	})
+	return result, err
+}
+
+// of returns the conversations of user, as seen by them, that aren't deleted
+func (d *data) of(user string) []store.Conversation {
+	result := make([]store.Conversation, 0)
+	for key, m := range d.members {
+		if c := d.conversations[key[1]]; key[0] == user && !c.deleted() {
+			result = append(result, view(c, m))
+		}
+	}
+	return result
+}
+
+// listedBefore reports whether a is listed before b: pinned first, then the
+// most recently active
+func listedBefore(a store.Conversation, b store.Conversation) bool {
+	if a.Pinned != b.Pinned {
+		return a.Pinned
+	}
+	if !a.ActiveAt.Equal(b.ActiveAt) {
+		return a.ActiveAt.After(b.ActiveAt)
+	}
+	return a.ID > b.ID
+}
+
+func (s *conversations) List(user string, after *store.Conversation, limit int) ([]store.Conversation, error) {
+	result := make([]store.Conversation, 0)
+	s.read(func(d *data) error {
+		for _, c := range d.of(user) {
+			if after == nil || listedBefore(*after, c) {
+				result = append(result, c)
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(result, func(i, j int) bool { return listedBefore(result[i], result[j]) })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (s *conversations) ByMembers(user string, members []string, superset bool) ([]store.Conversation, error) {
+	wanted := make(map[string]bool)
+	for _, m := range members {
+		wanted[m] = true
+	}
+
+	result := make([]store.Conversation, 0)
+	s.read(func(d *data) error {
+		for _, c := range d.of(user) {
+			found, total := 0, 0
+			for key := range d.members {
+				if key[1] == c.ID {
+					total++
+					if wanted[key[0]] {
+						found++
+					}
+				}
+			}
+			if found == len(members) && (superset || total == len(members)) {
+				result = append(result, c)
+			}
+		}
+		return nil
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (s *conversations) Shared(user string, other string) ([]store.Conversation, error) {
+	result := make([]store.Conversation, 0)
+	s.read(func(d *data) error {
+		for _, c := range d.of(user) {
+			if _, ok := d.members[pair{other, c.ID}]; ok {
+				result = append(result, c)
+			}
+		}
+		return nil
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (s *conversations) IDs(user string) ([]string, error) {
+	result := make([]string, 0)
+	s.read(func(d *data) error {
+		for key := range d.members {
+			if key[0] == user {
+				result = append(result, key[1])
+			}
+		}
+		return nil
+	})
+	return result, nil
+}
+
+func (s *conversations) Role(user string, id string) (string, error) {
+	role := ""
+	err := s.read(func(d *data) error {
+		m, ok := d.members[pair{user, id}]
+		if !ok || d.conversations[id].deleted() {
+			return store.ErrNotFound
+		}
+		role = m.Role
+		return nil
+	})
+	return role, err
+}
+
+func (s *conversations) Member(user string, id string) (store.Member, error) {
+	result := store.Member{}
+	err := s.read(func(d *data) error {
+		m, ok := d.members[pair{user, id}]
+		if !ok {
+			return store.ErrNotFound
+		}
+		result = m.Member
+		return nil
+	})
+	return result, err
+}
+
+func (s *conversations) Members(user string, id string, after *store.User, limit int) ([]store.User, error) {
+	result := make([]store.User, 0)
+	s.read(func(d *data) error {
+		if _, ok := d.members[pair{user, id}]; !ok || d.conversations[id].deleted() {
+			return nil
+		}
+		for key := range d.members {
+			if key[1] == id && key[0] != user {
+				result = append(result, d.users[key[0]].User)
+			}
+		}
+		return nil
+	})
+	return page(result, after, limit), nil
+}
+
+func (s *conversations) MemberIDs(id string) ([]string, error) {
+	result := make([]string, 0)
+	s.read(func(d *data) error {
+		for key := range d.members {
+			if key[1] == id {
+				result = append(result, key[0])
+			}
+		}
+		return nil
+	})
+	return result, nil
+}
+
+func (s *conversations) NextOwner(id string) (string, error) {
+	next := ""
+	err := s.read(func(d *data) error {
+		candidates := make([]member, 0)
+		for key, m := range d.members {
+			if key[1] == id {
+				candidates = append(candidates, m)
+			}
+		}
+		if len(candidates) < 1 {
+			return store.ErrNotFound
+		}
+
+		// Admins first
+		sort.Slice(candidates, func(i, j int) bool {
+			a, b := candidates[i], candidates[j]
+			if (a.Role == store.RoleAdmin) != (b.Role == store.RoleAdmin) {
+				return a.Role == store.RoleAdmin
+			}
+			return a.User < b.User
+		})
+		next = candidates[0].User
+		return nil
+	})
+	return next, err
+}
+
+func (s *conversations) AddMember(m store.Member) error {
+	return s.write(func(d *data) error {
+		return d.addMember(m)
+	})
+}
+
+func (s *conversations) RemoveMember(user string, id string) (store.Member, error) {
+	result := store.Member{}
+	err := s.write(func(d *data) error {
+		m, ok := d.members[pair{user, id}]
+		if !ok {
+			return store.ErrNotFound
+		}
+		delete(d.members, pair{user, id})
+		d.touch(id)
+		result = m.Member
+		return nil
+	})
+	return result, err
+}
+
+func (s *conversations) SetRole(user string, id string, role string) (store.Member, error) {
+	result := store.Member{}
+	err := s.write(func(d *data) error {
+		m, ok := d.members[pair{user, id}]
+		if !ok {
+			return store.ErrNotFound
+		}
+		if owner, ok := d.owner(id); ok && owner != user && role == store.RoleOwner {
+			return store.ErrConflict
+		}
+		m.Role = role
+		d.members[pair{user, id}] = m
+		result = m.Member
+		return nil
+	})
+	return result, err
+}
+
+func (s *conversations) SetPinned(user string, id string, pinned bool) (store.Member, error) {
+	result := store.Member{}
+	err := s.write(func(d *data) error {
+		m, ok := d.members[pair{user, id}]
+		if !ok {
+			return store.ErrNotFound
+		}
+		m.Pinned = pinned
+		d.members[pair{user, id}] = m
+		result = m.Member
+		return nil
+	})
+	return result, err
+}
+
+func (s *conversations) Invite(invite *store.Invite, ttl time.Duration) error {
+	return s.write(func(d *data) error {
+		c, ok := d.conversations[invite.Conversation]
+		if !ok {
+			return store.ErrReference
+		}
+		for _, u := range []string{invite.User, invite.Inviter} {
+			if _, ok := d.users[u]; !ok {
+				return store.ErrReference
+			}
+		}
+
+		// Renew the pending invite, if there is one
+		key := pair{invite.Conversation, invite.User}
+		if existing, ok := d.invites[key]; ok {
+			invite.ID = existing.ID
+		} else {
+			for _, other := range d.invites {
+				if other.ID == invite.ID {
+					return store.ErrConflict
+				}
+			}
+		}
+		invite.Title = c.Title
+		invite.CreatedAt = now()
+		invite.ExpiresAt = invite.CreatedAt.Add(ttl)
+		d.invites[key] = *invite
+		return nil
+	})
+}
+
+func (s *conversations) LastHeard(user string, id string) (int64, error) {
+	var result int64
+	err := s.read(func(d *data) error {
+		m, ok := d.members[pair{user, id}]
+		if !ok || d.conversations[id].deleted() {
+			return store.ErrNotFound
+		}
+		result = m.lastHeard.Int64
+		return nil
+	})
+	return result, err
+}
+
+func (s *conversations) SetLastHeard(user string, id string, lastHeard int64) error {
+	return s.write(func(d *data) error {
+		m, ok := d.members[pair{user, id}]
+		if !ok || d.conversations[id].deleted() {
+			return store.ErrNotFound
+		}
+		m.lastHeard = null.IntFrom(lastHeard)
+		d.members[pair{user, id}] = m
+		return nil
+	})
+}
+
+func (s *conversations) SetPreferences(user string, id string, changes store.PreferenceChanges) (store.MemberPreferences, error) {
+	result := store.MemberPreferences{}
+	err := s.write(func(d *data) error {
+		m, ok := d.members[pair{user, id}]
+		if !ok || d.conversations[id].deleted() {
+			return store.ErrNotFound
+		}
+		if changes.Archived.Valid {
+			m.archived = changes.Archived.Bool
+		}
+		if changes.Mute {
+			m.mutedUntil = changes.MutedUntil
+			if m.mutedUntil.Valid {
+				m.mutedUntil.Time = m.mutedUntil.Time.Truncate(time.Microsecond)
+			}
+		}
+		if changes.Notifications.Valid {
+			m.notifications = changes.Notifications.String
+		}
+		d.members[pair{user, id}] = m
+		result = store.MemberPreferences{
+			User:          user,
+			Conversation:  id,
+			Archived:      m.archived,
+			MutedUntil:    m.mutedUntil,
+			Notifications: m.notifications,
+		}
+		return nil
+	})
+	return result, err
+}
+
+// pending reports whether invite can still be answered
+func (d *data) pending(invite store.Invite) bool {
+	return invite.ExpiresAt.After(now()) && !d.conversations[invite.Conversation].deleted()
+}
+
+// invitedBefore reports whether a is listed before b, the most recent first
+func invitedBefore(a store.Invite, b store.Invite) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ID > b.ID
+}
+
+// invites returns the pending invites that match, as listed after the invite
+// after if not nil
+func (s *conversations) invites(match func(invite store.Invite) bool, after *store.Invite, limit int) []store.Invite {
+	result := make([]store.Invite, 0)
+	s.read(func(d *data) error {
+		for _, invite := range d.invites {
+			if match(invite) && d.pending(invite) && (after == nil || invitedBefore(*after, invite)) {
+				invite.Title = d.conversations[invite.Conversation].Title
+				result = append(result, invite)
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(result, func(i, j int) bool { return invitedBefore(result[i], result[j]) })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+func (s *conversations) Invites(user string, after *store.Invite, limit int) ([]store.Invite, error) {
+	return s.invites(func(invite store.Invite) bool {
+		return invite.User == user
+	}, after, limit), nil
+}
+
+func (s *conversations) ConversationInvites(id string, after *store.Invite, limit int) ([]store.Invite, error) {
+	return s.invites(func(invite store.Invite) bool {
+		return invite.Conversation == id
+	}, after, limit), nil
+}
+
+func (s *conversations) AnswerInvite(id string, user string) (store.Invite, error) {
+	result := store.Invite{}
+	err := s.write(func(d *data) error {
+		for key, invite := range d.invites {
+			if invite.ID == id && invite.User == user && d.pending(invite) {
+				delete(d.invites, key)
+				invite.Title = d.conversations[invite.Conversation].Title
+				result = invite
+				return nil
+			}
+		}
+		return store.ErrNotFound
+	})
+	return result, err
+}
+
+func (s *conversations) CreateLink(link *store.InviteLink) error {
+	return s.write(func(d *data) error {
+		if _, ok := d.links[link.Token]; ok {
+			return store.ErrConflict
+		}
+		if _, ok := d.conversations[link.Conversation]; !ok {
+			return store.ErrReference
+		}
+		if _, ok := d.users[link.Creator]; !ok {
+			return store.ErrReference
+		}
+		link.CreatedAt = now()
+		if link.ExpiresAt.Valid {
+			link.ExpiresAt.Time = link.ExpiresAt.Time.Truncate(time.Microsecond)
+		}
+		d.links[link.Token] = *link
+		return nil
+	})
+}
+
+func (s *conversations) Link(token string) (store.InviteLink, error) {
+	result := store.InviteLink{}
+	err := s.read(func(d *data) error {
+		link, ok := d.links[token]
+		if !ok || d.conversations[link.Conversation].deleted() {
+			return store.ErrNotFound
+		}
+		result = link
+		return nil
+	})
+	return result, err
+}
+
+// linkedBefore reports whether a is listed before b, the most recent first
+func linkedBefore(a store.InviteLink, b store.InviteLink) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.Token > b.Token
+}
+
+func (s *conversations) Links(id string, after *store.InviteLink, limit int) ([]store.InviteLink, error) {
+	result := make([]store.InviteLink, 0)
+	s.read(func(d *data) error {
+		for _, link := range d.links {
+			if link.Conversation == id && (after == nil || linkedBefore(*after, link)) {
+				result = append(result, link)
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(result, func(i, j int) bool { return linkedBefore(result[i], result[j]) })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (s *conversations) UseLink(token string) error {
+	return s.write(func(d *data) error {
+		link, ok := d.links[token]
+		if !ok || (link.UsesLeft.Valid && link.UsesLeft.Int64 < 1) || (link.ExpiresAt.Valid && !link.ExpiresAt.Time.After(now())) {
+			return store.ErrNotFound
+		}
+		if link.UsesLeft.Valid {
+			link.UsesLeft.Int64 -= 1
+		}
+		d.links[token] = link
+		return nil
+	})
+}
+
+func (s *conversations) RevokeLink(id string, token string) error {
+	return s.write(func(d *data) error {
+		link, ok := d.links[token]
+		if !ok || link.Conversation != id {
+			return store.ErrNotFound
+		}
+		delete(d.links, token)
+		return nil
+	})
+}
+
+func (s *conversations) Expired(retention time.Duration, limit int) ([]store.Trashed, error) {
+	expired := make([]conversation, 0)
+	s.read(func(d *data) error {
+		for _, c := range d.conversations {
+			if c.deleted() && !c.deletedAt.After(now().Add(-retention)) {
+				expired = append(expired, c)
+			}
+		}
+		return nil
+	})
+
+	// Oldest first
+	sort.Slice(expired, func(i, j int) bool {
+		if !expired[i].deletedAt.Equal(expired[j].deletedAt) {
+			return expired[i].deletedAt.Before(expired[j].deletedAt)
+		}
+		return expired[i].ID < expired[j].ID
+	})
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+	result := make([]store.Trashed, len(expired))
+	for i, c := range expired {
+		result[i] = store.Trashed{Conversation: c.ID, By: c.deletedBy}
+	}
+	return result, nil
+}
+
+func (s *conversations) Purge(ids []string) error {
+	return s.write(func(d *data) error {
+		purged := make(map[string]bool)
+		for _, id := range ids {
+			purged[id] = true
+		}
+		for key := range d.members {
+			if purged[key[1]] {
+				delete(d.members, key)
+			}
+		}
+		for id := range purged {
+			d.deleteConversation(id)
+		}
+		return nil
+	})
+}
+
+func (s *conversations) PurgeInvites(limit int) (int, error) {
+	n := 0
+	err := s.write(func(d *data) error {
+		expired := make([]pair, 0)
+		for key, invite := range d.invites {
+			if !invite.ExpiresAt.After(now()) {
+				expired = append(expired, key)
+			}
+		}
+
+		// Oldest first
+		sort.Slice(expired, func(i, j int) bool {
+			a, b := d.invites[expired[i]], d.invites[expired[j]]
+			if !a.ExpiresAt.Equal(b.ExpiresAt) {
+				return a.ExpiresAt.Before(b.ExpiresAt)
+			}
+			return a.ID < b.ID
+		})
+		if len(expired) > limit {
+			expired = expired[:limit]
+		}
+		for _, key := range expired {
+			delete(d.invites, key)
+		}
+		n = len(expired)
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,250 @@
+// Package memory keeps users, contacts and conversations in maps, so
+// backend-core can be run and tested without Postgres. It enforces the same
+// uniqueness and reference constraints as the schema under migrations/.
+//
+// Transactions run one at a time on a copy of everything, which replaces the
+// original once they commit. That is plenty for development and tests, but
+// nothing is persisted.
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+
+	"backend/core/event"
+	"backend/core/store"
+)
+
+var errTxDone = errors.New("memory: transaction has already been committed or rolled back")
+
+type Memory struct {
+	publisher event.Publisher
+
+	writer sync.Mutex   // held by the transaction in progress
+	mu     sync.RWMutex // guards data
+	data   *data
+}
+
+// New returns an empty store. Events enqueued by transactions are published
+// through publisher once they commit, at most once, or dropped if it is nil.
+func New(publisher event.Publisher) *Memory {
+	return &Memory{
+		publisher: publisher,
+		data:      newData(),
+	}
+}
+
+func (m *Memory) Users() store.UserStore {
+	return &users{access{m: m}}
+}
+
+func (m *Memory) Contacts() store.ContactStore {
+	return &contacts{access{m: m}}
+}
+
+func (m *Memory) Conversations() store.ConversationStore {
+	return &conversations{access{m: m}}
+}
+
+func (m *Memory) Begin() (store.Tx, error) {
+	m.writer.Lock()
+	return &tx{access: access{m: m, tx: m.data.clone()}}, nil
+}
+
+// access is how the stores get at the data, either that of m or the copy of
+// a transaction
+type access struct {
+	m  *Memory
+	tx *data // nil outside of transactions
+}
+
+func (a access) read(f func(d *data) error) error {
+	if a.tx != nil {
+		return f(a.tx)
+	}
+	a.m.mu.RLock()
+	defer a.m.mu.RUnlock()
+	return f(a.m.data)
+}
+
+// write runs f on the data of the transaction, or outside of one as a
+// transaction of its own. f should check everything before changing anything.
+func (a access) write(f func(d *data) error) error {
+	if a.tx != nil {
+		return f(a.tx)
+	}
+
+	a.m.writer.Lock()
+	defer a.m.writer.Unlock()
+	d := a.m.data.clone()
+	if err := f(d); err != nil {
+		return err
+	}
+	a.m.mu.Lock()
+	a.m.data = d
+	a.m.mu.Unlock()
+	return nil
+}
+
+type tx struct {
+	access
+	events []pending
+	done   bool
+}
+
+type pending struct {
+	subject string
+	body    []byte
+}
+
+func (t *tx) Users() store.UserStore {
+	return &users{t.access}
+}
+
+func (t *tx) Contacts() store.ContactStore {
+	return &contacts{t.access}
+}
+
+func (t *tx) Conversations() store.ConversationStore {
+	return &conversations{t.access}
+}
+
+func (t *tx) Enqueue(subject string, actor string, payloads ...interface{}) error {
+	if t.done {
+		return errTxDone
+	}
+	if t.m.publisher == nil {
+		return nil
+	}
+
+	// Encoded now, as payloads may change before the commit
+	for _, payload := range payloads {
+		envelope, err := event.New(subject, actor, payload)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(envelope)
+		if err != nil {
+			return err
+		}
+		t.events = append(t.events, pending{subject, b})
+	}
+	return nil
+}
+
+func (t *tx) Commit() error {
+	if t.done {
+		return errTxDone
+	}
+	t.done = true
+
+	t.m.mu.Lock()
+	t.m.data = t.tx
+	t.m.mu.Unlock()
+	t.m.writer.Unlock()
+
+	for _, e := range t.events {
+		if err := t.m.publisher.Publish(e.subject, e.body); err != nil {
+			log.Print(err)
+		}
+	}
+	return nil
+}
+
+func (t *tx) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	t.m.writer.Unlock()
+	return nil
+}
+
+// pair keys relations, in the order of the columns of their table
+type pair [2]string
+
+type user struct {
+	store.User
+	privacy store.Privacy
+}
+
+type conversation struct {
+	store.Conversation // only the columns of conversation
+	deletedAt          time.Time
+	deletedBy          string
+}
+
+func (c conversation) deleted() bool {
+	return !c.deletedAt.IsZero()
+}
+
+type member struct {
+	store.Member
+	lastHeard     null.Int
+	archived      bool
+	mutedUntil    null.Time
+	notifications string
+}
+
+type data struct {
+	users         map[string]user
+	contacts      map[pair]bool               // user, contact
+	conversations map[string]conversation     // by id
+	members       map[pair]member             // user, conversation
+	dms           map[pair]string             // usera, userb to conversation
+	invites       map[pair]store.Invite       // conversation, user
+	links         map[string]store.InviteLink // by token
+	blocks        map[pair]time.Time          // blocker, blocked to when
+}
+
+func newData() *data {
+	return &data{
+		users:         make(map[string]user),
+		contacts:      make(map[pair]bool),
+		conversations: make(map[string]conversation),
+		members:       make(map[pair]member),
+		dms:           make(map[pair]string),
+		invites:       make(map[pair]store.Invite),
+		links:         make(map[string]store.InviteLink),
+		blocks:        make(map[pair]time.Time),
+	}
+}
+
+func (d *data) clone() *data {
+	c := newData()
+	for k, v := range d.users {
+		c.users[k] = v
+	}
+	for k, v := range d.contacts {
+		c.contacts[k] = v
+	}
+	for k, v := range d.conversations {
+		c.conversations[k] = v
+	}
+	for k, v := range d.members {
+		c.members[k] = v
+	}
+	for k, v := range d.dms {
+		c.dms[k] = v
+	}
+	for k, v := range d.invites {
+		c.invites[k] = v
+	}
+	for k, v := range d.links {
+		c.links[k] = v
+	}
+	for k, v := range d.blocks {
+		c.blocks[k] = v
+	}
+	return c
+}
+
+// now is the current time at the precision of Postgres
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
@@ -0,0 +1,36 @@
+// +build unit
+
+package memory
+
+import (
+	"testing"
+
+	"backend/core/store"
+	"backend/core/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return New(nil)
+	})
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float32
+	}{
+		{"alice", "alice", 1},
+		{"alice", "ALICE", 1},
+		{"alice", "alise", 3.0 / 9},
+		{"alice", "bob", 0},
+		{"", "", 0},
+		{"Alice Smith", "smith", 0.5},
+	}
+
+	for _, test := range tests {
+		if got := similarity(test.a, test.b); got != test.want {
+			t.Errorf("similarity(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
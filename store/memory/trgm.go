@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"strings"
+	"unicode"
+)
+
+// trigrams returns the set of trigrams of s the way pg_trgm extracts them:
+// lowercased words of letters and digits, each padded with two spaces in front
+// and one behind
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// similarity is the similarity function of pg_trgm: the trigrams a and b
+// share over the trigrams of either, in single precision like Postgres
+func similarity(a string, b string) float32 {
+	ta, tb := trigrams(a), trigrams(b)
+	shared := 0
+	for trigram := range ta {
+		if tb[trigram] {
+			shared++
+		}
+	}
+	union := len(ta) + len(tb) - shared
+	if union == 0 {
+		return 0
+	}
+	return float32(shared) / float32(union)
+}
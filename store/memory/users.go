@@ -0,0 +1,402 @@
+package memory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+
+	"backend/core/store"
+)
+
+// Privacy of new users, the column defaults
+var defaultPrivacy = store.Privacy{
+	PhoneNumber: store.VisibilityContacts,
+	Bio:         store.VisibilityEveryone,
+	ProfilePic:  store.VisibilityEveryone,
+}
+
+// phoneHash is the discovery hash of a phone number, the way the
+// hash_phone_number trigger computes it
+func phoneHash(phone string) []byte {
+	digits := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '+' {
+			return r
+		}
+		return -1
+	}, phone)
+	sum := sha256.Sum256([]byte(digits))
+	return sum[:]
+}
+
+// Lowest similarity at which the % operator of pg_trgm matches
+const similarityThreshold = 0.3
+
+type users struct {
+	access
+}
+
+func (d *data) byPhone(phone string) (user, bool) {
+	for _, u := range d.users {
+		if u.PhoneNumber == phone {
+			return u, true
+		}
+	}
+	return user{}, false
+}
+
+// usernameTaken reports whether a user other than except has username. NULL
+// usernames don't count.
+func (d *data) usernameTaken(username null.String, except string) bool {
+	if !username.Valid {
+		return false
+	}
+	for _, u := range d.users {
+		if u.ID != except && u.Username.Valid && u.Username.String == username.String {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *users) Register(u *store.User) (store.Privacy, error) {
+	privacy := store.Privacy{}
+	err := s.write(func(d *data) error {
+		existing, ok := d.byPhone(u.PhoneNumber)
+		if !ok {
+			if _, ok := d.users[u.ID]; ok || d.usernameTaken(u.Username, "") {
+				return store.ErrConflict
+			}
+			d.users[u.ID] = user{*u, defaultPrivacy}
+			privacy = defaultPrivacy
+			return nil
+		}
+
+		// Take over the user with the same phone number
+		if d.usernameTaken(u.Username, existing.ID) {
+			return store.ErrConflict
+		}
+		existing.Username = u.Username
+		existing.FirstName = u.FirstName
+		existing.LastName = u.LastName
+		d.users[existing.ID] = existing
+		u.ID = existing.ID
+		privacy = existing.privacy
+		return nil
+	})
+	return privacy, err
+}
+
+func (s *users) Ensure(placeholders []store.User) ([]store.User, error) {
+	result := make([]store.User, 0, len(placeholders))
+	err := s.write(func(d *data) error {
+		for _, placeholder := range placeholders {
+			if _, ok := d.byPhone(placeholder.PhoneNumber); !ok {
+				if _, ok := d.users[placeholder.ID]; ok {
+					return store.ErrConflict
+				}
+			}
+		}
+		for _, placeholder := range placeholders {
+			u, ok := d.byPhone(placeholder.PhoneNumber)
+			if !ok {
+				u = user{store.User{ID: placeholder.ID, PhoneNumber: placeholder.PhoneNumber}, defaultPrivacy}
+				d.users[u.ID] = u
+			}
+			result = append(result, u.User)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *users) Update(u *store.User) (store.Privacy, error) {
+	privacy := store.Privacy{}
+	err := s.write(func(d *data) error {
+		existing, ok := d.users[u.ID]
+		if !ok {
+			return store.ErrNotFound
+		}
+		if d.usernameTaken(u.Username, u.ID) {
+			return store.ErrConflict
+		}
+		existing.Username = u.Username
+		existing.Bio = u.Bio
+		existing.ProfilePic = u.ProfilePic
+		existing.FirstName = u.FirstName
+		existing.LastName = u.LastName
+		d.users[u.ID] = existing
+		u.PhoneNumber = existing.PhoneNumber
+		privacy = existing.privacy
+		return nil
+	})
+	return privacy, err
+}
+
+func (s *users) Get(id string) (store.User, error) {
+	result := store.User{}
+	err := s.read(func(d *data) error {
+		u, ok := d.users[id]
+		if !ok {
+			return store.ErrNotFound
+		}
+		result = u.User
+		return nil
+	})
+	return result, err
+}
+
+// blocked reports whether blocker has blocked user
+func (d *data) blocked(user string, blocker string) bool {
+	_, ok := d.blocks[pair{blocker, user}]
+	return ok
+}
+
+func (s *users) ByPhone(phone string, viewer string) (store.User, error) {
+	result := store.User{}
+	err := s.read(func(d *data) error {
+		u, ok := d.byPhone(phone)
+		if !ok || d.blocked(viewer, u.ID) {
+			return store.ErrNotFound
+		}
+		result = u.User
+		return nil
+	})
+	return result, err
+}
+
+func (s *users) ByUsername(username string, viewer string) (store.User, error) {
+	result := store.User{}
+	err := s.read(func(d *data) error {
+		for _, u := range d.users {
+			if u.Username.Valid && u.Username.String == username && !d.blocked(viewer, u.ID) {
+				result = u.User
+				return nil
+			}
+		}
+		return store.ErrNotFound
+	})
+	return result, err
+}
+
+func (s *users) Discover(prefixes [][]byte, viewer string) ([]store.DiscoveredUser, error) {
+	wanted := make(map[string]bool)
+	for _, prefix := range prefixes {
+		wanted[string(prefix)] = true
+	}
+
+	result := make([]store.DiscoveredUser, 0)
+	s.read(func(d *data) error {
+		for _, u := range d.users {
+			hash := phoneHash(u.PhoneNumber)
+			if u.Registered() && u.ID != viewer && wanted[string(hash[:store.PhoneHashPrefixSize])] && !d.blocked(viewer, u.ID) {
+				result = append(result, store.DiscoveredUser{
+					Hash: hex.EncodeToString(hash),
+					User: u.User,
+				})
+			}
+		}
+		return nil
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].User.ID < result[j].User.ID })
+	return result, nil
+}
+
+// ranksBefore reports whether a comes before b in search results
+func ranksBefore(a store.SearchResult, b store.SearchResult) bool {
+	if a.Contact != b.Contact {
+		return a.Contact
+	}
+	if a.Prefix != b.Prefix {
+		return a.Prefix
+	}
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.ID > b.ID
+}
+
+func (s *users) Search(viewer string, query string, after *store.SearchResult, limit int) ([]store.SearchResult, error) {
+	prefix := strings.ToLower(query)
+	hasPrefix := func(field string) bool {
+		return strings.HasPrefix(strings.ToLower(field), prefix)
+	}
+
+	results := make([]store.SearchResult, 0)
+	s.read(func(d *data) error {
+		for _, u := range d.users {
+			if u.ID == viewer || !u.Registered() || d.blocked(viewer, u.ID) {
+				continue
+			}
+
+			byUsername := similarity(u.Username.String, query)
+			byName := similarity(u.FirstName+" "+u.LastName, query)
+			result := store.SearchResult{
+				User:    u.User,
+				Contact: d.contacts[pair{viewer, u.ID}],
+				Prefix:  (u.Username.Valid && hasPrefix(u.Username.String)) || hasPrefix(u.FirstName) || hasPrefix(u.LastName),
+				Score:   float64(byUsername),
+			}
+			if byName > byUsername {
+				result.Score = float64(byName)
+			}
+			similar := (u.Username.Valid && byUsername >= similarityThreshold) || byName >= similarityThreshold
+			if !result.Prefix && !similar {
+				continue
+			}
+			if after != nil && !ranksBefore(*after, result) {
+				continue
+			}
+
+			// Only contacts see the phone number
+			if !result.Contact {
+				result.PhoneNumber = ""
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+
+	sort.Slice(results, func(i, j int) bool { return ranksBefore(results[i], results[j]) })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (s *users) Relations(viewer string, ids []string) (map[string]store.Relation, error) {
+	relations := make(map[string]store.Relation)
+	s.read(func(d *data) error {
+		for _, id := range ids {
+			u, ok := d.users[id]
+			if !ok {
+				continue
+			}
+			relations[id] = store.Relation{
+				Privacy: u.privacy,
+				Contact: d.contacts[pair{id, viewer}],
+				Saved:   d.contacts[pair{viewer, id}],
+			}
+		}
+		return nil
+	})
+	return relations, nil
+}
+
+func (s *users) Privacy(id string) (store.Privacy, error) {
+	result := store.Privacy{}
+	err := s.read(func(d *data) error {
+		u, ok := d.users[id]
+		if !ok {
+			return store.ErrNotFound
+		}
+		result = u.privacy
+		return nil
+	})
+	return result, err
+}
+
+func (s *users) SetPrivacy(id string, privacy store.Privacy) (store.User, error) {
+	result := store.User{}
+	err := s.write(func(d *data) error {
+		u, ok := d.users[id]
+		if !ok {
+			return store.ErrNotFound
+		}
+		u.privacy = privacy
+		d.users[id] = u
+		result = u.User
+		return nil
+	})
+	return result, err
+}
+
+func (s *users) Block(user string, blocked string) error {
+	return s.write(func(d *data) error {
+		if _, ok := d.users[blocked]; !ok {
+			return store.ErrNotFound
+		}
+		if _, ok := d.users[user]; !ok {
+			return store.ErrReference
+		}
+		if user == blocked {
+			return errors.New("memory: users can't block themselves")
+		}
+		if _, ok := d.blocks[pair{user, blocked}]; !ok {
+			d.blocks[pair{user, blocked}] = now()
+		}
+		return nil
+	})
+}
+
+func (s *users) Unblock(user string, blocked string) error {
+	return s.write(func(d *data) error {
+		if _, ok := d.blocks[pair{user, blocked}]; !ok {
+			return store.ErrNotFound
+		}
+		delete(d.blocks, pair{user, blocked})
+		return nil
+	})
+}
+
+func (s *users) Blocked(user string) ([]store.User, error) {
+	type block struct {
+		user store.User
+		at   time.Time
+	}
+	blocks := make([]block, 0)
+	s.read(func(d *data) error {
+		for key, at := range d.blocks {
+			if key[0] == user {
+				blocks = append(blocks, block{d.users[key[1]].User, at})
+			}
+		}
+		return nil
+	})
+
+	// Most recently blocked first
+	sort.Slice(blocks, func(i, j int) bool {
+		if !blocks[i].at.Equal(blocks[j].at) {
+			return blocks[i].at.After(blocks[j].at)
+		}
+		return blocks[i].user.ID < blocks[j].user.ID
+	})
+	result := make([]store.User, len(blocks))
+	for i, b := range blocks {
+		result[i] = b.user
+	}
+	return result, nil
+}
+
+func (s *users) Blockers(user string, ids []string) (map[string]bool, error) {
+	blockers := make(map[string]bool)
+	s.read(func(d *data) error {
+		for _, id := range ids {
+			if d.blocked(user, id) {
+				blockers[id] = true
+			}
+		}
+		return nil
+	})
+	return blockers, nil
+}
+
+func (s *users) BlockerIDs(user string) ([]string, error) {
+	result := make([]string, 0)
+	s.read(func(d *data) error {
+		for key := range d.blocks {
+			if key[1] == user {
+				result = append(result, key[0])
+			}
+		}
+		return nil
+	})
+	return result, nil
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// Enqueuer stores events as part of a Postgres transaction, like the outbox
+type Enqueuer interface {
+	Enqueue(tx *sql.Tx, subject string, actor string, payloads ...interface{}) error
+}
+
+// Postgres keeps everything in the schema under migrations/
+type Postgres struct {
+	db     *sql.DB
+	outbox Enqueuer
+}
+
+func NewPostgres(db *sql.DB, outbox Enqueuer) *Postgres {
+	return &Postgres{
+		db:     db,
+		outbox: outbox,
+	}
+}
+
+func (p *Postgres) Users() UserStore {
+	return &pgUsers{p.db}
+}
+
+func (p *Postgres) Contacts() ContactStore {
+	return &pgContacts{p.db}
+}
+
+func (p *Postgres) Conversations() ConversationStore {
+	return &pgConversations{p.db}
+}
+
+func (p *Postgres) Begin() (Tx, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &pgTx{tx, p.outbox}, nil
+}
+
+type pgTx struct {
+	tx     *sql.Tx
+	outbox Enqueuer
+}
+
+func (t *pgTx) Users() UserStore {
+	return &pgUsers{t.tx}
+}
+
+func (t *pgTx) Contacts() ContactStore {
+	return &pgContacts{t.tx}
+}
+
+func (t *pgTx) Conversations() ConversationStore {
+	return &pgConversations{t.tx}
+}
+
+func (t *pgTx) Enqueue(subject string, actor string, payloads ...interface{}) error {
+	return t.outbox.Enqueue(t.tx, subject, actor, payloads...)
+}
+
+func (t *pgTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *pgTx) Rollback() error {
+	err := t.tx.Rollback()
+	if err == sql.ErrTxDone {
+		return nil
+	}
+	return err
+}
+
+// queryer is satisfied by *sql.DB and *sql.Tx
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Columns of a user, as read by scanUser
+const userColumns = `"user".id, "user".username, "user".bio, "user".profile_pic, "user".first_name, "user".last_name, "user".phone_number`
+
+// scanUser reads userColumns into user, followed by extra
+func scanUser(row scanner, user *User, extra ...interface{}) error {
+	dest := []interface{}{&user.ID, &user.Username, &user.Bio, &user.ProfilePic, &user.FirstName, &user.LastName, &user.PhoneNumber}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// scanUsers reads every row of userColumns, closing rows
+func scanUsers(rows *sql.Rows) ([]User, error) {
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		user := User{}
+		if err := scanUser(rows, &user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Columns of a conversation as seen by a member, as read by scanConversation
+const conversationColumns = `"conversation".id, "conversation".title, "conversation".dm, "conversation".picture, member.pinned, member.role, member.lastheard, member.archived, member.muted_until, member.notifications, "conversation".active_at`
+
+// scanConversation reads conversationColumns into conversation
+func scanConversation(row scanner, conversation *Conversation) error {
+	return row.Scan(&conversation.ID, &conversation.Title, &conversation.DM, &conversation.Picture, &conversation.Pinned, &conversation.Role, &conversation.LastHeard, &conversation.Archived, &conversation.MutedUntil, &conversation.Notifications, &conversation.ActiveAt)
+}
+
+// scanConversations reads every row of conversationColumns, closing rows
+func scanConversations(rows *sql.Rows) ([]Conversation, error) {
+	defer rows.Close()
+
+	conversations := make([]Conversation, 0)
+	for rows.Next() {
+		conversation := Conversation{}
+		if err := scanConversation(rows, &conversation); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, rows.Err()
+}
+
+// scanIDs reads every row of a single column of IDs, closing rows
+func scanIDs(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// translate turns the errors of database/sql and Postgres into those of the
+// store
+func translate(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code {
+		case "23505": // unique_violation
+			return ErrConflict
+		case "23503": // foreign_key_violation
+			return ErrReference
+		}
+	}
+	return err
+}
+
+// affected is ErrNotFound if result changed no rows
+func affected(result sql.Result, err error) error {
+	if err != nil {
+		return translate(err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"github.com/lib/pq"
+)
+
+type pgContacts struct {
+	q queryer
+}
+
+func (s *pgContacts) Create(user string, contact string) error {
+	_, err := s.q.Exec(`
+		INSERT INTO contact ("user", contact) VALUES ($1, $2)
+	`, user, contact)
+	return translate(err)
+}
+
+func (s *pgContacts) CreateAll(user string, contacts []string) ([]string, error) {
+	rows, err := s.q.Query(`
+		INSERT INTO contact ("user", contact)
+			SELECT $1, contact FROM unnest($2::BYTEA[]) AS c(contact)
+			ON CONFLICT DO NOTHING
+			RETURNING contact
+	`, user, pq.Array(contacts))
+	if err != nil {
+		return nil, translate(err)
+	}
+	return scanIDs(rows)
+}
+
+func (s *pgContacts) Delete(user string, contact string) error {
+	return affected(s.q.Exec(`
+		DELETE FROM contact WHERE "user" = $1 AND contact = $2
+	`, user, contact))
+}
+
+func (s *pgContacts) Get(user string, contact string) (User, error) {
+	result := User{}
+	err := scanUser(s.q.QueryRow(`
+		SELECT `+userColumns+` FROM "user"
+		INNER JOIN contact
+		ON contact.contact = "user".id AND contact.user = $1
+		WHERE contact.contact = $2
+	`, user, contact), &result)
+	return result, translate(err)
+}
+
+func (s *pgContacts) Exists(user string, contact string) (bool, error) {
+	var exists bool
+	err := s.q.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM contact WHERE "user" = $1 AND contact = $2)
+	`, user, contact).Scan(&exists)
+	return exists, err
+}
+
+func (s *pgContacts) List(user string, after *User, limit int) ([]User, error) {
+	cursor := User{}
+	if after != nil {
+		cursor = *after
+	}
+
+	rows, err := s.q.Query(`
+		SELECT `+userColumns+` FROM "user"
+		INNER JOIN contact
+		ON contact.contact = "user".id AND contact.user = $1
+		WHERE $2::BOOLEAN OR (first_name, last_name, id) > ($3::VARCHAR, $4::VARCHAR, $5::BYTEA)
+		ORDER BY first_name, last_name, id
+		LIMIT $6
+	`, user, after == nil, cursor.FirstName, cursor.LastName, cursor.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows)
+}
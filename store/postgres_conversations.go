@@ -0,0 +1,537 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"gopkg.in/guregu/null.v3"
+)
+
+type pgConversations struct {
+	q queryer
+}
+
+func (s *pgConversations) Create(conversation *Conversation, owner string) error {
+	err := s.q.QueryRow(`
+		INSERT INTO "conversation" (id, title, picture, dm) VALUES ($1, $2, $3, $4)
+			RETURNING active_at
+	`, conversation.ID, conversation.Title, conversation.Picture, conversation.DM).Scan(&conversation.ActiveAt)
+	if err != nil {
+		return translate(err)
+	}
+	_, err = s.q.Exec(`
+		INSERT INTO member ("user", "conversation", "role") VALUES ($1, $2, $3)
+	`, owner, conversation.ID, RoleOwner)
+	return translate(err)
+}
+
+func (s *pgConversations) Update(conversation string, title null.String, picture null.String) error {
+	return affected(s.q.Exec(`
+		UPDATE "conversation"
+		SET title = $2, picture = $3
+		WHERE id = $1
+	`, conversation, title, picture))
+}
+
+func (s *pgConversations) Trash(conversation string, user string) error {
+	return affected(s.q.Exec(`
+		UPDATE "conversation" SET deleted_at = NOW(), deleted_by = $2 WHERE id = $1 AND deleted_at IS NULL
+	`, conversation, user))
+}
+
+func (s *pgConversations) Restore(conversation string, retention time.Duration) (Conversation, error) {
+	result := Conversation{}
+	err := s.q.QueryRow(`
+		UPDATE "conversation" SET deleted_at = NULL, deleted_by = NULL
+		WHERE id = $1 AND deleted_at > NOW() - $2::FLOAT8 * INTERVAL '1 second'
+		RETURNING id, title, dm, picture, active_at
+	`, conversation, retention.Seconds()).Scan(&result.ID, &result.Title, &result.DM, &result.Picture, &result.ActiveAt)
+	return result, translate(err)
+}
+
+func (s *pgConversations) Delete(conversation string) error {
+	return affected(s.q.Exec(`
+		DELETE FROM "conversation" WHERE id = $1
+	`, conversation))
+}
+
+func (s *pgConversations) Lock(conversation string) error {
+	var id string
+	err := s.q.QueryRow(`
+		SELECT id FROM "conversation" WHERE id = $1 FOR UPDATE
+	`, conversation).Scan(&id)
+	return translate(err)
+}
+
+func (s *pgConversations) DM(id string, user string, other string) (string, bool, error) {
+	// Pairs are stored in order
+	usera, userb := user, other
+	if usera > userb {
+		usera, userb = userb, usera
+	}
+
+	// Get
+	var conversation string
+	err := s.q.QueryRow(`
+		SELECT "conversation" FROM dm WHERE usera = $1 AND userb = $2
+	`, usera, userb).Scan(&conversation)
+	if err != sql.ErrNoRows {
+		return conversation, false, translate(err)
+	}
+
+	// Or create, unless a concurrent request beats us to it
+	_, err = s.q.Exec(`
+		INSERT INTO "conversation" (id, dm) VALUES ($1, TRUE)
+	`, id)
+	if err != nil {
+		return "", false, translate(err)
+	}
+	err = s.q.QueryRow(`
+		INSERT INTO dm ("conversation", usera, userb) VALUES ($1, $2, $3)
+		ON CONFLICT (usera, userb) DO NOTHING
+		RETURNING "conversation"
+	`, id, usera, userb).Scan(&conversation)
+	if err != sql.ErrNoRows {
+		return conversation, err == nil, translate(err)
+	}
+	_, err = s.q.Exec(`
+		DELETE FROM "conversation" WHERE id = $1
+	`, id)
+	if err != nil {
+		return "", false, err
+	}
+	err = s.q.QueryRow(`
+		SELECT "conversation" FROM dm WHERE usera = $1 AND userb = $2
+	`, usera, userb).Scan(&conversation)
+	return conversation, false, translate(err)
+}
+
+func (s *pgConversations) Get(user string, conversation string) (Conversation, error) {
+	result := Conversation{}
+	err := scanConversation(s.q.QueryRow(`
+		SELECT `+conversationColumns+`
+		FROM "conversation", member
+		WHERE member.conversation = "conversation".id AND member.user = $1 AND member.conversation = $2 AND "conversation".deleted_at IS NULL
+	`, user, conversation), &result)
+	return result, translate(err)
+}
+
+func (s *pgConversations) List(user string, after *Conversation, limit int) ([]Conversation, error) {
+	cursor := Conversation{}
+	if after != nil {
+		cursor = *after
+	}
+
+	rows, err := s.q.Query(`
+		SELECT `+conversationColumns+`
+		FROM "conversation", member
+		WHERE member.conversation = "conversation".id AND member.user = $1 AND "conversation".deleted_at IS NULL
+			AND ($2::BOOLEAN OR (member.pinned, "conversation".active_at, "conversation".id) < ($3::BOOLEAN, $4::TIMESTAMPTZ, $5::BYTEA))
+		ORDER BY member.pinned DESC, "conversation".active_at DESC, "conversation".id DESC
+		LIMIT $6
+	`, user, after == nil, cursor.Pinned, cursor.ActiveAt, cursor.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanConversations(rows)
+}
+
+func (s *pgConversations) ByMembers(user string, members []string, superset bool) ([]Conversation, error) {
+	rows, err := s.q.Query(`
+		SELECT `+conversationColumns+`
+		FROM member
+		INNER JOIN "conversation" ON "conversation".id = member.conversation
+		INNER JOIN member m ON m.conversation = member.conversation
+		WHERE member.user = $1 AND "conversation".deleted_at IS NULL
+		GROUP BY "conversation".id, member.pinned, member.role, member.lastheard, member.archived, member.muted_until, member.notifications
+		HAVING COUNT(*) FILTER (WHERE m.user = ANY($2::BYTEA[])) = $3
+			AND ($4 OR COUNT(*) = $3)
+		ORDER BY "conversation".id
+	`, user, pq.Array(members), len(members), superset)
+	if err != nil {
+		return nil, err
+	}
+	return scanConversations(rows)
+}
+
+func (s *pgConversations) Shared(user string, other string) ([]Conversation, error) {
+	rows, err := s.q.Query(`
+		SELECT `+conversationColumns+`
+		FROM member
+		INNER JOIN member shared ON shared.conversation = member.conversation AND shared.user = $2
+		INNER JOIN "conversation" ON "conversation".id = member.conversation
+		WHERE member.user = $1 AND "conversation".deleted_at IS NULL
+		ORDER BY "conversation".id
+	`, user, other)
+	if err != nil {
+		return nil, err
+	}
+	return scanConversations(rows)
+}
+
+func (s *pgConversations) IDs(user string) ([]string, error) {
+	rows, err := s.q.Query(`
+		SELECT "conversation" FROM member WHERE "user" = $1
+	`, user)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *pgConversations) Role(user string, conversation string) (string, error) {
+	var role string
+	err := s.q.QueryRow(`
+		SELECT member."role" FROM member, "conversation"
+		WHERE member."conversation" = "conversation".id AND member."user" = $1 AND member."conversation" = $2 AND "conversation".deleted_at IS NULL
+		FOR UPDATE OF member
+	`, user, conversation).Scan(&role)
+	return role, translate(err)
+}
+
+func (s *pgConversations) Member(user string, conversation string) (Member, error) {
+	member := Member{
+		User:         user,
+		Conversation: conversation,
+	}
+	err := s.q.QueryRow(`
+		SELECT pinned, "role" FROM member WHERE "user" = $1 AND "conversation" = $2 FOR UPDATE
+	`, user, conversation).Scan(&member.Pinned, &member.Role)
+	return member, translate(err)
+}
+
+func (s *pgConversations) Members(user string, conversation string, after *User, limit int) ([]User, error) {
+	cursor := User{}
+	if after != nil {
+		cursor = *after
+	}
+
+	rows, err := s.q.Query(`
+		SELECT `+userColumns+` FROM "user"
+		INNER JOIN member m ON "user".id = m.user AND "user".id != $1
+		INNER JOIN conversation ON "conversation".id = m.conversation
+		INNER JOIN member
+		ON member.conversation = "conversation".id AND member.user = $1 AND member.conversation = $2
+		WHERE "conversation".deleted_at IS NULL AND ($3::BOOLEAN OR ("user".first_name, "user".last_name, "user".id) > ($4::VARCHAR, $5::VARCHAR, $6::BYTEA))
+		ORDER BY "user".first_name, "user".last_name, "user".id
+		LIMIT $7
+	`, user, conversation, after == nil, cursor.FirstName, cursor.LastName, cursor.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows)
+}
+
+func (s *pgConversations) MemberIDs(conversation string) ([]string, error) {
+	rows, err := s.q.Query(`
+		SELECT "user" FROM member WHERE "conversation" = $1
+	`, conversation)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *pgConversations) NextOwner(conversation string) (string, error) {
+	var next string
+	err := s.q.QueryRow(`
+		SELECT "user" FROM member WHERE "conversation" = $1
+		ORDER BY "role" = 'admin' DESC, "user"
+		LIMIT 1
+	`, conversation).Scan(&next)
+	return next, translate(err)
+}
+
+func (s *pgConversations) AddMember(member Member) error {
+	// Conflicts are reported rather than raised, which would abort the
+	// transaction
+	err := affected(s.q.Exec(`
+		INSERT INTO member ("user", "conversation", "role", pinned) VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`, member.User, member.Conversation, member.Role, member.Pinned))
+	if err == ErrNotFound {
+		return ErrConflict
+	}
+	return err
+}
+
+func (s *pgConversations) RemoveMember(user string, conversation string) (Member, error) {
+	member := Member{
+		User:         user,
+		Conversation: conversation,
+	}
+	err := s.q.QueryRow(`
+		DELETE FROM member WHERE "user" = $1 AND "conversation" = $2 RETURNING pinned, "role"
+	`, user, conversation).Scan(&member.Pinned, &member.Role)
+	return member, translate(err)
+}
+
+func (s *pgConversations) SetRole(user string, conversation string, role string) (Member, error) {
+	member := Member{
+		User:         user,
+		Conversation: conversation,
+		Role:         role,
+	}
+	err := s.q.QueryRow(`
+		UPDATE member SET "role" = $3 WHERE "user" = $1 AND "conversation" = $2 RETURNING pinned
+	`, user, conversation, role).Scan(&member.Pinned)
+	return member, translate(err)
+}
+
+func (s *pgConversations) SetPinned(user string, conversation string, pinned bool) (Member, error) {
+	member := Member{
+		User:         user,
+		Conversation: conversation,
+		Pinned:       pinned,
+	}
+	err := s.q.QueryRow(`
+		UPDATE member SET pinned = $3 WHERE "user" = $1 AND "conversation" = $2 RETURNING "role"
+	`, user, conversation, pinned).Scan(&member.Role)
+	return member, translate(err)
+}
+
+func (s *pgConversations) Invite(invite *Invite, ttl time.Duration) error {
+	err := s.q.QueryRow(`
+		INSERT INTO invite (id, "conversation", "user", inviter, expires_at)
+			VALUES ($1, $2, $3, $4, NOW() + $5::FLOAT8 * INTERVAL '1 second')
+			ON CONFLICT ("conversation", "user")
+			DO UPDATE SET inviter = EXCLUDED.inviter, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+			RETURNING id, created_at, expires_at, (SELECT title FROM "conversation" WHERE id = $2)
+	`, invite.ID, invite.Conversation, invite.User, invite.Inviter, ttl.Seconds()).Scan(&invite.ID, &invite.CreatedAt, &invite.ExpiresAt, &invite.Title)
+	return translate(err)
+}
+
+func (s *pgConversations) LastHeard(user string, conversation string) (int64, error) {
+	var lastHeard int64
+	err := s.q.QueryRow(`
+		SELECT COALESCE(member.lastheard, 0) FROM member, "conversation"
+		WHERE member."conversation" = "conversation".id AND member."user" = $1 AND member."conversation" = $2 AND "conversation".deleted_at IS NULL
+		FOR UPDATE OF member
+	`, user, conversation).Scan(&lastHeard)
+	return lastHeard, translate(err)
+}
+
+func (s *pgConversations) SetLastHeard(user string, conversation string, lastHeard int64) error {
+	return affected(s.q.Exec(`
+		UPDATE member SET lastheard = $3
+		WHERE "user" = $1 AND "conversation" = $2
+			AND "conversation" IN (SELECT id FROM "conversation" WHERE deleted_at IS NULL)
+	`, user, conversation, lastHeard))
+}
+
+func (s *pgConversations) SetPreferences(user string, conversation string, changes PreferenceChanges) (MemberPreferences, error) {
+	preferences := MemberPreferences{
+		User:         user,
+		Conversation: conversation,
+	}
+	err := s.q.QueryRow(`
+		UPDATE member SET
+			archived = COALESCE($3::BOOLEAN, archived),
+			muted_until = CASE WHEN $4::BOOLEAN THEN $5::TIMESTAMPTZ ELSE muted_until END,
+			notifications = COALESCE($6::VARCHAR, notifications)
+		WHERE "user" = $1 AND "conversation" = $2
+			AND "conversation" IN (SELECT id FROM "conversation" WHERE deleted_at IS NULL)
+		RETURNING archived, muted_until, notifications
+	`, user, conversation, changes.Archived, changes.Mute, changes.MutedUntil, changes.Notifications).Scan(&preferences.Archived, &preferences.MutedUntil, &preferences.Notifications)
+	return preferences, translate(err)
+}
+
+// Columns of a pending invite, as read by scanInvite
+const inviteColumns = `invite.id, invite.conversation, "conversation".title, invite.user, invite.inviter, invite.created_at, invite.expires_at`
+
+// scanInvite reads inviteColumns into invite
+func scanInvite(row scanner, invite *Invite) error {
+	return row.Scan(&invite.ID, &invite.Conversation, &invite.Title, &invite.User, &invite.Inviter, &invite.CreatedAt, &invite.ExpiresAt)
+}
+
+// scanInvites reads every row of inviteColumns, closing rows
+func scanInvites(rows *sql.Rows) ([]Invite, error) {
+	defer rows.Close()
+
+	invites := make([]Invite, 0)
+	for rows.Next() {
+		invite := Invite{}
+		if err := scanInvite(rows, &invite); err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+func (s *pgConversations) Invites(user string, after *Invite, limit int) ([]Invite, error) {
+	cursor := Invite{}
+	if after != nil {
+		cursor = *after
+	}
+
+	rows, err := s.q.Query(`
+		SELECT `+inviteColumns+`
+		FROM invite, "conversation"
+		WHERE invite.conversation = "conversation".id AND invite.user = $1 AND invite.expires_at > NOW() AND "conversation".deleted_at IS NULL
+			AND ($2::BOOLEAN OR (invite.created_at, invite.id) < ($3::TIMESTAMPTZ, $4::BYTEA))
+		ORDER BY invite.created_at DESC, invite.id DESC
+		LIMIT $5
+	`, user, after == nil, cursor.CreatedAt, cursor.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanInvites(rows)
+}
+
+func (s *pgConversations) ConversationInvites(conversation string, after *Invite, limit int) ([]Invite, error) {
+	cursor := Invite{}
+	if after != nil {
+		cursor = *after
+	}
+
+	rows, err := s.q.Query(`
+		SELECT `+inviteColumns+`
+		FROM invite, "conversation"
+		WHERE invite.conversation = "conversation".id AND invite.conversation = $1 AND invite.expires_at > NOW() AND "conversation".deleted_at IS NULL
+			AND ($2::BOOLEAN OR (invite.created_at, invite.id) < ($3::TIMESTAMPTZ, $4::BYTEA))
+		ORDER BY invite.created_at DESC, invite.id DESC
+		LIMIT $5
+	`, conversation, after == nil, cursor.CreatedAt, cursor.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanInvites(rows)
+}
+
+func (s *pgConversations) AnswerInvite(id string, user string) (Invite, error) {
+	invite := Invite{}
+	err := s.q.QueryRow(`
+		DELETE FROM invite
+		WHERE id = $1 AND "user" = $2 AND expires_at > NOW()
+			AND "conversation" IN (SELECT id FROM "conversation" WHERE deleted_at IS NULL)
+		RETURNING id, "conversation", "user", inviter, created_at, expires_at, (SELECT title FROM "conversation" WHERE id = invite.conversation)
+	`, id, user).Scan(&invite.ID, &invite.Conversation, &invite.User, &invite.Inviter, &invite.CreatedAt, &invite.ExpiresAt, &invite.Title)
+	return invite, translate(err)
+}
+
+// Columns of an invite link, as read by scanLink
+const linkColumns = `token, "conversation", creator, uses_left, expires_at, created_at`
+
+// scanLink reads linkColumns into link
+func scanLink(row scanner, link *InviteLink) error {
+	return row.Scan(&link.Token, &link.Conversation, &link.Creator, &link.UsesLeft, &link.ExpiresAt, &link.CreatedAt)
+}
+
+func (s *pgConversations) CreateLink(link *InviteLink) error {
+	err := s.q.QueryRow(`
+		INSERT INTO invite_link (token, "conversation", creator, uses_left, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING created_at
+	`, link.Token, link.Conversation, link.Creator, link.UsesLeft, link.ExpiresAt).Scan(&link.CreatedAt)
+	return translate(err)
+}
+
+func (s *pgConversations) Link(token string) (InviteLink, error) {
+	link := InviteLink{}
+	err := scanLink(s.q.QueryRow(`
+		SELECT `+linkColumns+` FROM invite_link
+		WHERE token = $1 AND "conversation" IN (SELECT id FROM "conversation" WHERE deleted_at IS NULL)
+	`, token), &link)
+	return link, translate(err)
+}
+
+func (s *pgConversations) Links(conversation string, after *InviteLink, limit int) ([]InviteLink, error) {
+	cursor := InviteLink{}
+	if after != nil {
+		cursor = *after
+	}
+
+	rows, err := s.q.Query(`
+		SELECT `+linkColumns+` FROM invite_link
+		WHERE "conversation" = $1
+			AND ($2::BOOLEAN OR (created_at, token) < ($3::TIMESTAMPTZ, $4::BYTEA))
+		ORDER BY created_at DESC, token DESC
+		LIMIT $5
+	`, conversation, after == nil, cursor.CreatedAt, cursor.Token, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]InviteLink, 0)
+	for rows.Next() {
+		link := InviteLink{}
+		if err := scanLink(rows, &link); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *pgConversations) UseLink(token string) error {
+	// The row lock makes concurrent uses take turns, so the last one is only
+	// ever handed out once
+	return affected(s.q.Exec(`
+		UPDATE invite_link SET uses_left = uses_left - 1
+		WHERE token = $1 AND (uses_left IS NULL OR uses_left > 0) AND (expires_at IS NULL OR expires_at > NOW())
+	`, token))
+}
+
+func (s *pgConversations) RevokeLink(conversation string, token string) error {
+	return affected(s.q.Exec(`
+		DELETE FROM invite_link WHERE token = $1 AND "conversation" = $2
+	`, token, conversation))
+}
+
+func (s *pgConversations) Expired(retention time.Duration, limit int) ([]Trashed, error) {
+	rows, err := s.q.Query(`
+		SELECT id, COALESCE(deleted_by, '') FROM "conversation"
+		WHERE deleted_at <= NOW() - $1::FLOAT8 * INTERVAL '1 second'
+		ORDER BY deleted_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, retention.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	expired := make([]Trashed, 0)
+	for rows.Next() {
+		trashed := Trashed{}
+		if err := rows.Scan(&trashed.Conversation, &trashed.By); err != nil {
+			return nil, err
+		}
+		expired = append(expired, trashed)
+	}
+	return expired, rows.Err()
+}
+
+func (s *pgConversations) Purge(conversations []string) error {
+	// Invites and links go along
+	_, err := s.q.Exec(`
+		DELETE FROM member WHERE "conversation" = ANY($1::BYTEA[])
+	`, pq.Array(conversations))
+	if err != nil {
+		return err
+	}
+	_, err = s.q.Exec(`
+		DELETE FROM "conversation" WHERE id = ANY($1::BYTEA[])
+	`, pq.Array(conversations))
+	return translate(err)
+}
+
+func (s *pgConversations) PurgeInvites(limit int) (int, error) {
+	result, err := s.q.Exec(`
+		DELETE FROM invite WHERE id IN (
+			SELECT id FROM invite
+			WHERE expires_at <= NOW()
+			ORDER BY expires_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+	`, limit)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,43 @@
+// +build integration
+
+package store_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+
+	"backend/core/migrations"
+	"backend/core/store"
+	"backend/core/store/storetest"
+)
+
+// discard drops events, there is nothing to relay them to
+type discard struct{}
+
+func (discard) Enqueue(tx *sql.Tx, subject string, actor string, payloads ...interface{}) error {
+	return nil
+}
+
+func TestPostgresConformance(t *testing.T) {
+	godotenv.Load("../.env")
+	db, err := sql.Open("postgres", os.Getenv("POSTGRES"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := migrations.Up(db); err != nil {
+		t.Fatal(err)
+	}
+
+	storetest.Run(t, func(t *testing.T) store.Store {
+		_, err := db.Exec(`TRUNCATE "user", "conversation", member, contact, pinned_conversation, dm, block, invite, invite_link CASCADE`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store.NewPostgres(db, discard{})
+	})
+}
@@ -0,0 +1,270 @@
+package store
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+type pgUsers struct {
+	q queryer
+}
+
+func (s *pgUsers) Register(user *User) (Privacy, error) {
+	privacy := Privacy{}
+	err := s.q.QueryRow(`
+		INSERT INTO "user" (id, username, bio, profile_pic, first_name, last_name, phone_number)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT(phone_number)
+			DO UPDATE SET phone_number=EXCLUDED.phone_number, username=$2, first_name=$5, last_name=$6
+			RETURNING id, phone_number_visibility, bio_visibility, profile_pic_visibility
+	`, user.ID, user.Username, user.Bio, user.ProfilePic, user.FirstName, user.LastName, user.PhoneNumber).Scan(&user.ID, &privacy.PhoneNumber, &privacy.Bio, &privacy.ProfilePic)
+	return privacy, translate(err)
+}
+
+func (s *pgUsers) Ensure(placeholders []User) ([]User, error) {
+	ids := make([]string, len(placeholders))
+	phones := make([]string, len(placeholders))
+	for i, placeholder := range placeholders {
+		ids[i] = placeholder.ID
+		phones[i] = placeholder.PhoneNumber
+	}
+
+	rows, err := s.q.Query(`
+		INSERT INTO "user" (id, username, bio, profile_pic, first_name, last_name, phone_number)
+			SELECT id, NULL, '', '', '', '', phone_number FROM unnest($1::BYTEA[], $2::VARCHAR[]) AS u(id, phone_number)
+			ON CONFLICT(phone_number)
+			DO UPDATE SET phone_number=EXCLUDED.phone_number
+			RETURNING `+userColumns+`
+	`, pq.Array(ids), pq.Array(phones))
+	if err != nil {
+		return nil, translate(err)
+	}
+	return scanUsers(rows)
+}
+
+func (s *pgUsers) Update(user *User) (Privacy, error) {
+	privacy := Privacy{}
+	err := s.q.QueryRow(`
+		UPDATE "user"
+		SET
+		username = $2,
+		bio = $3,
+		profile_pic = $4,
+		first_name = $5,
+		last_name = $6
+		WHERE id = $1
+		RETURNING phone_number, phone_number_visibility, bio_visibility, profile_pic_visibility
+	`, user.ID, user.Username, user.Bio, user.ProfilePic, user.FirstName, user.LastName).Scan(&user.PhoneNumber, &privacy.PhoneNumber, &privacy.Bio, &privacy.ProfilePic)
+	return privacy, translate(err)
+}
+
+func (s *pgUsers) Get(id string) (User, error) {
+	user := User{}
+	err := scanUser(s.q.QueryRow(`
+		SELECT `+userColumns+` FROM "user" WHERE id = $1
+	`, id), &user)
+	return user, translate(err)
+}
+
+func (s *pgUsers) ByPhone(phone string, viewer string) (User, error) {
+	user := User{}
+	err := scanUser(s.q.QueryRow(`
+		SELECT `+userColumns+` FROM "user"
+		WHERE phone_number = $1 AND NOT EXISTS (SELECT 1 FROM block WHERE blocker = "user".id AND blocked = $2)
+	`, phone, viewer), &user)
+	return user, translate(err)
+}
+
+func (s *pgUsers) ByUsername(username string, viewer string) (User, error) {
+	user := User{}
+	err := scanUser(s.q.QueryRow(`
+		SELECT `+userColumns+` FROM "user"
+		WHERE username = $1 AND NOT EXISTS (SELECT 1 FROM block WHERE blocker = "user".id AND blocked = $2)
+	`, username, viewer), &user)
+	return user, translate(err)
+}
+
+func (s *pgUsers) Discover(prefixes [][]byte, viewer string) ([]DiscoveredUser, error) {
+	// Placeholders created for contacts aren't registered users. The prefix is
+	// PhoneHashPrefixSize long, as indexed.
+	rows, err := s.q.Query(`
+		SELECT `+userColumns+`, phone_hash FROM "user"
+		WHERE substring(phone_hash FROM 1 FOR 2) = ANY($1::BYTEA[]) AND first_name <> '' AND id <> $2
+			AND NOT EXISTS (SELECT 1 FROM block WHERE blocker = "user".id AND blocked = $2)
+	`, pq.Array(prefixes), viewer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]DiscoveredUser, 0)
+	for rows.Next() {
+		var hash []byte
+		user := User{}
+		if err := scanUser(rows, &user, &hash); err != nil {
+			return nil, err
+		}
+		users = append(users, DiscoveredUser{
+			Hash: hex.EncodeToString(hash),
+			User: user,
+		})
+	}
+	return users, rows.Err()
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *pgUsers) Search(viewer string, query string, after *SearchResult, limit int) ([]SearchResult, error) {
+	cursor := SearchResult{}
+	if after != nil {
+		cursor = *after
+	}
+
+	rows, err := s.q.Query(`
+		SELECT id, username, bio, profile_pic, first_name, last_name, phone_number, is_contact, is_prefix, score FROM (
+			SELECT "user".id, "user".username, "user".bio, "user".profile_pic, "user".first_name, "user".last_name,
+				CASE WHEN contact.contact IS NULL THEN '' ELSE "user".phone_number END AS phone_number,
+				contact.contact IS NOT NULL AS is_contact,
+				("user".username ILIKE $3 OR "user".first_name ILIKE $3 OR "user".last_name ILIKE $3) AS is_prefix,
+				GREATEST(similarity(COALESCE("user".username, ''), $2), similarity("user".first_name || ' ' || "user".last_name, $2))::FLOAT8 AS score
+			FROM "user"
+			LEFT JOIN contact ON contact.contact = "user".id AND contact.user = $1
+			WHERE "user".id <> $1 AND "user".first_name <> ''
+				AND NOT EXISTS (SELECT 1 FROM block WHERE blocker = "user".id AND blocked = $1)
+				AND ("user".username ILIKE $3 OR "user".first_name ILIKE $3 OR "user".last_name ILIKE $3
+					OR "user".username % $2 OR ("user".first_name || ' ' || "user".last_name) % $2)
+		) AS result
+		WHERE $4::BOOLEAN OR (is_contact, is_prefix, score, id) < ($5::BOOLEAN, $6::BOOLEAN, $7::FLOAT8, $8::BYTEA)
+		ORDER BY is_contact DESC, is_prefix DESC, score DESC, id DESC
+		LIMIT $9
+	`, viewer, query, likeEscaper.Replace(query)+"%", after == nil, cursor.Contact, cursor.Prefix, cursor.Score, cursor.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]SearchResult, 0)
+	for rows.Next() {
+		result := SearchResult{}
+		if err := scanUser(rows, &result.User, &result.Contact, &result.Prefix, &result.Score); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func (s *pgUsers) Relations(viewer string, users []string) (map[string]Relation, error) {
+	relations := make(map[string]Relation)
+	if len(users) < 1 {
+		return relations, nil
+	}
+
+	rows, err := s.q.Query(`
+		SELECT "user".id, "user".phone_number_visibility, "user".bio_visibility, "user".profile_pic_visibility,
+			contact.contact IS NOT NULL, saved.contact IS NOT NULL
+		FROM "user"
+		LEFT JOIN contact ON contact.user = "user".id AND contact.contact = $2
+		LEFT JOIN contact saved ON saved.user = $2 AND saved.contact = "user".id
+		WHERE "user".id = ANY($1::BYTEA[])
+	`, pq.Array(users), viewer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		relation := Relation{}
+		if err := rows.Scan(&id, &relation.Privacy.PhoneNumber, &relation.Privacy.Bio, &relation.Privacy.ProfilePic, &relation.Contact, &relation.Saved); err != nil {
+			return nil, err
+		}
+		relations[id] = relation
+	}
+	return relations, rows.Err()
+}
+
+func (s *pgUsers) Blockers(user string, users []string) (map[string]bool, error) {
+	rows, err := s.q.Query(`
+		SELECT blocker FROM block WHERE blocked = $1 AND blocker = ANY($2::BYTEA[])
+	`, user, pq.Array(users))
+	if err != nil {
+		return nil, err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	blockers := make(map[string]bool)
+	for _, id := range ids {
+		blockers[id] = true
+	}
+	return blockers, nil
+}
+
+func (s *pgUsers) BlockerIDs(user string) ([]string, error) {
+	rows, err := s.q.Query(`
+		SELECT blocker FROM block WHERE blocked = $1
+	`, user)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+func (s *pgUsers) Privacy(user string) (Privacy, error) {
+	privacy := Privacy{}
+	err := s.q.QueryRow(`
+		SELECT phone_number_visibility, bio_visibility, profile_pic_visibility FROM "user" WHERE id = $1
+	`, user).Scan(&privacy.PhoneNumber, &privacy.Bio, &privacy.ProfilePic)
+	return privacy, translate(err)
+}
+
+func (s *pgUsers) SetPrivacy(user string, privacy Privacy) (User, error) {
+	result := User{}
+	err := scanUser(s.q.QueryRow(`
+		UPDATE "user"
+		SET
+		phone_number_visibility = $2,
+		bio_visibility = $3,
+		profile_pic_visibility = $4
+		WHERE id = $1
+		RETURNING `+userColumns+`
+	`, user, privacy.PhoneNumber, privacy.Bio, privacy.ProfilePic), &result)
+	return result, translate(err)
+}
+
+func (s *pgUsers) Block(user string, blocked string) error {
+	var exists int
+	err := s.q.QueryRow(`SELECT 1 FROM "user" WHERE id = $1`, blocked).Scan(&exists)
+	if err != nil {
+		return translate(err)
+	}
+	_, err = s.q.Exec(`
+		INSERT INTO block (blocker, blocked) VALUES ($1, $2) ON CONFLICT DO NOTHING
+	`, user, blocked)
+	return translate(err)
+}
+
+func (s *pgUsers) Unblock(user string, blocked string) error {
+	return affected(s.q.Exec(`
+		DELETE FROM block WHERE blocker = $1 AND blocked = $2
+	`, user, blocked))
+}
+
+func (s *pgUsers) Blocked(user string) ([]User, error) {
+	rows, err := s.q.Query(`
+		SELECT `+userColumns+` FROM "user"
+		INNER JOIN block
+		ON block.blocked = "user".id AND block.blocker = $1
+		ORDER BY block.created_at DESC
+	`, user)
+	if err != nil {
+		return nil, err
+	}
+	return scanUsers(rows)
+}
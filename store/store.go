@@ -0,0 +1,209 @@
+// Package store keeps the users, contacts and conversations of backend-core.
+// Handlers go through the interfaces here rather than talking to Postgres
+// themselves, so they can be run against any implementation.
+package store
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+var (
+	// ErrNotFound is returned when what is read or changed doesn't exist
+	ErrNotFound = errors.New("store: not found")
+	// ErrConflict is returned when what is created already exists
+	ErrConflict = errors.New("store: conflict")
+	// ErrReference is returned when what is created or changed refers to a
+	// user or conversation that doesn't exist
+	ErrReference = errors.New("store: reference to nothing")
+)
+
+// Store reads users, contacts and conversations, and changes them in
+// transactions
+type Store interface {
+	Users() UserStore
+	Contacts() ContactStore
+	Conversations() ConversationStore
+
+	Begin() (Tx, error)
+}
+
+// Tx is a transaction. Reads through it see its own changes, and the locks it
+// takes are held until it ends.
+type Tx interface {
+	Users() UserStore
+	Contacts() ContactStore
+	Conversations() ConversationStore
+
+	// Enqueue stores an event per payload as part of the transaction, to be
+	// published once it commits
+	Enqueue(subject string, actor string, payloads ...interface{}) error
+	Commit() error
+	// Rollback undoes the transaction, unless it was committed already
+	Rollback() error
+}
+
+type UserStore interface {
+	// Register creates user, or takes over the user with the same phone
+	// number, such as a placeholder created when someone added it as a
+	// contact. user.ID is set to the ID of the stored user.
+	Register(user *User) (Privacy, error)
+	// Ensure creates the placeholders whose phone numbers aren't taken yet,
+	// returning the users with those phone numbers
+	Ensure(placeholders []User) ([]User, error)
+	// Update changes the profile of user.ID, filling in user.PhoneNumber
+	Update(user *User) (Privacy, error)
+
+	Get(id string) (User, error)
+	// ByPhone and ByUsername don't find users who blocked viewer
+	ByPhone(phone string, viewer string) (User, error)
+	ByUsername(username string, viewer string) (User, error)
+	// Discover finds registered users whose phone number hashes start with
+	// any of prefixes, other than viewer and users who blocked them
+	Discover(prefixes [][]byte, viewer string) ([]DiscoveredUser, error)
+	// Search finds registered users by username or name, other than viewer
+	// and users who blocked them. Results are ranked contacts first, then
+	// prefix matches, then by score, and start after the result after if not
+	// nil. Only contacts come with their phone number.
+	Search(viewer string, query string, after *SearchResult, limit int) ([]SearchResult, error)
+
+	// Relations returns how viewer relates to each of users that exists
+	Relations(viewer string, users []string) (map[string]Relation, error)
+	// Privacy returns who can see the private fields of user
+	Privacy(user string) (Privacy, error)
+	// SetPrivacy changes who can see the private fields of user, returning
+	// their profile
+	SetPrivacy(user string, privacy Privacy) (User, error)
+
+	// Block has user block blocked, ErrNotFound if blocked doesn't exist.
+	// Blocking twice does nothing.
+	Block(user string, blocked string) error
+	// Unblock undoes Block, ErrNotFound if user hasn't blocked blocked
+	Unblock(user string, blocked string) error
+	// Blocked returns the users user has blocked, most recently blocked first
+	Blocked(user string) ([]User, error)
+	// Blockers returns which of users have blocked user
+	Blockers(user string, users []string) (map[string]bool, error)
+	// BlockerIDs returns the IDs of everyone who has blocked user
+	BlockerIDs(user string) ([]string, error)
+}
+
+type ContactStore interface {
+	// Create adds contact to the contacts of user, ErrConflict if it is
+	// there already
+	Create(user string, contact string) error
+	// CreateAll adds contacts to the contacts of user, returning those that
+	// weren't there already
+	CreateAll(user string, contacts []string) ([]string, error)
+	Delete(user string, contact string) error
+
+	Get(user string, contact string) (User, error)
+	Exists(user string, contact string) (bool, error)
+	// List returns the contacts of user by name, starting after the contact
+	// after if not nil
+	List(user string, after *User, limit int) ([]User, error)
+}
+
+// ConversationStore reads conversations as seen by one of their members.
+// Deleted conversations are left out unless noted otherwise.
+type ConversationStore interface {
+	// Create stores conversation, with owner as its first member
+	Create(conversation *Conversation, owner string) error
+	Update(conversation string, title null.String, picture null.String) error
+	// Trash deletes conversation softly on behalf of user
+	Trash(conversation string, user string) error
+	// Restore undeletes conversation, if it was deleted no longer than
+	// retention ago
+	Restore(conversation string, retention time.Duration) (Conversation, error)
+	// Delete removes conversation for good
+	Delete(conversation string) error
+	// Lock serialises membership changes to conversation, whether deleted or
+	// not
+	Lock(conversation string) error
+	// DM returns the DM between user and other, creating it with id if they
+	// have none yet. created reports whether it was.
+	DM(id string, user string, other string) (conversation string, created bool, err error)
+
+	Get(user string, conversation string) (Conversation, error)
+	// List returns the conversations of user, pinned first and then the most
+	// recently active, starting after the conversation after if not nil
+	List(user string, after *Conversation, limit int) ([]Conversation, error)
+	// ByMembers returns the conversations of user with exactly members, or
+	// with at least members if superset, by ID. members include user.
+	ByMembers(user string, members []string, superset bool) ([]Conversation, error)
+	// Shared returns the conversations of user that other is a member of too,
+	// by ID
+	Shared(user string, other string) ([]Conversation, error)
+	// IDs returns the IDs of the conversations of user, deleted ones too
+	IDs(user string) ([]string, error)
+
+	// Role returns the role of user in conversation, locking the membership
+	Role(user string, conversation string) (string, error)
+	// Member returns the membership of user in conversation, even if deleted,
+	// locking it
+	Member(user string, conversation string) (Member, error)
+	// Members returns the members of conversation other than user, by name,
+	// starting after the member after if not nil. ErrNotFound is not returned
+	// if user is not a member or conversation was deleted; there are just no
+	// members.
+	Members(user string, conversation string, after *User, limit int) ([]User, error)
+	// MemberIDs returns the IDs of the members of conversation, even if
+	// deleted
+	MemberIDs(conversation string) ([]string, error)
+	// NextOwner returns who should own conversation once its owner is gone,
+	// preferring admins. ErrNotFound means nobody is left.
+	NextOwner(conversation string) (string, error)
+	// AddMember adds a member, ErrConflict if they are one already
+	AddMember(member Member) error
+	// RemoveMember removes user from conversation, returning the membership
+	RemoveMember(user string, conversation string) (Member, error)
+	SetRole(user string, conversation string, role string) (Member, error)
+	SetPinned(user string, conversation string, pinned bool) (Member, error)
+	// LastHeard returns how far user has heard conversation, 0 if never,
+	// locking the membership
+	LastHeard(user string, conversation string) (int64, error)
+	SetLastHeard(user string, conversation string, lastHeard int64) error
+	// SetPreferences changes how user sees and hears conversation, returning
+	// all of their preferences
+	SetPreferences(user string, conversation string, changes PreferenceChanges) (MemberPreferences, error)
+
+	// Invite invites invite.User into invite.Conversation for ttl, renewing
+	// the pending invite if there is one. invite.ID is kept for new invites
+	// and set to that of the renewed one otherwise; title and times are
+	// filled in.
+	Invite(invite *Invite, ttl time.Duration) error
+	// Invites returns the pending invites of user, most recent first, starting
+	// after the invite after if not nil
+	Invites(user string, after *Invite, limit int) ([]Invite, error)
+	// ConversationInvites is Invites, into conversation rather than for a
+	// user
+	ConversationInvites(conversation string, after *Invite, limit int) ([]Invite, error)
+	// AnswerInvite removes the pending invite id of user, returning it. An
+	// invite is only ever answered once.
+	AnswerInvite(id string, user string) (Invite, error)
+
+	// CreateLink stores link, filling in when it was created
+	CreateLink(link *InviteLink) error
+	// Link returns the invite link with token, whether or not it ran out,
+	// unless its conversation was deleted
+	Link(token string) (InviteLink, error)
+	// Links returns the invite links into conversation, including those that
+	// ran out, most recent first, starting after the link after if not nil
+	Links(conversation string, after *InviteLink, limit int) ([]InviteLink, error)
+	// UseLink takes one use of the link with token, ErrNotFound if it ran out
+	// or expired
+	UseLink(token string) error
+	RevokeLink(conversation string, token string) error
+
+	// Expired returns at most limit conversations deleted longer than
+	// retention ago, oldest first, locking them. Concurrent transactions skip
+	// the conversations locked, rather than waiting for them.
+	Expired(retention time.Duration, limit int) ([]Trashed, error)
+	// Purge removes conversations for good, with everything in them
+	Purge(conversations []string) error
+	// PurgeInvites removes at most limit invites past their expiry, oldest
+	// first, returning how many were removed
+	PurgeInvites(limit int) (int, error)
+}
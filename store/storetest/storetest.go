@@ -0,0 +1,1008 @@
+// Package storetest checks that an implementation of store.Store behaves like
+// the others, Postgres being the reference.
+package storetest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"testing"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+
+	"backend/core/store"
+)
+
+// Run runs the conformance tests. open returns an empty store for each test.
+func Run(t *testing.T, open func(t *testing.T) store.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s store.Store)
+	}{
+		{"Register", testRegister},
+		{"Username", testUsername},
+		{"Ensure", testEnsure},
+		{"Discover", testDiscover},
+		{"Search", testSearch},
+		{"Contacts", testContacts},
+		{"Conversations", testConversations},
+		{"Members", testMembers},
+		{"Owner", testOwner},
+		{"Trash", testTrash},
+		{"DM", testDM},
+		{"Invite", testInvite},
+		{"Invites", testInvites},
+		{"Links", testLinks},
+		{"Privacy", testPrivacy},
+		{"Blocks", testBlocks},
+		{"LastHeard", testLastHeard},
+		{"Preferences", testPreferences},
+		{"TrashedMembers", testTrashedMembers},
+		{"Purge", testPurge},
+		{"PurgeInvites", testPurgeInvites},
+		{"Tx", testTx},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, open(t))
+		})
+	}
+}
+
+func wantErr(t *testing.T, what string, got error, want error) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s: want error %v, got %v", what, want, got)
+	}
+}
+
+func must(t *testing.T, what string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+}
+
+// register creates a registered user with an ID and a phone number ending in
+// n, and a username if not empty
+func register(t *testing.T, s store.Store, n string, first string, username string) store.User {
+	t.Helper()
+	user := store.User{
+		ID:          "u-" + n,
+		FirstName:   first,
+		LastName:    "Test",
+		PhoneNumber: "+65 9000 000" + n,
+	}
+	if username != "" {
+		user.Username = null.StringFrom(username)
+	}
+	_, err := s.Users().Register(&user)
+	must(t, "Register "+user.ID, err)
+	return user
+}
+
+func ids(users []store.User) []string {
+	result := make([]string, len(users))
+	for i, user := range users {
+		result[i] = user.ID
+	}
+	return result
+}
+
+func conversationIDs(conversations []store.Conversation) []string {
+	result := make([]string, len(conversations))
+	for i, conversation := range conversations {
+		result[i] = conversation.ID
+	}
+	return result
+}
+
+// sorted is for results in no particular order
+func sorted(ids []string) []string {
+	sort.Strings(ids)
+	return ids
+}
+
+func sameIDs(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testRegister(t *testing.T, s store.Store) {
+	user := register(t, s, "1", "Alice", "")
+	privacy, err := s.Users().Register(&store.User{ID: "u-other", FirstName: "Alicia", PhoneNumber: user.PhoneNumber})
+	must(t, "Register again", err)
+	if privacy.PhoneNumber != store.VisibilityContacts || privacy.Bio != store.VisibilityEveryone || privacy.ProfilePic != store.VisibilityEveryone {
+		t.Errorf("Want the default privacy, got %v", privacy)
+	}
+
+	// The phone number is taken over, under the original ID
+	got, err := s.Users().ByPhone(user.PhoneNumber, "")
+	must(t, "ByPhone", err)
+	if got.ID != user.ID || got.FirstName != "Alicia" {
+		t.Errorf("Want %s renamed to Alicia, got %v", user.ID, got)
+	}
+	_, err = s.Users().Get("u-other")
+	wantErr(t, "Get taken over", err, store.ErrNotFound)
+
+	// Update keeps the phone number
+	update := store.User{ID: user.ID, FirstName: "Alice", LastName: "Updated", Bio: "bio"}
+	_, err = s.Users().Update(&update)
+	must(t, "Update", err)
+	if update.PhoneNumber != user.PhoneNumber {
+		t.Errorf("Want Update to fill in the phone number %s, got %s", user.PhoneNumber, update.PhoneNumber)
+	}
+	got, err = s.Users().Get(user.ID)
+	must(t, "Get", err)
+	if got.LastName != "Updated" || got.Bio != "bio" {
+		t.Errorf("Want the update stored, got %v", got)
+	}
+	_, err = s.Users().Update(&store.User{ID: "u-missing"})
+	wantErr(t, "Update missing", err, store.ErrNotFound)
+	_, err = s.Users().ByPhone("+65 9999 9999", "")
+	wantErr(t, "ByPhone missing", err, store.ErrNotFound)
+}
+
+func testUsername(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "alice")
+	register(t, s, "2", "Bob", "")
+	register(t, s, "3", "Carol", "")
+
+	// Usernames are unique, but any number of users may have none
+	_, err := s.Users().Register(&store.User{ID: "u-4", FirstName: "Eve", PhoneNumber: "+65 9000 0004", Username: null.StringFrom("alice")})
+	wantErr(t, "Register with a taken username", err, store.ErrConflict)
+	_, err = s.Users().Update(&store.User{ID: "u-2", FirstName: "Bob", Username: null.StringFrom("alice")})
+	wantErr(t, "Update to a taken username", err, store.ErrConflict)
+	_, err = s.Users().Update(&store.User{ID: alice.ID, FirstName: "Alice", Username: null.StringFrom("alice")})
+	must(t, "Update keeping the username", err)
+
+	// Empty usernames are usernames too
+	_, err = s.Users().Update(&store.User{ID: "u-2", FirstName: "Bob", Username: null.StringFrom("")})
+	must(t, "Update to an empty username", err)
+	_, err = s.Users().Update(&store.User{ID: "u-3", FirstName: "Carol", Username: null.StringFrom("")})
+	wantErr(t, "Update to a taken empty username", err, store.ErrConflict)
+
+	got, err := s.Users().ByUsername("alice", "")
+	must(t, "ByUsername", err)
+	if got.ID != alice.ID {
+		t.Errorf("Want %s, got %v", alice.ID, got)
+	}
+	_, err = s.Users().ByUsername("bob", "")
+	wantErr(t, "ByUsername missing", err, store.ErrNotFound)
+}
+
+func testEnsure(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	users, err := s.Users().Ensure([]store.User{
+		{ID: "u-new", PhoneNumber: alice.PhoneNumber},
+		{ID: "u-2", PhoneNumber: "+65 9000 0002"},
+	})
+	must(t, "Ensure", err)
+	if !sameIDs(ids(users), alice.ID, "u-2") {
+		t.Errorf("Want the existing user and a placeholder, got %v", users)
+	}
+	if !users[0].Registered() || users[1].Registered() || users[1].Username.Valid {
+		t.Errorf("Want a placeholder without a name or username, got %v", users[1])
+	}
+
+	// Placeholders can register
+	bob := register(t, s, "2", "Bob", "")
+	if bob.ID != "u-2" {
+		t.Errorf("Want the placeholder to become Bob, got %v", bob)
+	}
+}
+
+func testDiscover(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	bob := register(t, s, "2", "Bob", "")
+	_, err := s.Users().Ensure([]store.User{{ID: "u-3", PhoneNumber: "+65 9000 0003"}})
+	must(t, "Ensure", err)
+
+	hash := func(e164 string) []byte {
+		sum := sha256.Sum256([]byte(e164))
+		return sum[:]
+	}
+	prefix := func(e164 string) []byte {
+		return hash(e164)[:store.PhoneHashPrefixSize]
+	}
+	found, err := s.Users().Discover([][]byte{prefix("+6590000001"), prefix("+6590000002"), prefix("+6590000003")}, alice.ID)
+	must(t, "Discover", err)
+
+	// Neither the viewer nor placeholders are found, and the whole hash is
+	// there to match
+	if len(found) != 1 || found[0].User.ID != bob.ID || found[0].Hash != hex.EncodeToString(hash("+6590000002")) {
+		t.Errorf("Want only %s by their hash, got %v", bob.ID, found)
+	}
+}
+
+func testSearch(t *testing.T, s store.Store) {
+	viewer := register(t, s, "1", "Viewer", "")
+	alice := register(t, s, "2", "Alice", "alice")
+	alicia := register(t, s, "3", "Alicia", "")
+	register(t, s, "4", "Bob", "bob")
+	must(t, "Create contact", s.Contacts().Create(viewer.ID, alicia.ID))
+
+	results, err := s.Users().Search(viewer.ID, "ali", nil, 10)
+	must(t, "Search", err)
+	if len(results) != 2 {
+		t.Fatalf("Want Alice and Alicia, got %v", results)
+	}
+
+	// Contacts first, and only they come with their phone number
+	if results[0].ID != alicia.ID || !results[0].Contact || !results[0].Prefix || results[0].PhoneNumber != alicia.PhoneNumber {
+		t.Errorf("Want the contact %s first, got %v", alicia.ID, results[0])
+	}
+	if results[1].ID != alice.ID || results[1].Contact || results[1].PhoneNumber != "" {
+		t.Errorf("Want %s second, got %v", alice.ID, results[1])
+	}
+
+	// Paging
+	next, err := s.Users().Search(viewer.ID, "ali", &results[0], 10)
+	must(t, "Search after", err)
+	if len(next) != 1 || next[0].ID != alice.ID || next[0].Score != results[1].Score {
+		t.Errorf("Want %s after the contact, got %v", alice.ID, next)
+	}
+
+	// Fuzzy matches
+	results, err = s.Users().Search(viewer.ID, "alise", nil, 10)
+	must(t, "Search fuzzy", err)
+	if len(results) < 1 || results[0].Prefix || results[0].Score <= 0 {
+		t.Errorf("Want similar users without a prefix match, got %v", results)
+	}
+}
+
+func testContacts(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	bob := register(t, s, "2", "Bob", "")
+	carol := register(t, s, "3", "Carol", "")
+
+	must(t, "Create", s.Contacts().Create(alice.ID, carol.ID))
+	wantErr(t, "Create again", s.Contacts().Create(alice.ID, carol.ID), store.ErrConflict)
+	wantErr(t, "Create missing", s.Contacts().Create(alice.ID, "u-missing"), store.ErrReference)
+
+	created, err := s.Contacts().CreateAll(alice.ID, []string{bob.ID, carol.ID})
+	must(t, "CreateAll", err)
+	if !sameIDs(created, bob.ID) {
+		t.Errorf("Want only %s created, got %v", bob.ID, created)
+	}
+
+	// By name
+	contacts, err := s.Contacts().List(alice.ID, nil, 1)
+	must(t, "List", err)
+	if !sameIDs(ids(contacts), bob.ID) {
+		t.Errorf("Want %s first, got %v", bob.ID, ids(contacts))
+	}
+	contacts, err = s.Contacts().List(alice.ID, &contacts[0], 10)
+	must(t, "List after", err)
+	if !sameIDs(ids(contacts), carol.ID) {
+		t.Errorf("Want %s next, got %v", carol.ID, ids(contacts))
+	}
+
+	got, err := s.Contacts().Get(alice.ID, bob.ID)
+	must(t, "Get", err)
+	if got.ID != bob.ID || got.PhoneNumber != bob.PhoneNumber {
+		t.Errorf("Want %v, got %v", bob, got)
+	}
+	_, err = s.Contacts().Get(bob.ID, alice.ID)
+	wantErr(t, "Get the other way around", err, store.ErrNotFound)
+
+	// Relations go both ways
+	relations, err := s.Users().Relations(bob.ID, []string{alice.ID, "u-missing"})
+	must(t, "Relations", err)
+	if relation, ok := relations[alice.ID]; !ok || !relation.Contact || relation.Saved || relation.Privacy.Bio != store.VisibilityEveryone {
+		t.Errorf("Want Alice to have Bob as a contact, got %v", relations)
+	}
+	if _, ok := relations["u-missing"]; ok {
+		t.Error("Want no relation to missing users")
+	}
+
+	must(t, "Delete", s.Contacts().Delete(alice.ID, bob.ID))
+	wantErr(t, "Delete again", s.Contacts().Delete(alice.ID, bob.ID), store.ErrNotFound)
+	exists, err := s.Contacts().Exists(alice.ID, bob.ID)
+	must(t, "Exists", err)
+	if exists {
+		t.Error("Want the contact deleted")
+	}
+}
+
+func testConversations(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	bob := register(t, s, "2", "Bob", "")
+
+	conversations := s.Conversations()
+	first := store.Conversation{ID: "c-1", Title: null.StringFrom("First")}
+	must(t, "Create", conversations.Create(&first, alice.ID))
+	wantErr(t, "Create again", conversations.Create(&first, alice.ID), store.ErrConflict)
+	wantErr(t, "Create for missing owner", conversations.Create(&store.Conversation{ID: "c-missing"}, "u-missing"), store.ErrReference)
+	time.Sleep(time.Millisecond)
+	second := store.Conversation{ID: "c-2", Title: null.StringFrom("Second")}
+	must(t, "Create second", conversations.Create(&second, alice.ID))
+
+	got, err := conversations.Get(alice.ID, first.ID)
+	must(t, "Get", err)
+	if got.Title.String != "First" || got.Role != store.RoleOwner || got.Notifications != store.NotifyAll || got.DM {
+		t.Errorf("Want the conversation as seen by its owner, got %v", got)
+	}
+	_, err = conversations.Get(bob.ID, first.ID)
+	wantErr(t, "Get as a stranger", err, store.ErrNotFound)
+
+	// Most recently active first
+	list, err := conversations.List(alice.ID, nil, 10)
+	must(t, "List", err)
+	if !sameIDs(conversationIDs(list), second.ID, first.ID) {
+		t.Errorf("Want the newest first, got %v", conversationIDs(list))
+	}
+
+	// Updates count as activity, pinned conversations come first anyway
+	time.Sleep(time.Millisecond)
+	must(t, "Update", conversations.Update(first.ID, null.StringFrom("Renamed"), null.String{}))
+	wantErr(t, "Update missing", conversations.Update("c-missing", null.String{}, null.String{}), store.ErrNotFound)
+	list, err = conversations.List(alice.ID, nil, 1)
+	must(t, "List after Update", err)
+	if !sameIDs(conversationIDs(list), first.ID) || list[0].Title.String != "Renamed" {
+		t.Errorf("Want the renamed conversation first, got %v", list)
+	}
+	list, err = conversations.List(alice.ID, &list[0], 10)
+	must(t, "List next", err)
+	if !sameIDs(conversationIDs(list), second.ID) {
+		t.Errorf("Want the other conversation next, got %v", conversationIDs(list))
+	}
+	member, err := conversations.SetPinned(alice.ID, second.ID, true)
+	must(t, "SetPinned", err)
+	if !member.Pinned || member.Role != store.RoleOwner {
+		t.Errorf("Want a pinned owner, got %v", member)
+	}
+	list, err = conversations.List(alice.ID, nil, 10)
+	must(t, "List pinned", err)
+	if !sameIDs(conversationIDs(list), second.ID, first.ID) || !list[0].Pinned {
+		t.Errorf("Want the pinned conversation first, got %v", conversationIDs(list))
+	}
+	_, err = conversations.SetPinned(bob.ID, second.ID, true)
+	wantErr(t, "SetPinned as a stranger", err, store.ErrNotFound)
+}
+
+func testMembers(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	bob := register(t, s, "2", "Bob", "")
+	carol := register(t, s, "3", "Carol", "")
+
+	conversations := s.Conversations()
+	conversation := store.Conversation{ID: "c-1"}
+	must(t, "Create", conversations.Create(&conversation, alice.ID))
+	other := store.Conversation{ID: "c-2"}
+	must(t, "Create other", conversations.Create(&other, alice.ID))
+
+	for _, user := range []string{bob.ID, carol.ID} {
+		must(t, "AddMember "+user, conversations.AddMember(store.Member{User: user, Conversation: conversation.ID, Role: store.RoleMember}))
+	}
+	must(t, "AddMember to other", conversations.AddMember(store.Member{User: bob.ID, Conversation: other.ID, Role: store.RoleMember}))
+	wantErr(t, "AddMember again", conversations.AddMember(store.Member{User: bob.ID, Conversation: conversation.ID, Role: store.RoleAdmin}), store.ErrConflict)
+	wantErr(t, "AddMember missing user", conversations.AddMember(store.Member{User: "u-missing", Conversation: conversation.ID, Role: store.RoleMember}), store.ErrReference)
+	wantErr(t, "AddMember to missing conversation", conversations.AddMember(store.Member{User: bob.ID, Conversation: "c-missing", Role: store.RoleMember}), store.ErrReference)
+
+	role, err := conversations.Role(bob.ID, conversation.ID)
+	must(t, "Role", err)
+	if role != store.RoleMember {
+		t.Errorf("Want %s, got %s", store.RoleMember, role)
+	}
+
+	// Others, by name
+	members, err := conversations.Members(alice.ID, conversation.ID, nil, 10)
+	must(t, "Members", err)
+	if !sameIDs(ids(members), bob.ID, carol.ID) {
+		t.Errorf("Want Bob and Carol, got %v", ids(members))
+	}
+	members, err = conversations.Members(alice.ID, conversation.ID, &members[0], 10)
+	must(t, "Members after", err)
+	if !sameIDs(ids(members), carol.ID) {
+		t.Errorf("Want Carol after Bob, got %v", ids(members))
+	}
+	members, err = conversations.Members("u-missing", conversation.ID, nil, 10)
+	must(t, "Members as a stranger", err)
+	if len(members) != 0 {
+		t.Errorf("Want no members for strangers, got %v", ids(members))
+	}
+
+	// Exactly, or at least, these members, by ID
+	found, err := conversations.ByMembers(alice.ID, []string{alice.ID, bob.ID}, false)
+	must(t, "ByMembers", err)
+	if !sameIDs(conversationIDs(found), other.ID) {
+		t.Errorf("Want only %s with exactly Alice and Bob, got %v", other.ID, conversationIDs(found))
+	}
+	found, err = conversations.ByMembers(alice.ID, []string{alice.ID, bob.ID}, true)
+	must(t, "ByMembers superset", err)
+	if !sameIDs(conversationIDs(found), conversation.ID, other.ID) {
+		t.Errorf("Want both with at least Alice and Bob, got %v", conversationIDs(found))
+	}
+	shared, err := conversations.Shared(alice.ID, carol.ID)
+	must(t, "Shared", err)
+	if !sameIDs(conversationIDs(shared), conversation.ID) {
+		t.Errorf("Want %s shared with Carol, got %v", conversation.ID, conversationIDs(shared))
+	}
+
+	// Admins are next in line
+	_, err = conversations.SetRole(carol.ID, conversation.ID, store.RoleAdmin)
+	must(t, "SetRole", err)
+	removed, err := conversations.RemoveMember(alice.ID, conversation.ID)
+	must(t, "RemoveMember", err)
+	if removed.Role != store.RoleOwner || removed.User != alice.ID {
+		t.Errorf("Want the removed owner, got %v", removed)
+	}
+	_, err = conversations.RemoveMember(alice.ID, conversation.ID)
+	wantErr(t, "RemoveMember again", err, store.ErrNotFound)
+	next, err := conversations.NextOwner(conversation.ID)
+	must(t, "NextOwner", err)
+	if next != carol.ID {
+		t.Errorf("Want the admin %s next, got %s", carol.ID, next)
+	}
+
+	// Conversations go once their members have
+	wantErr(t, "Delete with members", conversations.Delete(conversation.ID), store.ErrReference)
+	for _, user := range []string{bob.ID, carol.ID} {
+		_, err = conversations.RemoveMember(user, conversation.ID)
+		must(t, "RemoveMember "+user, err)
+	}
+	_, err = conversations.NextOwner(conversation.ID)
+	wantErr(t, "NextOwner of nobody", err, store.ErrNotFound)
+	must(t, "Delete", conversations.Delete(conversation.ID))
+	wantErr(t, "Delete again", conversations.Delete(conversation.ID), store.ErrNotFound)
+	wantErr(t, "Lock deleted", conversations.Lock(conversation.ID), store.ErrNotFound)
+}
+
+func testOwner(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	bob := register(t, s, "2", "Bob", "")
+
+	conversations := s.Conversations()
+	conversation := store.Conversation{ID: "c-1"}
+	must(t, "Create", conversations.Create(&conversation, alice.ID))
+
+	// One owner per conversation
+	wantErr(t, "AddMember as a second owner", conversations.AddMember(store.Member{User: bob.ID, Conversation: conversation.ID, Role: store.RoleOwner}), store.ErrConflict)
+	must(t, "AddMember", conversations.AddMember(store.Member{User: bob.ID, Conversation: conversation.ID, Role: store.RoleMember}))
+	_, err := conversations.SetRole(bob.ID, conversation.ID, store.RoleOwner)
+	wantErr(t, "SetRole to a second owner", err, store.ErrConflict)
+
+	// Transfers go through admin
+	_, err = conversations.SetRole(alice.ID, conversation.ID, store.RoleAdmin)
+	must(t, "SetRole previous owner", err)
+	member, err := conversations.SetRole(bob.ID, conversation.ID, store.RoleOwner)
+	must(t, "SetRole next owner", err)
+	if member.Role != store.RoleOwner || member.User != bob.ID || member.Conversation != conversation.ID {
+		t.Errorf("Want Bob to own the conversation, got %v", member)
+	}
+	_, err = conversations.SetRole("u-missing", conversation.ID, store.RoleMember)
+	wantErr(t, "SetRole missing", err, store.ErrNotFound)
+}
+
+func testTrash(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+
+	conversations := s.Conversations()
+	conversation := store.Conversation{ID: "c-1", Title: null.StringFrom("Trash")}
+	must(t, "Create", conversations.Create(&conversation, alice.ID))
+	must(t, "Trash", conversations.Trash(conversation.ID, alice.ID))
+	wantErr(t, "Trash again", conversations.Trash(conversation.ID, alice.ID), store.ErrNotFound)
+
+	// Deleted conversations are out of sight, but their members are kept
+	_, err := conversations.Get(alice.ID, conversation.ID)
+	wantErr(t, "Get", err, store.ErrNotFound)
+	_, err = conversations.Role(alice.ID, conversation.ID)
+	wantErr(t, "Role", err, store.ErrNotFound)
+	list, err := conversations.List(alice.ID, nil, 10)
+	must(t, "List", err)
+	if len(list) != 0 {
+		t.Errorf("Want no conversations listed, got %v", conversationIDs(list))
+	}
+	member, err := conversations.Member(alice.ID, conversation.ID)
+	must(t, "Member", err)
+	if member.Role != store.RoleOwner {
+		t.Errorf("Want the owner kept, got %v", member)
+	}
+	must(t, "Lock", conversations.Lock(conversation.ID))
+
+	restored, err := conversations.Restore(conversation.ID, time.Hour)
+	must(t, "Restore", err)
+	if restored.ID != conversation.ID || restored.Title.String != "Trash" {
+		t.Errorf("Want the conversation restored, got %v", restored)
+	}
+	_, err = conversations.Restore(conversation.ID, time.Hour)
+	wantErr(t, "Restore again", err, store.ErrNotFound)
+	_, err = conversations.Get(alice.ID, conversation.ID)
+	must(t, "Get restored", err)
+
+	// Only for so long
+	must(t, "Trash for good", conversations.Trash(conversation.ID, alice.ID))
+	time.Sleep(time.Millisecond)
+	_, err = conversations.Restore(conversation.ID, time.Microsecond)
+	wantErr(t, "Restore too late", err, store.ErrNotFound)
+}
+
+func testDM(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	bob := register(t, s, "2", "Bob", "")
+
+	conversations := s.Conversations()
+	id, created, err := conversations.DM("c-dm", bob.ID, alice.ID)
+	must(t, "DM", err)
+	if id != "c-dm" || !created {
+		t.Errorf("Want c-dm created, got %s, %v", id, created)
+	}
+	id, created, err = conversations.DM("c-other", alice.ID, bob.ID)
+	must(t, "DM again", err)
+	if id != "c-dm" || created {
+		t.Errorf("Want c-dm found, got %s, %v", id, created)
+	}
+	_, _, err = conversations.DM("c-missing", alice.ID, "u-missing")
+	wantErr(t, "DM with missing user", err, store.ErrReference)
+
+	// DMs start without members
+	must(t, "AddMember", conversations.AddMember(store.Member{User: alice.ID, Conversation: id, Role: store.RoleMember}))
+	got, err := conversations.Get(alice.ID, id)
+	must(t, "Get", err)
+	if !got.DM || got.Title.Valid {
+		t.Errorf("Want an untitled DM, got %v", got)
+	}
+}
+
+func testInvite(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	bob := register(t, s, "2", "Bob", "")
+
+	conversations := s.Conversations()
+	conversation := store.Conversation{ID: "c-1", Title: null.StringFrom("Invited")}
+	must(t, "Create", conversations.Create(&conversation, alice.ID))
+
+	invite := store.Invite{ID: "i-1", Conversation: conversation.ID, User: bob.ID, Inviter: alice.ID}
+	must(t, "Invite", conversations.Invite(&invite, time.Hour))
+	if invite.ID != "i-1" || invite.Title.String != "Invited" || invite.ExpiresAt.Sub(invite.CreatedAt) != time.Hour {
+		t.Errorf("Want a new invite for an hour, got %v", invite)
+	}
+
+	// Inviting again renews the pending invite
+	renewed := store.Invite{ID: "i-2", Conversation: conversation.ID, User: bob.ID, Inviter: alice.ID}
+	must(t, "Invite again", conversations.Invite(&renewed, 2*time.Hour))
+	if renewed.ID != "i-1" || renewed.ExpiresAt.Sub(renewed.CreatedAt) != 2*time.Hour {
+		t.Errorf("Want i-1 renewed, got %v", renewed)
+	}
+
+	missing := store.Invite{ID: "i-3", Conversation: conversation.ID, User: "u-missing", Inviter: alice.ID}
+	wantErr(t, "Invite missing user", conversations.Invite(&missing, time.Hour), store.ErrReference)
+}
+
+func inviteIDs(invites []store.Invite) []string {
+	result := make([]string, len(invites))
+	for i, invite := range invites {
+		result[i] = invite.ID
+	}
+	return result
+}
+
+func testInvites(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	bob := register(t, s, "2", "Bob", "")
+	carol := register(t, s, "3", "Carol", "")
+
+	conversations := s.Conversations()
+	first := store.Conversation{ID: "c-1", Title: null.StringFrom("First")}
+	must(t, "Create", conversations.Create(&first, alice.ID))
+	second := store.Conversation{ID: "c-2", Title: null.StringFrom("Second")}
+	must(t, "Create second", conversations.Create(&second, alice.ID))
+
+	invite := func(id string, conversation string, user string, ttl time.Duration) {
+		must(t, "Invite "+id, conversations.Invite(&store.Invite{ID: id, Conversation: conversation, User: user, Inviter: alice.ID}, ttl))
+	}
+	invite("i-1", first.ID, bob.ID, time.Hour)
+	time.Sleep(time.Millisecond)
+	invite("i-2", second.ID, bob.ID, time.Hour)
+	invite("i-3", first.ID, carol.ID, -time.Hour)
+
+	// Pending ones, most recent first
+	invites, err := conversations.Invites(bob.ID, nil, 10)
+	must(t, "Invites", err)
+	if !sameIDs(inviteIDs(invites), "i-2", "i-1") || invites[0].Title.String != "Second" {
+		t.Errorf("Want i-2 and i-1 with their titles, got %v", invites)
+	}
+	invites, err = conversations.Invites(bob.ID, &invites[0], 10)
+	must(t, "Invites after", err)
+	if !sameIDs(inviteIDs(invites), "i-1") {
+		t.Errorf("Want i-1 after i-2, got %v", inviteIDs(invites))
+	}
+	invites, err = conversations.ConversationInvites(first.ID, nil, 10)
+	must(t, "ConversationInvites", err)
+	if !sameIDs(inviteIDs(invites), "i-1") {
+		t.Errorf("Want only i-1 pending in %s, got %v", first.ID, inviteIDs(invites))
+	}
+
+	// Answered once, by the invited user
+	_, err = conversations.AnswerInvite("i-1", carol.ID)
+	wantErr(t, "AnswerInvite of another user", err, store.ErrNotFound)
+	_, err = conversations.AnswerInvite("i-3", carol.ID)
+	wantErr(t, "AnswerInvite expired", err, store.ErrNotFound)
+	answered, err := conversations.AnswerInvite("i-1", bob.ID)
+	must(t, "AnswerInvite", err)
+	if answered.Conversation != first.ID || answered.Inviter != alice.ID || answered.Title.String != "First" {
+		t.Errorf("Want i-1 answered, got %v", answered)
+	}
+	_, err = conversations.AnswerInvite("i-1", bob.ID)
+	wantErr(t, "AnswerInvite again", err, store.ErrNotFound)
+
+	// Deleted conversations take their invites out of sight
+	must(t, "Trash", conversations.Trash(second.ID, alice.ID))
+	invites, err = conversations.Invites(bob.ID, nil, 10)
+	must(t, "Invites after Trash", err)
+	if len(invites) != 0 {
+		t.Errorf("Want no invites left, got %v", inviteIDs(invites))
+	}
+	_, err = conversations.AnswerInvite("i-2", bob.ID)
+	wantErr(t, "AnswerInvite into deleted", err, store.ErrNotFound)
+}
+
+func testLinks(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+
+	conversations := s.Conversations()
+	conversation := store.Conversation{ID: "c-1"}
+	must(t, "Create", conversations.Create(&conversation, alice.ID))
+
+	once := store.InviteLink{Token: "l-1", Conversation: conversation.ID, Creator: alice.ID, UsesLeft: null.IntFrom(1)}
+	must(t, "CreateLink", conversations.CreateLink(&once))
+	if once.CreatedAt.IsZero() {
+		t.Error("Want the creation time filled in")
+	}
+	wantErr(t, "CreateLink again", conversations.CreateLink(&once), store.ErrConflict)
+	wantErr(t, "CreateLink to missing", conversations.CreateLink(&store.InviteLink{Token: "l-missing", Conversation: "c-missing", Creator: alice.ID}), store.ErrReference)
+	time.Sleep(time.Millisecond)
+	unlimited := store.InviteLink{Token: "l-2", Conversation: conversation.ID, Creator: alice.ID, ExpiresAt: null.TimeFrom(time.Now().Add(time.Hour))}
+	must(t, "CreateLink unlimited", conversations.CreateLink(&unlimited))
+	expired := store.InviteLink{Token: "l-3", Conversation: conversation.ID, Creator: alice.ID, ExpiresAt: null.TimeFrom(time.Now().Add(-time.Hour))}
+	must(t, "CreateLink expired", conversations.CreateLink(&expired))
+
+	// Most recent first
+	links, err := conversations.Links(conversation.ID, nil, 2)
+	must(t, "Links", err)
+	if len(links) != 2 || links[1].Token != unlimited.Token {
+		t.Fatalf("Want l-2 second to last, got %v", links)
+	}
+	links, err = conversations.Links(conversation.ID, &links[1], 10)
+	must(t, "Links after", err)
+	if len(links) != 1 || links[0].Token != once.Token {
+		t.Errorf("Want l-1 last, got %v", links)
+	}
+
+	// Used up, or expired
+	must(t, "UseLink", conversations.UseLink(once.Token))
+	wantErr(t, "UseLink used up", conversations.UseLink(once.Token), store.ErrNotFound)
+	wantErr(t, "UseLink expired", conversations.UseLink(expired.Token), store.ErrNotFound)
+	wantErr(t, "UseLink missing", conversations.UseLink("l-missing"), store.ErrNotFound)
+	must(t, "UseLink unlimited", conversations.UseLink(unlimited.Token))
+	link, err := conversations.Link(once.Token)
+	must(t, "Link", err)
+	if link.UsesLeft != null.IntFrom(0) || link.Conversation != conversation.ID {
+		t.Errorf("Want l-1 without uses left, got %v", link)
+	}
+
+	wantErr(t, "RevokeLink elsewhere", conversations.RevokeLink("c-other", unlimited.Token), store.ErrNotFound)
+	must(t, "RevokeLink", conversations.RevokeLink(conversation.ID, unlimited.Token))
+	_, err = conversations.Link(unlimited.Token)
+	wantErr(t, "Link revoked", err, store.ErrNotFound)
+
+	// Not into deleted conversations
+	must(t, "Trash", conversations.Trash(conversation.ID, alice.ID))
+	_, err = conversations.Link(once.Token)
+	wantErr(t, "Link into deleted", err, store.ErrNotFound)
+}
+
+func testPrivacy(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+
+	users := s.Users()
+	privacy := store.Privacy{PhoneNumber: store.VisibilityNobody, Bio: store.VisibilityContacts, ProfilePic: store.VisibilityNobody}
+	user, err := users.SetPrivacy(alice.ID, privacy)
+	must(t, "SetPrivacy", err)
+	if user.ID != alice.ID || user.PhoneNumber != alice.PhoneNumber {
+		t.Errorf("Want the profile of Alice, got %v", user)
+	}
+	got, err := users.Privacy(alice.ID)
+	must(t, "Privacy", err)
+	if got != privacy {
+		t.Errorf("Want %v, got %v", privacy, got)
+	}
+
+	_, err = users.Privacy("u-missing")
+	wantErr(t, "Privacy missing", err, store.ErrNotFound)
+	_, err = users.SetPrivacy("u-missing", privacy)
+	wantErr(t, "SetPrivacy missing", err, store.ErrNotFound)
+}
+
+func testBlocks(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "alice")
+	bob := register(t, s, "2", "Bob", "")
+	carol := register(t, s, "3", "Carol", "")
+
+	users := s.Users()
+	must(t, "Block", users.Block(alice.ID, bob.ID))
+	must(t, "Block again", users.Block(alice.ID, bob.ID))
+	wantErr(t, "Block missing", users.Block(alice.ID, "u-missing"), store.ErrNotFound)
+	time.Sleep(time.Millisecond)
+	must(t, "Block another", users.Block(alice.ID, carol.ID))
+
+	// Most recently blocked first
+	blocked, err := users.Blocked(alice.ID)
+	must(t, "Blocked", err)
+	if !sameIDs(ids(blocked), carol.ID, bob.ID) {
+		t.Errorf("Want Carol then Bob, got %v", ids(blocked))
+	}
+	blockers, err := users.Blockers(bob.ID, []string{alice.ID, carol.ID})
+	must(t, "Blockers", err)
+	if len(blockers) != 1 || !blockers[alice.ID] {
+		t.Errorf("Want only Alice to block Bob, got %v", blockers)
+	}
+	blockerIDs, err := users.BlockerIDs(carol.ID)
+	must(t, "BlockerIDs", err)
+	if !sameIDs(blockerIDs, alice.ID) {
+		t.Errorf("Want only Alice to block Carol, got %v", blockerIDs)
+	}
+
+	// Blocked users can't find the blocker
+	_, err = users.ByPhone(alice.PhoneNumber, bob.ID)
+	wantErr(t, "ByPhone", err, store.ErrNotFound)
+	_, err = users.ByUsername("alice", bob.ID)
+	wantErr(t, "ByUsername", err, store.ErrNotFound)
+	sum := sha256.Sum256([]byte("+6590000001"))
+	discovered, err := users.Discover([][]byte{sum[:store.PhoneHashPrefixSize]}, bob.ID)
+	must(t, "Discover", err)
+	if len(discovered) != 0 {
+		t.Errorf("Want Alice undiscovered, got %v", discovered)
+	}
+	results, err := users.Search(bob.ID, "Alice", nil, 10)
+	must(t, "Search", err)
+	if len(results) != 0 {
+		t.Errorf("Want Alice not found, got %v", results)
+	}
+
+	must(t, "Unblock", users.Unblock(alice.ID, bob.ID))
+	wantErr(t, "Unblock again", users.Unblock(alice.ID, bob.ID), store.ErrNotFound)
+	_, err = users.ByPhone(alice.PhoneNumber, bob.ID)
+	must(t, "ByPhone after Unblock", err)
+}
+
+func testLastHeard(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	bob := register(t, s, "2", "Bob", "")
+
+	conversations := s.Conversations()
+	conversation := store.Conversation{ID: "c-1"}
+	must(t, "Create", conversations.Create(&conversation, alice.ID))
+
+	lastHeard, err := conversations.LastHeard(alice.ID, conversation.ID)
+	must(t, "LastHeard", err)
+	if lastHeard != 0 {
+		t.Errorf("Want nothing heard, got %d", lastHeard)
+	}
+	must(t, "SetLastHeard", conversations.SetLastHeard(alice.ID, conversation.ID, 42))
+	lastHeard, err = conversations.LastHeard(alice.ID, conversation.ID)
+	must(t, "LastHeard after", err)
+	got, err := conversations.Get(alice.ID, conversation.ID)
+	must(t, "Get", err)
+	if lastHeard != 42 || got.LastHeard != null.IntFrom(42) {
+		t.Errorf("Want 42 heard, got %d and %v", lastHeard, got.LastHeard)
+	}
+
+	_, err = conversations.LastHeard(bob.ID, conversation.ID)
+	wantErr(t, "LastHeard as a stranger", err, store.ErrNotFound)
+	wantErr(t, "SetLastHeard as a stranger", conversations.SetLastHeard(bob.ID, conversation.ID, 42), store.ErrNotFound)
+}
+
+func testPreferences(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+
+	conversations := s.Conversations()
+	conversation := store.Conversation{ID: "c-1"}
+	must(t, "Create", conversations.Create(&conversation, alice.ID))
+
+	until := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	preferences, err := conversations.SetPreferences(alice.ID, conversation.ID, store.PreferenceChanges{
+		Archived:   null.BoolFrom(true),
+		Mute:       true,
+		MutedUntil: null.TimeFrom(until),
+	})
+	must(t, "SetPreferences", err)
+	if !preferences.Archived || !preferences.MutedUntil.Time.Equal(until) || preferences.Notifications != store.NotifyAll {
+		t.Errorf("Want archived and muted, got %v", preferences)
+	}
+
+	// Preferences left out stay as they are
+	preferences, err = conversations.SetPreferences(alice.ID, conversation.ID, store.PreferenceChanges{
+		Notifications: null.StringFrom(store.NotifyNone),
+	})
+	must(t, "SetPreferences notifications", err)
+	if !preferences.Archived || !preferences.MutedUntil.Valid || preferences.Notifications != store.NotifyNone {
+		t.Errorf("Want only the notifications changed, got %v", preferences)
+	}
+	preferences, err = conversations.SetPreferences(alice.ID, conversation.ID, store.PreferenceChanges{Mute: true})
+	must(t, "SetPreferences unmute", err)
+	got, err := conversations.Get(alice.ID, conversation.ID)
+	must(t, "Get", err)
+	if preferences.MutedUntil.Valid || got.MutedUntil.Valid || !got.Archived || got.Notifications != store.NotifyNone {
+		t.Errorf("Want only the mute undone, got %v and %v", preferences, got)
+	}
+
+	_, err = conversations.SetPreferences("u-missing", conversation.ID, store.PreferenceChanges{})
+	wantErr(t, "SetPreferences as a stranger", err, store.ErrNotFound)
+}
+
+func testTrashedMembers(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	bob := register(t, s, "2", "Bob", "")
+
+	conversations := s.Conversations()
+	conversation := store.Conversation{ID: "c-1"}
+	must(t, "Create", conversations.Create(&conversation, alice.ID))
+	must(t, "AddMember", conversations.AddMember(store.Member{User: bob.ID, Conversation: conversation.ID, Role: store.RoleMember}))
+	must(t, "Trash", conversations.Trash(conversation.ID, alice.ID))
+
+	// Deleted conversations are as hidden from members as from Get and List
+	members, err := conversations.Members(alice.ID, conversation.ID, nil, 10)
+	must(t, "Members", err)
+	if len(members) != 0 {
+		t.Errorf("Want no members listed, got %v", ids(members))
+	}
+	_, err = conversations.LastHeard(alice.ID, conversation.ID)
+	wantErr(t, "LastHeard", err, store.ErrNotFound)
+	wantErr(t, "SetLastHeard", conversations.SetLastHeard(alice.ID, conversation.ID, 42), store.ErrNotFound)
+	_, err = conversations.SetPreferences(alice.ID, conversation.ID, store.PreferenceChanges{Archived: null.BoolFrom(true)})
+	wantErr(t, "SetPreferences", err, store.ErrNotFound)
+
+	// Until restored
+	_, err = conversations.Restore(conversation.ID, time.Hour)
+	must(t, "Restore", err)
+	members, err = conversations.Members(alice.ID, conversation.ID, nil, 10)
+	must(t, "Members after Restore", err)
+	if !sameIDs(ids(members), bob.ID) {
+		t.Errorf("Want Bob listed again, got %v", ids(members))
+	}
+	must(t, "SetLastHeard after Restore", conversations.SetLastHeard(alice.ID, conversation.ID, 42))
+}
+
+func testPurge(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	bob := register(t, s, "2", "Bob", "")
+
+	conversations := s.Conversations()
+	trashed := store.Conversation{ID: "c-1"}
+	must(t, "Create", conversations.Create(&trashed, alice.ID))
+	must(t, "AddMember", conversations.AddMember(store.Member{User: bob.ID, Conversation: trashed.ID, Role: store.RoleMember}))
+	must(t, "CreateLink", conversations.CreateLink(&store.InviteLink{Token: "l-1", Conversation: trashed.ID, Creator: alice.ID}))
+	kept := store.Conversation{ID: "c-2"}
+	must(t, "Create kept", conversations.Create(&kept, alice.ID))
+	must(t, "Trash", conversations.Trash(trashed.ID, alice.ID))
+
+	// Deleted conversations keep their members until purged
+	memberIDs, err := conversations.MemberIDs(trashed.ID)
+	must(t, "MemberIDs", err)
+	if !sameIDs(sorted(memberIDs), sorted([]string{alice.ID, bob.ID})...) {
+		t.Errorf("Want Alice and Bob in %s, got %v", trashed.ID, memberIDs)
+	}
+	owned, err := conversations.IDs(alice.ID)
+	must(t, "IDs", err)
+	if !sameIDs(sorted(owned), trashed.ID, kept.ID) {
+		t.Errorf("Want both conversations of Alice, got %v", owned)
+	}
+
+	expired, err := conversations.Expired(time.Hour, 10)
+	must(t, "Expired", err)
+	if len(expired) != 0 {
+		t.Errorf("Want nothing expired yet, got %v", expired)
+	}
+	time.Sleep(time.Millisecond)
+	expired, err = conversations.Expired(0, 10)
+	must(t, "Expired past the retention", err)
+	if len(expired) != 1 || expired[0] != (store.Trashed{Conversation: trashed.ID, By: alice.ID}) {
+		t.Fatalf("Want %s deleted by Alice, got %v", trashed.ID, expired)
+	}
+
+	tx, err := s.Begin()
+	must(t, "Begin", err)
+	defer tx.Rollback()
+	must(t, "Purge", tx.Conversations().Purge([]string{trashed.ID}))
+	must(t, "Commit", tx.Commit())
+
+	// Gone for good, with its members
+	_, err = conversations.Member(bob.ID, trashed.ID)
+	wantErr(t, "Member", err, store.ErrNotFound)
+	_, err = conversations.Restore(trashed.ID, time.Hour)
+	wantErr(t, "Restore", err, store.ErrNotFound)
+	expired, err = conversations.Expired(0, 10)
+	must(t, "Expired after Purge", err)
+	if len(expired) != 0 {
+		t.Errorf("Want nothing expired left, got %v", expired)
+	}
+	_, err = conversations.Get(alice.ID, kept.ID)
+	must(t, "Get kept", err)
+	owned, err = conversations.IDs(alice.ID)
+	must(t, "IDs after Purge", err)
+	if !sameIDs(owned, kept.ID) {
+		t.Errorf("Want only %s left, got %v", kept.ID, owned)
+	}
+}
+
+func testPurgeInvites(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+	bob := register(t, s, "2", "Bob", "")
+	carol := register(t, s, "3", "Carol", "")
+
+	conversations := s.Conversations()
+	first := store.Conversation{ID: "c-1"}
+	must(t, "Create", conversations.Create(&first, alice.ID))
+	second := store.Conversation{ID: "c-2"}
+	must(t, "Create second", conversations.Create(&second, alice.ID))
+
+	invite := func(id string, conversation string, user string, ttl time.Duration) {
+		must(t, "Invite "+id, conversations.Invite(&store.Invite{ID: id, Conversation: conversation, User: user, Inviter: alice.ID}, ttl))
+	}
+	invite("i-1", first.ID, bob.ID, -2*time.Hour)
+	invite("i-2", first.ID, carol.ID, -time.Hour)
+	invite("i-3", second.ID, bob.ID, time.Hour)
+
+	// Expired ones, a batch at a time
+	for _, want := range []int{1, 1, 0} {
+		n, err := conversations.PurgeInvites(1)
+		must(t, "PurgeInvites", err)
+		if n != want {
+			t.Errorf("Want %d invites purged, got %d", want, n)
+		}
+	}
+
+	// Pending ones stay
+	invites, err := conversations.Invites(bob.ID, nil, 10)
+	must(t, "Invites", err)
+	if !sameIDs(inviteIDs(invites), "i-3") {
+		t.Errorf("Want i-3 still pending, got %v", inviteIDs(invites))
+	}
+
+	// Purged ones can be sent anew
+	invite("i-4", first.ID, carol.ID, time.Hour)
+	invites, err = conversations.ConversationInvites(first.ID, nil, 10)
+	must(t, "ConversationInvites", err)
+	if !sameIDs(inviteIDs(invites), "i-4") {
+		t.Errorf("Want i-4 pending in %s, got %v", first.ID, inviteIDs(invites))
+	}
+}
+
+func testTx(t *testing.T, s store.Store) {
+	alice := register(t, s, "1", "Alice", "")
+
+	// Rolled back
+	tx, err := s.Begin()
+	must(t, "Begin", err)
+	conversation := store.Conversation{ID: "c-1"}
+	must(t, "Create", tx.Conversations().Create(&conversation, alice.ID))
+	_, err = tx.Conversations().Get(alice.ID, conversation.ID)
+	must(t, "Get in tx", err)
+	must(t, "Enqueue", tx.Enqueue("core.v1.conversation.created", alice.ID, &conversation))
+	must(t, "Rollback", tx.Rollback())
+	_, err = s.Conversations().Get(alice.ID, conversation.ID)
+	wantErr(t, "Get rolled back", err, store.ErrNotFound)
+
+	// Committed
+	tx, err = s.Begin()
+	must(t, "Begin", err)
+	defer tx.Rollback()
+	must(t, "Create", tx.Conversations().Create(&conversation, alice.ID))
+	_, err = tx.Conversations().Role(alice.ID, conversation.ID)
+	must(t, "Role in tx", err)
+	must(t, "Commit", tx.Commit())
+	must(t, "Rollback after Commit", tx.Rollback())
+	_, err = s.Conversations().Get(alice.ID, conversation.ID)
+	must(t, "Get committed", err)
+}
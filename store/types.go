@@ -0,0 +1,149 @@
+package store
+
+import (
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+type Contact struct {
+	UserA string `json:"usera"` // First user ID
+	UserB string `json:"userb"` // Second user ID
+}
+
+// Member roles, from most to least privileged
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Notification levels of a member
+const (
+	NotifyAll      = "all"      // every message
+	NotifyMentions = "mentions" // only messages mentioning the member
+	NotifyNone     = "none"     // nothing
+)
+
+type Member struct {
+	User         string `json:"user"`
+	Conversation string `json:"conversation"`
+	Pinned       bool   `json:"pinned"`
+	Role         string `json:"role"`
+}
+
+// Invite is a pending invitation of a user into a conversation by one of its
+// admins
+type Invite struct {
+	ID           string      `json:"id"`           // id
+	Conversation string      `json:"conversation"` // conversation
+	Title        null.String `json:"title"`        // title of the conversation
+	User         string      `json:"user"`         // invited user
+	Inviter      string      `json:"inviter"`      // inviting user
+	CreatedAt    time.Time   `json:"created_at"`   // created_at
+	ExpiresAt    time.Time   `json:"expires_at"`   // expires_at
+}
+
+// MemberPreferences is how a member wants to see and hear a conversation
+type MemberPreferences struct {
+	User          string    `json:"user"`
+	Conversation  string    `json:"conversation"`
+	Archived      bool      `json:"archived"`
+	MutedUntil    null.Time `json:"muted_until"`   // muted until then, null when not muted
+	Notifications string    `json:"notifications"` // one of the notification levels
+}
+
+// PreferenceChanges are the preferences of a member to change. Null ones,
+// and MutedUntil unless Mute, stay as they are.
+type PreferenceChanges struct {
+	Archived      null.Bool
+	Mute          bool // change MutedUntil, null unmuting
+	MutedUntil    null.Time
+	Notifications null.String
+}
+
+// InviteLink lets anyone holding its token join a conversation, until it runs
+// out of uses, expires or is revoked
+type InviteLink struct {
+	Token        string    `json:"token"`        // token
+	Conversation string    `json:"conversation"` // conversation
+	Creator      string    `json:"creator"`      // creator
+	UsesLeft     null.Int  `json:"uses_left"`    // uses_left, null for unlimited
+	ExpiresAt    null.Time `json:"expires_at"`   // expires_at, null for never
+	CreatedAt    time.Time `json:"created_at"`   // created_at
+}
+
+type Conversation struct {
+	ID            string      `json:"id"`            // id
+	Title         null.String `json:"title"`         // title
+	DM            bool        `json:"dm"`            // dm
+	Picture       null.String `json:"picture"`       // picture
+	Pinned        bool        `json:"pinned"`        // pinned
+	Role          string      `json:"role"`          // role
+	LastHeard     null.Int    `json:"lastheard"`     // lastheard
+	Archived      bool        `json:"archived"`      // archived
+	MutedUntil    null.Time   `json:"muted_until"`   // muted_until
+	Notifications string      `json:"notifications"` // notifications
+	ActiveAt      time.Time   `json:"-"`             // active_at, for paging
+}
+
+// Trashed is a deleted conversation, and who deleted it
+type Trashed struct {
+	Conversation string // id
+	By           string // deleted_by, empty if they are gone
+}
+
+type User struct {
+	ID          string      `json:"id"`           // id
+	Username    null.String `json:"username"`     // username
+	Bio         string      `json:"bio"`          // bio
+	ProfilePic  string      `json:"profile_pic"`  // profile_pic
+	FirstName   string      `json:"first_name"`   // first_name
+	LastName    string      `json:"last_name"`    // last_name
+	PhoneNumber string      `json:"phone_number"` // phone_number
+}
+
+// Registered reports whether user signed up, rather than being a placeholder
+// created when someone added their phone number as a contact
+func (user User) Registered() bool {
+	return user.FirstName != ""
+}
+
+type DiscoveredUser struct {
+	Hash string `json:"hash"` // whole discovery hash, for clients to match
+	User User   `json:"user"` // user
+}
+
+// PhoneHashPrefixSize is how many bytes of the discovery hash of their phone
+// number users are discovered by. Keep in sync with the
+// user_phone_hash_prefix index.
+const PhoneHashPrefixSize = 2
+
+// SearchResult is a user found by a search, with what it is ranked by
+type SearchResult struct {
+	User
+	Contact bool    // the user is a contact of the viewer
+	Prefix  bool    // a name of the user starts with the query
+	Score   float64 // similarity of the closest name to the query
+}
+
+// Who can see a private field of a user
+const (
+	VisibilityEveryone = "everyone"
+	VisibilityContacts = "contacts" // users in the user's contacts
+	VisibilityNobody   = "nobody"
+)
+
+type Privacy struct {
+	PhoneNumber string `json:"phone_number"` // phone_number_visibility
+	Bio         string `json:"bio"`          // bio_visibility
+	ProfilePic  string `json:"profile_pic"`  // profile_pic_visibility
+}
+
+// Relation is how a viewer relates to a user, and what the user lets others
+// see
+type Relation struct {
+	Privacy Privacy
+	Contact bool // the user has the viewer as a contact
+	Saved   bool // the viewer has the user as a contact
+}
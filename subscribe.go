@@ -9,40 +9,77 @@ import (
 )
 
 func (h *Handler) SubscribeContact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	Subscribe(h.contactConnections, w, r, p)
+	userID := r.Context().Value("user").(string)
+
+	h.Subscribe(contactTopic, userID, w, r)
 }
 
 func (h *Handler) SubscribeConversation(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	Subscribe(h.conversationConnections, w, r, p)
+	userID := r.Context().Value("user").(string)
+
+	err := h.permissions.Acquire(userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer h.permissions.Release(userID)
+
+	h.Subscribe(conversationTopic, userID, w, r)
 }
 
 func (h *Handler) SubscribeUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	Subscribe(h.userConnections, w, r, p)
+	userID := r.Context().Value("user").(string)
+
+	h.Subscribe(userTopic, userID, w, r)
 }
 
 func (h *Handler) SubscribeMember(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	userID := r.Context().Value("user").(string)
 	conversation := p.ByName("conversation")
-	if _, ok := h.memberConnections[conversation]; !ok {
-		h.memberConnections[conversation] = make(map[string]chan []byte)
+
+	err := h.permissions.Acquire(userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
+	defer h.permissions.Release(userID)
 
-	Subscribe(h.memberConnections[conversation], w, r, p)
+	// Check
+	if !h.permissions.Member(userID, conversation) {
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	}
+
+	h.Subscribe(memberTopic(conversation), userID, w, r)
 }
 
-func Subscribe(channels map[string]chan []byte, w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+func (h *Handler) SubscribeInvite(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	userID := r.Context().Value("user").(string)
+
+	h.Subscribe(inviteTopic, userID, w, r)
+}
+
+func (h *Handler) Subscribe(topic string, userID string, w http.ResponseWriter, r *http.Request) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeStatus(w, r, http.StatusInternalServerError)
 		return
 	}
 
+	// Leave out events of users who blocked this one
+	err := h.blocks.Acquire(userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer h.blocks.Release(userID)
+
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	id := RandomHex()
-	recv := make(chan []byte)
-	channels[id] = recv
+	client := h.hub.Register(topic, userID)
+	defer h.hub.Unregister(topic, client)
 
 	// Refresh connection periodically
 	resClosed := w.(http.CloseNotifier).CloseNotify()
@@ -50,14 +87,17 @@ func Subscribe(channels map[string]chan []byte, w http.ResponseWriter, r *http.R
 
 	for {
 		select {
-		case msg := <-recv:
+		case msg := <-client.Messages():
 			fmt.Fprintf(w, "data: %s\n\n", msg)
 			flusher.Flush()
 		case <-ticker.C:
 			w.Write([]byte(":\n\n"))
+		case <-client.Done():
+			// Too slow to keep up, let the client reconnect
+			ticker.Stop()
+			return
 		case <-resClosed:
 			ticker.Stop()
-			delete(channels, id)
 			return
 		}
 	}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
+	"encoding/json"
+	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
 )
 
 func assertCode(t *testing.T, w *httptest.ResponseRecorder, code int) {
@@ -23,3 +29,50 @@ func assertDB(t *testing.T, db *sql.DB, query string, args ...interface{}) {
 		t.Errorf("Want one result, found none for query %s", query)
 	}
 }
+
+func assertNoDB(t *testing.T, db *sql.DB, query string, args ...interface{}) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Errorf("Error during query %s: %s", query, err)
+		return
+	}
+	defer rows.Close()
+	if rows.Next() {
+		t.Errorf("Want no results, found some for query %s", query)
+	}
+}
+
+// serve sends a request as userID, with body marshalled to JSON if not nil
+func serve(router http.Handler, method string, target string, body interface{}, userID string) *httptest.ResponseRecorder {
+	b := []byte{}
+	if body != nil {
+		b, _ = json.Marshal(body)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, target, bytes.NewBuffer(b))
+	if userID != "" {
+		claim, _ := json.Marshal(&RawClient{UserId: userID, ClientId: "test"})
+		r.Header.Add("X-User-Claim", string(claim))
+	}
+
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func runNatsServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   server.RANDOM_PORT,
+		NoLog:  true,
+		NoSigs: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("Embedded nats-server did not start")
+	}
+	return s
+}
@@ -1,38 +1,68 @@
 package main
 
-import "gopkg.in/guregu/null.v3"
+import (
+	"backend/core/store"
+)
 
-type UpdateMsg struct {
-	Type string `json:"type"`
-	Data string `json:"data"`
-}
+// Users, contacts and conversations are kept in the store
+type (
+	Contact           = store.Contact
+	Conversation      = store.Conversation
+	DiscoveredUser    = store.DiscoveredUser
+	Invite            = store.Invite
+	InviteLink        = store.InviteLink
+	Member            = store.Member
+	MemberPreferences = store.MemberPreferences
+	Privacy           = store.Privacy
+	User              = store.User
+)
 
-type Contact struct {
-	UserA string `json:"usera"` // First user ID
-	UserB string `json:"userb"` // Second user ID
-}
+// Member roles, from most to least privileged
+const (
+	RoleOwner  = store.RoleOwner
+	RoleAdmin  = store.RoleAdmin
+	RoleMember = store.RoleMember
+)
+
+// Notification levels of a member
+const (
+	NotifyAll      = store.NotifyAll
+	NotifyMentions = store.NotifyMentions
+	NotifyNone     = store.NotifyNone
+)
 
-type Member struct {
+// LastHeard is how far a member has heard a conversation, in milliseconds
+// since the Unix epoch
+type LastHeard struct {
 	User         string `json:"user"`
 	Conversation string `json:"conversation"`
-	Pinned       bool   `json:"pinned"`
+	LastHeard    int64  `json:"lastheard"`
+}
+
+// Results of syncing a phone number into the user's contacts
+const (
+	ContactSyncInvalid    = "invalid"    // not a phone number
+	ContactSyncContact    = "contact"    // already a contact
+	ContactSyncNew        = "new"        // not on the platform yet, added as a contact
+	ContactSyncRegistered = "registered" // a registered user, added as a contact
+	ContactSyncSelf       = "self"       // the user's own phone number
+	ContactSyncUnmatched  = "unmatched"  // a user who can't be added, blocking the user for instance
+)
+
+type ContactSync struct {
+	DefaultRegion string   `json:"default_region"` // region numbers without a country code are in
+	PhoneNumbers  []string `json:"phone_numbers"`  // phone numbers, as written in the address book
 }
 
-type Conversation struct {
-	ID      string      `json:"id"`      // id
-	Title   null.String `json:"title"`   // title
-	Picture null.String `json:"picture"` // picture
-	Pinned  bool        `json:"pinned"`  // pinned
+type ContactSyncResult struct {
+	PhoneNumber string `json:"phone_number"` // phone number, as supplied
+	Normalized  string `json:"normalized"`   // normalized phone number, empty if invalid
+	User        string `json:"user"`         // contact's user ID, empty unless added or already a contact
+	Status      string `json:"status"`       // invalid|contact|new|registered|self|unmatched
 }
 
-type User struct {
-	ID          string      `json:"id"`           // id
-	Username    null.String `json:"username"`     // username
-	Bio         string      `json:"bio"`          // bio
-	ProfilePic  string      `json:"profile_pic"`  // profile_pic
-	FirstName   string      `json:"first_name"`   // first_name
-	LastName    string      `json:"last_name"`    // last_name
-	PhoneNumber string      `json:"phone_number"` // phone_number
+type PhoneHashPrefixes struct {
+	Prefixes []string `json:"prefixes"` // hex encoded prefixes of discovery hashes, see HashPhone
 }
 
 type PhoneNumber struct {
@@ -1,31 +1,40 @@
 package main
 
 import (
-	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/julienschmidt/httprouter"
+	"gopkg.in/guregu/null.v3"
+
+	"backend/core/event"
+	"backend/core/store"
 )
 
+var errUsernameTaken = &Error{Status: http.StatusConflict, Code: CodeUsernameTaken, Message: "username is taken by another user"}
+
 func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	// Parse
 	user := User{}
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&user)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeError(w, r, malformed(err))
 		return
 	}
 
 	// Validate
-	phone, err := ParsePhone(user.PhoneNumber)
-	if err != nil || len(user.FirstName) < 1 || len(user.LastName) < 1 {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	err = validateUser(&user, true)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	user.PhoneNumber = phone // shouldn't be needed but makes life easier
 
 	// Generate ID
 	id := "u-" + RandomHex()
@@ -35,36 +44,39 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request, _ httproute
 	log.Print(user)
 
 	// Insert
-	var finalId string
-	err = h.db.QueryRow(`
-		INSERT INTO "user" (id, username, bio, profile_pic, first_name, last_name, phone_number)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
-			ON CONFLICT(phone_number)
-			DO UPDATE SET phone_number=EXCLUDED.phone_number, username=$2, first_name=$5, last_name=$6
-			RETURNING id
-	`, user.ID, user.Username, user.Bio, user.ProfilePic, user.FirstName, user.LastName, user.PhoneNumber).Scan(&finalId)
-	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
-		return
-	}
-	user.ID = finalId
-
-	// Publish NATs
-	if h.nc != nil {
-		userString, err := json.Marshal(&user)
-		if err == nil {
-			updateMsg := UpdateMsg{
-				Type: "add",
-				Data: string(userString),
-			}
-			updateMsgString, err := json.Marshal(&updateMsg)
-			if err == nil {
-				h.nc.Publish("user", updateMsgString)
-			}
-		}
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	privacy, err := tx.Users().Register(&user)
+	switch {
+	case err == store.ErrConflict:
+		writeError(w, r, errUsernameTaken)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs, user events are for everyone
+	public := user
+	applyPrivacy(&public, privacy, audience{})
+	err = tx.Enqueue(event.UserCreated, user.ID, &public)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
 	// Respond
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
@@ -72,25 +84,30 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request, _ httproute
 
 func (h *Handler) GetUserByPhone(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	// Parse
+	viewerID := r.Context().Value("user").(string)
 	phone, err := ParsePhone(r.FormValue("phone_number"))
 
 	// Validate
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeStatus(w, r, http.StatusBadRequest)
 		return
 	}
 
-	// Response object
-	user := User{}
-
 	// Select
-	err = h.db.QueryRow(`
-		SELECT id, username, bio, profile_pic, first_name, last_name, phone_number FROM "user" WHERE phone_number = $1
-	`, phone).Scan(&user.ID, &user.Username, &user.Bio, &user.ProfilePic, &user.FirstName, &user.LastName, &user.PhoneNumber)
+	user, err := h.store.Users().ByPhone(phone, viewerID)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
 
+	// Shape
+	err = shapeUser(h.store.Users(), viewerID, &user)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
 		return
 	}
 
@@ -101,23 +118,24 @@ func (h *Handler) GetUserByPhone(w http.ResponseWriter, r *http.Request, _ httpr
 
 func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	// Parse
+	viewerID := r.Context().Value("user").(string)
 	userID := p.ByName("user")
 
-	// Response object
-	user := User{}
-
 	// Select
-	err := h.db.QueryRow(`
-		SELECT id, username, bio, profile_pic, first_name, last_name, phone_number FROM "user" WHERE id = $1
-	`, userID).Scan(&user.ID, &user.Username, &user.Bio, &user.ProfilePic, &user.FirstName, &user.LastName, &user.PhoneNumber)
-
+	user, err := h.store.Users().Get(userID)
 	switch {
-	case err == sql.ErrNoRows:
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
 		return
 	case err != nil:
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
+		return
+	}
+
+	// Shape
+	err = shapeUser(h.store.Users(), viewerID, &user)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -128,23 +146,24 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request, p httprouter.P
 
 func (h *Handler) GetUserByUsername(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	// Parse
-	username := p.ByName("username")
-
-	// Response object
-	user := User{}
+	viewerID := r.Context().Value("user").(string)
+	username := NormalizeUsername(null.StringFrom(p.ByName("username"))).String
 
 	// Select
-	err := h.db.QueryRow(`
-		SELECT id, username, bio, profile_pic, first_name, last_name, phone_number FROM "user" WHERE username = $1
-	`, username).Scan(&user.ID, &user.Username, &user.Bio, &user.ProfilePic, &user.FirstName, &user.LastName, &user.PhoneNumber)
-
+	user, err := h.store.Users().ByUsername(username, viewerID)
 	switch {
-	case err == sql.ErrNoRows:
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
 		return
 	case err != nil:
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
+		writeError(w, r, err)
+		return
+	}
+
+	// Shape
+	err = shapeUser(h.store.Users(), viewerID, &user)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -160,41 +179,179 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request, p httproute
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&user)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeError(w, r, malformed(err))
+		return
+	}
+
+	// Validate
+	err = validateUser(&user, false)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Update
-	_, err = h.db.Exec(`
-		UPDATE "user"
-		SET 
-		username = $2,
-		bio = $3,
-		profile_pic = $4,
-		first_name = $5,
-		last_name = $6
-		WHERE id = $1
-	`, userID, user.Username, user.Bio, user.ProfilePic, user.FirstName, user.LastName)
-	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		log.Print(err)
-		return
-	}
-
-	// Publish NATs
-	if h.nc != nil {
-		userString, err := json.Marshal(&user)
-		if err == nil {
-			updateMsg := UpdateMsg{
-				Type: "update",
-				Data: string(userString),
-			}
-			updateMsgString, err := json.Marshal(&updateMsg)
-			if err == nil {
-				h.nc.Publish("user", updateMsgString)
-			}
-		}
+	tx, err := h.store.Begin()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	user.ID = userID
+	privacy, err := tx.Users().Update(&user)
+	switch {
+	case err == store.ErrNotFound:
+		writeStatus(w, r, http.StatusNotFound)
+		return
+	case err == store.ErrConflict:
+		writeError(w, r, errUsernameTaken)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	// Publish NATs, user events are for everyone
+	applyPrivacy(&user, privacy, audience{})
+	err = tx.Enqueue(event.UserUpdated, userID, &user)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.outbox.Wake()
+
 	w.WriteHeader(200)
 }
+
+// Most phone hash prefixes discovered in one request
+const maxDiscoverPrefixes = 5000
+
+func (h *Handler) DiscoverUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	phonePrefixes := PhoneHashPrefixes{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&phonePrefixes)
+	if err != nil {
+		writeError(w, r, malformed(err))
+		return
+	}
+
+	// Validate, only prefixes keep address books from the server
+	if len(phonePrefixes.Prefixes) > maxDiscoverPrefixes {
+		writeError(w, r, invalid(FieldError{"prefixes", FieldTooMany, fmt.Sprintf("at most %d", maxDiscoverPrefixes)}))
+		return
+	}
+	prefixes := make([][]byte, len(phonePrefixes.Prefixes))
+	for i, prefix := range phonePrefixes.Prefixes {
+		b, err := hex.DecodeString(prefix)
+		if err != nil || len(b) != store.PhoneHashPrefixSize {
+			writeError(w, r, invalid(FieldError{fmt.Sprintf("prefixes[%d]", i), FieldInvalid, fmt.Sprintf("not %d hex encoded bytes", store.PhoneHashPrefixSize)}))
+			return
+		}
+		prefixes[i] = b
+	}
+
+	// Select, users who blocked the caller can't be found
+	users, err := h.store.Users().Discover(prefixes, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Shape
+	shaped := make([]User, len(users))
+	for i, user := range users {
+		shaped[i] = user.User
+	}
+	err = shapeUsers(h.store.Users(), userID, shaped)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for i := range users {
+		users[i].User = shaped[i]
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// Longest search query, in characters
+const maxSearchLength = 64
+
+func (h *Handler) SearchUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	// Parse
+	userID := r.Context().Value("user").(string)
+	query := strings.TrimSpace(r.FormValue("q"))
+	page, err := ParsePage(r, 4)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Validate
+	if len(query) < 1 || utf8.RuneCountInString(query) > maxSearchLength {
+		writeError(w, r, invalid(FieldError{"q", FieldInvalid, fmt.Sprintf("between 1 and %d characters", maxSearchLength)}))
+		return
+	}
+
+	// Validate cursor, contacts first, then prefix matches, then by similarity
+	var after *store.SearchResult
+	if !page.First() {
+		after = &store.SearchResult{}
+		after.Contact, err = strconv.ParseBool(page.Key(0, ""))
+		if err != nil {
+			writeError(w, r, errInvalidPage)
+			return
+		}
+		after.Prefix, err = strconv.ParseBool(page.Key(1, ""))
+		if err != nil {
+			writeError(w, r, errInvalidPage)
+			return
+		}
+		after.Score, err = strconv.ParseFloat(page.Key(2, ""), 64)
+		if err != nil {
+			writeError(w, r, errInvalidPage)
+			return
+		}
+		after.ID = page.Key(3, "")
+	}
+
+	// Select registered users other than the caller who haven't blocked them
+	results, err := h.store.Users().Search(userID, query, after, page.Fetch())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Response object
+	users := make([]User, 0)
+	for _, result := range results {
+		if page.More(len(users) + 1) {
+			last := results[len(users)-1]
+			SetNext(w, r, page, strconv.FormatBool(last.Contact), strconv.FormatBool(last.Prefix), strconv.FormatFloat(last.Score, 'g', -1, 64), last.ID)
+			break
+		}
+		users = append(users, result.User)
+	}
+
+	// Shape
+	err = shapeUsers(h.store.Users(), userID, users)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Respond
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
@@ -11,6 +11,8 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+
+	"backend/core/store"
 )
 
 func TestUser(t *testing.T) {
@@ -23,6 +25,9 @@ func TestUser(t *testing.T) {
 	t.Run("GetUserByPhone", testGetUserByPhone(db, r))
 	t.Run("GetUser", testGetUser(db, r))
 	t.Run("UpdateUser", testUpdateUser(db, r))
+	t.Run("Discover", testDiscoverUsers(db, r))
+	t.Run("Search", testSearchUsers(db, r))
+	t.Run("Privacy", testPrivacy(db, r))
 }
 
 func testCreateUser(db *sql.DB, router http.Handler) func(t *testing.T) {
@@ -77,6 +82,8 @@ func testGetUserByPhone(db *sql.DB, router http.Handler) func(t *testing.T) {
 		// Test
 		w := httptest.NewRecorder()
 		r := httptest.NewRequest("GET", "/user?phone_number=%2B6599999998", nil)
+		claim, _ := json.Marshal(&RawClient{UserId: createdUser.ID, ClientId: "test"})
+		r.Header.Add("X-User-Claim", string(claim))
 
 		router.ServeHTTP(w, r)
 		assertCode(t, w, 200)
@@ -112,6 +119,8 @@ func testGetUser(db *sql.DB, router http.Handler) func(t *testing.T) {
 		// Test
 		w := httptest.NewRecorder()
 		r := httptest.NewRequest("GET", "/user/id/"+createdUser.ID, nil)
+		claim, _ := json.Marshal(&RawClient{UserId: createdUser.ID, ClientId: "test"})
+		r.Header.Add("X-User-Claim", string(claim))
 
 		router.ServeHTTP(w, r)
 		assertCode(t, w, 200)
@@ -163,6 +172,7 @@ func testUpdateUser(db *sql.DB, router http.Handler) func(t *testing.T) {
 		// Assert
 		wt := httptest.NewRecorder()
 		rt := httptest.NewRequest("GET", "/user/id/"+createdUser.ID, nil)
+		rt.Header.Add("X-User-Claim", string(claim))
 
 		router.ServeHTTP(wt, rt)
 
@@ -174,3 +184,173 @@ func testUpdateUser(db *sql.DB, router http.Handler) func(t *testing.T) {
 
 	}
 }
+
+func testDiscoverUsers(db *sql.DB, router http.Handler) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup a registered user, a placeholder, and someone looking for them
+		w := serve(router, "POST", "/user", &User{PhoneNumber: "+65 9999 2001", FirstName: "Test", LastName: "Discovered"}, "")
+		assertCode(t, w, 200)
+		registered := User{}
+		json.NewDecoder(w.Body).Decode(&registered)
+		w = serve(router, "POST", "/user", &User{PhoneNumber: "+65 9999 2002", FirstName: "Test", LastName: "Discoverer"}, "")
+		assertCode(t, w, 200)
+		discoverer := User{}
+		json.NewDecoder(w.Body).Decode(&discoverer)
+		assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: "+65 9999 2003"}, discoverer.ID), 200)
+
+		hashes := []string{}
+		prefixes := []string{}
+		for _, phone := range []string{"+6599992001", "+6599992003", "+6599992004"} {
+			hash, _ := HashPhone(phone)
+			hashes = append(hashes, hash)
+			prefixes = append(prefixes, hash[:store.PhoneHashPrefixSize*2])
+		}
+
+		// Test
+		w = serve(router, "POST", "/user/discover", &PhoneHashPrefixes{Prefixes: prefixes}, discoverer.ID)
+		assertCode(t, w, 200)
+
+		// Assert, the registered user is found by their whole hash, without
+		// their phone number since they don't have the discoverer as a
+		// contact. Other users may share the prefix.
+		got := make([]DiscoveredUser, 0)
+		json.NewDecoder(w.Body).Decode(&got)
+		registered.PhoneNumber = ""
+		found := false
+		for _, discovered := range got {
+			if discovered.Hash == hashes[0] {
+				found = true
+				if diff := cmp.Diff(discovered, DiscoveredUser{Hash: hashes[0], User: registered}); len(diff) != 0 {
+					t.Error(diff)
+				}
+			}
+			if discovered.Hash == hashes[1] {
+				t.Errorf("Want placeholders left out, got %v", discovered)
+			}
+		}
+		if !found {
+			t.Errorf("Want %s found, got %v", registered.ID, got)
+		}
+
+		// Prefixes have to be the right size
+		assertCode(t, serve(router, "POST", "/user/discover", &PhoneHashPrefixes{Prefixes: []string{hashes[0]}}, discoverer.ID), 400)
+
+	}
+}
+
+func testSearchUsers(db *sql.DB, router http.Handler) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup
+		found := []User{}
+		for i, phone := range []string{"+65 9999 3001", "+65 9999 3002"} {
+			w := serve(router, "POST", "/user", &User{PhoneNumber: phone, FirstName: "Searchable", LastName: []string{"Person A", "Person B"}[i]}, "")
+			assertCode(t, w, 200)
+			user := User{}
+			json.NewDecoder(w.Body).Decode(&user)
+			found = append(found, user)
+		}
+		w := serve(router, "POST", "/user", &User{PhoneNumber: "+65 9999 3003", FirstName: "Test", LastName: "Searcher"}, "")
+		assertCode(t, w, 200)
+		searcher := User{}
+		json.NewDecoder(w.Body).Decode(&searcher)
+
+		// The second one is a contact
+		assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: found[1].PhoneNumber}, searcher.ID), 200)
+
+		search := func(target string) ([]User, http.Header) {
+			w := serve(router, "GET", target, nil, searcher.ID)
+			assertCode(t, w, 200)
+			users := []User{}
+			json.NewDecoder(w.Body).Decode(&users)
+			return users, w.Header()
+		}
+
+		// Prefix, contacts first, one at a time
+		first, header := search("/user/search?q=SEARCHAB&limit=1")
+		second, _ := search("/user/search?q=SEARCHAB&limit=1&cursor=" + header.Get("X-Next-Cursor"))
+		if len(first) != 1 || len(second) != 1 {
+			t.Fatalf("Want a user per page, got %v and %v", first, second)
+		}
+		if first[0].ID != found[1].ID || first[0].PhoneNumber != found[1].PhoneNumber {
+			t.Errorf("Want the contact first with their phone number, got %v", first[0])
+		}
+		if second[0].ID != found[0].ID || second[0].PhoneNumber != "" {
+			t.Errorf("Want the other user next without a phone number, got %v", second[0])
+		}
+
+		// Fuzzy
+		users, _ := search("/user/search?q=searchable+persn+a")
+		if len(users) < 1 || users[0].ID != found[1].ID {
+			t.Errorf("Want a fuzzy match, got %v", users)
+		}
+
+		// Wildcards are matched literally
+		users, _ = search("/user/search?q=%25")
+		if len(users) != 0 {
+			t.Errorf("Want no users for %%, got %v", users)
+		}
+
+		assertCode(t, serve(router, "GET", "/user/search", nil, searcher.ID), 400)
+
+	}
+}
+
+func testPrivacy(db *sql.DB, router http.Handler) func(t *testing.T) {
+	return func(t *testing.T) {
+
+		// Setup, a user with a friend in their contacts and a stranger
+		created := []User{}
+		for i, phone := range []string{"+65 9999 4001", "+65 9999 4002", "+65 9999 4003"} {
+			w := serve(router, "POST", "/user", &User{PhoneNumber: phone, FirstName: "Privacy", LastName: []string{"User", "Friend", "Stranger"}[i]}, "")
+			assertCode(t, w, 200)
+			user := User{}
+			json.NewDecoder(w.Body).Decode(&user)
+			created = append(created, user)
+		}
+		user, friend, stranger := created[0], created[1], created[2]
+		assertCode(t, serve(router, "POST", "/user/contact", &PhoneNumber{PhoneNumber: friend.PhoneNumber}, user.ID), 200)
+		assertCode(t, serve(router, "PATCH", "/user", &User{FirstName: user.FirstName, LastName: user.LastName, Bio: "Secret bio", ProfilePic: "https://example.com/pic.png"}, user.ID), 200)
+
+		get := func(viewer string) User {
+			w := serve(router, "GET", "/user/id/"+user.ID, nil, viewer)
+			assertCode(t, w, 200)
+			got := User{}
+			json.NewDecoder(w.Body).Decode(&got)
+			return got
+		}
+
+		// Defaults, the phone number is for contacts
+		w := serve(router, "GET", "/user/privacy", nil, user.ID)
+		assertCode(t, w, 200)
+		privacy := Privacy{}
+		json.NewDecoder(w.Body).Decode(&privacy)
+		if diff := cmp.Diff(privacy, Privacy{PhoneNumber: VisibilityContacts, Bio: VisibilityEveryone, ProfilePic: VisibilityEveryone}); len(diff) != 0 {
+			t.Error(diff)
+		}
+		if got := get(friend.ID); got.PhoneNumber != user.PhoneNumber || got.Bio != "Secret bio" {
+			t.Errorf("Want a contact to see everything, got %v", got)
+		}
+		if got := get(stranger.ID); got.PhoneNumber != "" || got.Bio != "Secret bio" {
+			t.Errorf("Want a stranger to see the bio only, got %v", got)
+		}
+
+		// Hide the bio from everyone and the picture from strangers
+		privacy = Privacy{PhoneNumber: VisibilityEveryone, Bio: VisibilityNobody, ProfilePic: VisibilityContacts}
+		assertCode(t, serve(router, "PUT", "/user/privacy", &privacy, user.ID), 200)
+		if got := get(friend.ID); got.Bio != "" || got.ProfilePic == "" {
+			t.Errorf("Want a contact to see the picture but not the bio, got %v", got)
+		}
+		if got := get(stranger.ID); got.PhoneNumber != user.PhoneNumber || got.Bio != "" || got.ProfilePic != "" {
+			t.Errorf("Want a stranger to see the phone number only, got %v", got)
+		}
+		if got := get(user.ID); got.Bio != "Secret bio" {
+			t.Errorf("Want users to see all of their own profile, got %v", got)
+		}
+
+		assertCode(t, serve(router, "PUT", "/user/privacy", &Privacy{PhoneNumber: "everyone", Bio: "friends", ProfilePic: "nobody"}, user.ID), 400)
+		assertCode(t, serve(router, "GET", "/user/id/"+user.ID, nil, ""), 400)
+
+	}
+}
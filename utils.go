@@ -2,7 +2,9 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"strings"
 
 	"github.com/ttacon/libphonenumber"
 )
@@ -17,9 +19,35 @@ func RandomHex() string {
 }
 
 func ParsePhone(phone string) (string, error) {
-	num, err := libphonenumber.Parse(phone, "")
+	return ParsePhoneRegion(phone, "")
+}
+
+// ParsePhoneRegion is ParsePhone for numbers that may be written the way they
+// are dialled in region, e.g. "SG"
+func ParsePhoneRegion(phone string, region string) (string, error) {
+	num, err := libphonenumber.Parse(phone, strings.ToUpper(region))
 	if err != nil {
 		return "", err
 	}
 	return libphonenumber.Format(num, libphonenumber.INTERNATIONAL), nil
 }
+
+// HashPhone returns the hex encoded discovery hash of a phone number, the
+// SHA-256 of its E.164 form, as the hash_phone_number trigger computes it.
+//
+// There are few enough phone numbers to hash every one of them, so whoever
+// has the hash of a number can tell what it is. Clients only ever send the
+// first store.PhoneHashPrefixSize bytes, which tens of thousands of numbers
+// share, and match the whole hashes of the users found themselves. That way
+// the server can't tell which numbers are in an address book. In turn,
+// clients learn the numbers of the users sharing a prefix with any number
+// they ask for, other than those who blocked them: asking for every prefix
+// tells the number of every user.
+func HashPhone(phone string) (string, error) {
+	num, err := libphonenumber.Parse(phone, "")
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(libphonenumber.Format(num, libphonenumber.E164)))
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,54 @@
+// +build unit
+
+package main
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestParsePhoneRegion(t *testing.T) {
+	tests := []struct {
+		phone  string
+		region string
+		want   string
+		valid  bool
+	}{
+		{"+65 9999 1102", "", "+65 9999 1102", true},
+		{"+6599991102", "", "+65 9999 1102", true},
+		{"9999 1102", "SG", "+65 9999 1102", true},
+		{"9999 1102", "sg", "+65 9999 1102", true},
+		{"+1 650-253-0000", "SG", "+1 650-253-0000", true},
+		{"9999 1102", "", "", false},
+		{"not a phone number", "SG", "", false},
+	}
+
+	for _, test := range tests {
+		got, err := ParsePhoneRegion(test.phone, test.region)
+		if (err == nil) != test.valid || got != test.want {
+			t.Errorf("ParsePhoneRegion(%q, %q) = %q, %v; want %q, valid %t", test.phone, test.region, got, err, test.want, test.valid)
+		}
+	}
+}
+
+func TestHashPhone(t *testing.T) {
+	// Every spelling of a number hashes the same
+	want, err := HashPhone("+6599991102")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != sha256.Size*2 {
+		t.Errorf("Want %d hex characters, got %s", sha256.Size*2, want)
+	}
+	for _, phone := range []string{"+65 9999 1102", "+65-9999-1102"} {
+		if got, _ := HashPhone(phone); got != want {
+			t.Errorf("Want %s to hash to %s, got %s", phone, want, got)
+		}
+	}
+
+	// Stored numbers hash the same as what clients send
+	normalized, _ := ParsePhone("+6599991102")
+	if got, _ := HashPhone(normalized); got != want {
+		t.Errorf("Want normalized %s to hash to %s, got %s", normalized, want, got)
+	}
+}
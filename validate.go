@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Lengths of fields, in characters. Those that aren't ours to pick are the
+// lengths of their columns.
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+	maxNameLength     = 65535 // first_name, last_name and title
+	maxBioLength      = 63535 // bio
+	maxURLLength      = 63535 // profile_pic and picture
+)
+
+// Usernames are lowercase letters, digits and underscores, starting with a
+// letter
+var usernamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Regions are ISO 3166-1 alpha-2 codes
+var regionPattern = regexp.MustCompile(`^[A-Za-z]{2}$`)
+
+// reservedUsernames can't be taken, so no one passes for Beep
+var reservedUsernames = map[string]bool{
+	"admin":         true,
+	"administrator": true,
+	"api":           true,
+	"beep":          true,
+	"help":          true,
+	"me":            true,
+	"moderator":     true,
+	"null":          true,
+	"official":      true,
+	"root":          true,
+	"security":      true,
+	"staff":         true,
+	"support":       true,
+	"system":        true,
+	"undefined":     true,
+}
+
+// rule checks a value, returning the code and message of what is wrong with
+// it, or an empty code if nothing is
+type rule func(value string) (code string, message string)
+
+// field is a value of a request with the rules it must follow
+type field struct {
+	name  string
+	value string
+	rules []rule
+}
+
+// validate checks every field, returning the first violated rule of each of
+// them together, or nil if there are none
+func validate(fields ...field) error {
+	violations := make([]FieldError, 0)
+	for _, f := range fields {
+		for _, check := range f.rules {
+			if code, message := check(f.value); code != "" {
+				violations = append(violations, FieldError{f.name, code, message})
+				break
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return invalid(violations...)
+	}
+	return nil
+}
+
+func required(value string) (string, string) {
+	if value == "" {
+		return FieldRequired, "is required"
+	}
+	return "", ""
+}
+
+func minLength(n int) rule {
+	return func(value string) (string, string) {
+		if utf8.RuneCountInString(value) < n {
+			return FieldTooShort, fmt.Sprintf("at least %d characters", n)
+		}
+		return "", ""
+	}
+}
+
+func maxLength(n int) rule {
+	return func(value string) (string, string) {
+		if utf8.RuneCountInString(value) > n {
+			return FieldTooLong, fmt.Sprintf("at most %d characters", n)
+		}
+		return "", ""
+	}
+}
+
+// atMost is the rule of a list of count items, which ignores the value
+func atMost(count int, n int) rule {
+	return func(string) (string, string) {
+		if count > n {
+			return FieldTooMany, fmt.Sprintf("at most %d", n)
+		}
+		return "", ""
+	}
+}
+
+func matches(pattern *regexp.Regexp, message string) rule {
+	return func(value string) (string, string) {
+		if !pattern.MatchString(value) {
+			return FieldInvalid, message
+		}
+		return "", ""
+	}
+}
+
+func notReserved(value string) (string, string) {
+	if reservedUsernames[value] {
+		return FieldReserved, "is reserved"
+	}
+	return "", ""
+}
+
+// webURL allows absolute http and https URLs, or nothing
+func webURL(value string) (string, string) {
+	if value == "" {
+		return "", ""
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return FieldInvalid, "not an http or https URL"
+	}
+	return "", ""
+}
+
+func phoneNumber(value string) (string, string) {
+	if _, err := ParsePhone(value); err != nil {
+		return FieldInvalid, "not a phone number with its country code"
+	}
+	return "", ""
+}
+
+// NormalizeName trims a name and composes its characters, so that it is kept
+// the same however it was typed
+func NormalizeName(name string) string {
+	return norm.NFC.String(strings.TrimSpace(name))
+}
+
+// NormalizeUsername is how usernames are kept and looked up: trimmed and
+// lowercase, or null if empty
+func NormalizeUsername(username null.String) null.String {
+	normalized := strings.ToLower(NormalizeName(username.String))
+	return null.NewString(normalized, username.Valid && normalized != "")
+}
+
+// validateUser normalizes user and checks it, along with its phone number if
+// it is registering
+func validateUser(user *User, registering bool) error {
+	user.FirstName = NormalizeName(user.FirstName)
+	user.LastName = NormalizeName(user.LastName)
+	user.Bio = norm.NFC.String(user.Bio)
+	user.ProfilePic = strings.TrimSpace(user.ProfilePic)
+	user.Username = NormalizeUsername(user.Username)
+
+	fields := make([]field, 0)
+	if registering {
+		fields = append(fields, field{"phone_number", user.PhoneNumber, []rule{required, phoneNumber}})
+	}
+	fields = append(fields, []field{
+		{"first_name", user.FirstName, []rule{required, maxLength(maxNameLength)}},
+		{"last_name", user.LastName, []rule{required, maxLength(maxNameLength)}},
+		{"bio", user.Bio, []rule{maxLength(maxBioLength)}},
+		{"profile_pic", user.ProfilePic, []rule{maxLength(maxURLLength), webURL}},
+	}...)
+	if user.Username.Valid {
+		fields = append(fields, field{"username", user.Username.String, []rule{
+			minLength(minUsernameLength),
+			maxLength(maxUsernameLength),
+			matches(usernamePattern, "only lowercase letters, digits and underscores, starting with a letter"),
+			notReserved,
+		}})
+	}
+
+	err := validate(fields...)
+	if err != nil {
+		return err
+	}
+	if registering {
+		user.PhoneNumber, _ = ParsePhone(user.PhoneNumber)
+	}
+	return nil
+}
+
+// validateConversation normalizes the title and picture of conversation and
+// checks them
+func validateConversation(conversation *Conversation) error {
+	if conversation.Title.Valid {
+		conversation.Title.String = NormalizeName(conversation.Title.String)
+	}
+	if conversation.Picture.Valid {
+		conversation.Picture.String = strings.TrimSpace(conversation.Picture.String)
+	}
+
+	return validate(
+		field{"title", conversation.Title.String, []rule{maxLength(maxNameLength)}},
+		field{"picture", conversation.Picture.String, []rule{maxLength(maxURLLength), webURL}},
+	)
+}
+
+// validateContact checks the phone number a contact is added by
+func validateContact(contact *PhoneNumber) error {
+	return validate(
+		field{"phone_number", contact.PhoneNumber, []rule{required, phoneNumber}},
+	)
+}
+
+// validateContactSync checks an address book, but not the numbers in it
+// which are reported one by one
+func validateContactSync(sync *ContactSync) error {
+	fields := []field{
+		{"phone_numbers", "", []rule{atMost(len(sync.PhoneNumbers), maxSyncContacts)}},
+	}
+	if sync.DefaultRegion != "" {
+		fields = append(fields, field{"default_region", sync.DefaultRegion, []rule{
+			matches(regionPattern, "not a two letter region code"),
+		}})
+	}
+	return validate(fields...)
+}
@@ -0,0 +1,103 @@
+// +build unit
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// violated returns the field and code of each violation in err
+func violated(err error) map[string]string {
+	result := make(map[string]string)
+	if e, ok := err.(*Error); ok {
+		for _, detail := range e.Details {
+			result[detail.Field] = detail.Code
+		}
+	}
+	return result
+}
+
+func TestValidateUser(t *testing.T) {
+	tests := []struct {
+		user User
+		want map[string]string
+	}{
+		{User{FirstName: "Ada", LastName: "Lovelace"}, map[string]string{}},
+		{User{FirstName: " ", LastName: ""}, map[string]string{"first_name": FieldRequired, "last_name": FieldRequired}},
+		{User{FirstName: "Ada", LastName: strings.Repeat("a", maxNameLength+1)}, map[string]string{"last_name": FieldTooLong}},
+		{User{FirstName: "Ada", LastName: "Lovelace", Username: null.StringFrom("ada_l")}, map[string]string{}},
+		{User{FirstName: "Ada", LastName: "Lovelace", Username: null.StringFrom("")}, map[string]string{}},
+		{User{FirstName: "Ada", LastName: "Lovelace", Username: null.StringFrom("ad")}, map[string]string{"username": FieldTooShort}},
+		{User{FirstName: "Ada", LastName: "Lovelace", Username: null.StringFrom(strings.Repeat("a", maxUsernameLength+1))}, map[string]string{"username": FieldTooLong}},
+		{User{FirstName: "Ada", LastName: "Lovelace", Username: null.StringFrom("1ada")}, map[string]string{"username": FieldInvalid}},
+		{User{FirstName: "Ada", LastName: "Lovelace", Username: null.StringFrom("ada.l")}, map[string]string{"username": FieldInvalid}},
+		{User{FirstName: "Ada", LastName: "Lovelace", Username: null.StringFrom("Admin")}, map[string]string{"username": FieldReserved}},
+		{User{FirstName: "Ada", LastName: "Lovelace", ProfilePic: "https://example.com/ada.png"}, map[string]string{}},
+		{User{FirstName: "Ada", LastName: "Lovelace", ProfilePic: "javascript:alert(1)"}, map[string]string{"profile_pic": FieldInvalid}},
+		{User{FirstName: "", LastName: "Lovelace", Username: null.StringFrom("root"), ProfilePic: "/ada.png"}, map[string]string{"first_name": FieldRequired, "username": FieldReserved, "profile_pic": FieldInvalid}},
+	}
+
+	for _, test := range tests {
+		user := test.user
+		got := violated(validateUser(&user, false))
+		if len(got) != len(test.want) {
+			t.Errorf("validateUser(%v) violated %v; want %v", test.user, got, test.want)
+			continue
+		}
+		for field, code := range test.want {
+			if got[field] != code {
+				t.Errorf("validateUser(%v) violated %v; want %v", test.user, got, test.want)
+			}
+		}
+	}
+}
+
+func TestValidateUserNormalizes(t *testing.T) {
+	user := User{
+		FirstName:   " Jose\u0301 ",
+		LastName:    "Nin\u0303o",
+		Username:    null.StringFrom(" Jose_N "),
+		PhoneNumber: "+6599991102",
+	}
+	err := validateUser(&user, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.FirstName != "Jos\u00e9" || user.LastName != "Ni\u00f1o" {
+		t.Errorf("Want composed and trimmed names, got %q %q", user.FirstName, user.LastName)
+	}
+	if user.Username != null.StringFrom("jose_n") {
+		t.Errorf("Want a lowercase username, got %v", user.Username)
+	}
+	if user.PhoneNumber != "+65 9999 1102" {
+		t.Errorf("Want the phone number formatted, got %q", user.PhoneNumber)
+	}
+
+	user.Username = null.StringFrom(" ")
+	validateUser(&user, false)
+	if user.Username.Valid {
+		t.Errorf("Want an empty username to be null, got %v", user.Username)
+	}
+}
+
+func TestValidateConversation(t *testing.T) {
+	conversation := Conversation{Title: null.StringFrom(" Cafe\u0301 "), Picture: null.StringFrom("ftp://example.com/pic.png")}
+	got := violated(validateConversation(&conversation))
+	if len(got) != 1 || got["picture"] != FieldInvalid {
+		t.Errorf("Want the picture invalid, got %v", got)
+	}
+	if conversation.Title.String != "Caf\u00e9" {
+		t.Errorf("Want a composed and trimmed title, got %q", conversation.Title.String)
+	}
+}
+
+func TestValidateContactSync(t *testing.T) {
+	sync := ContactSync{DefaultRegion: "SGP", PhoneNumbers: make([]string, maxSyncContacts+1)}
+	got := violated(validateContactSync(&sync))
+	if len(got) != 2 || got["default_region"] != FieldInvalid || got["phone_numbers"] != FieldTooMany {
+		t.Errorf("Want the region and count invalid, got %v", got)
+	}
+}